@@ -0,0 +1,257 @@
+package chamber_tools
+
+import (
+	"fmt"
+	"github.com/appf-anu/chamber-tools/derived"
+	"github.com/appf-anu/chamber-tools/fixture"
+	"github.com/pkg/errors"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CellError is an error decoding a cell of a conditions file.
+// Row and Column are numbered from 1 like in a spreadsheet, so the header line is row 1.
+type CellError struct {
+	Row    int
+	Column int
+	Header string
+	Err    error
+}
+
+func (e *CellError) Error() string {
+	if e.Row > 0 {
+		return fmt.Sprintf("row %d, column %d (%s): %v", e.Row, e.Column, e.Header, e.Err)
+	}
+	return fmt.Sprintf("column %d (%s): %v", e.Column, e.Header, e.Err)
+}
+
+// Cause returns the underlying error, for errors.Cause
+func (e *CellError) Cause() error {
+	return e.Err
+}
+
+// Unwrap returns the underlying error, for errors.Is and errors.As
+func (e *CellError) Unwrap() error {
+	return e.Err
+}
+
+// CellErrors are the errors decoding each bad cell of a row, in the order of their columns
+type CellErrors []*CellError
+
+func (errs CellErrors) Error() string {
+	messages := make([]string, len(errs))
+	for i, err := range errs {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "; ")
+}
+
+// err returns nil if there are no errors, the *CellError if there is one, and errs if there are several
+func (errs CellErrors) err() error {
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return errs[0]
+	}
+	return errs
+}
+
+// isNullCell returns true if a cell has no target, that is if it is empty, "NULL" or "-"
+func isNullCell(cell string) bool {
+	switch strings.ToUpper(trimField(cell)) {
+	case "", "NULL", "-":
+		return true
+	}
+	return false
+}
+
+// cellUnits are the units that may follow the number in a cell, eg. "25 °C", longest first so that "%RH" isn't read
+// as "RH" after a number ending in "%"
+var cellUnits = []string{"µmol/m²/s", "umol/m2/s", "°C", "%RH", "ppm", "kPa", "RH", "%", "C"}
+
+// parseNumber parses a number from a cell, which may be followed by one of cellUnits like "25 °C". anything else after
+// the number is an error, rather than being dropped.
+func parseNumber(cell string) (float64, error) {
+	if v, err := strconv.ParseFloat(cell, 64); err == nil {
+		return v, nil
+	}
+	for _, unit := range cellUnits {
+		n := len(cell) - len(unit)
+		if n <= 0 || !strings.EqualFold(cell[n:], unit) {
+			continue
+		}
+		if v, err := strconv.ParseFloat(strings.TrimSpace(cell[:n]), 64); err == nil {
+			return v, nil
+		}
+	}
+	return 0, errors.Errorf("%q is not a number", cell)
+}
+
+// decodeFloatCell decodes a cell as a float, null cells are decoded as unset
+func decodeFloatCell(cell string) (NullFloat64, error) {
+	cell = trimField(cell)
+	if isNullCell(cell) {
+		return NullFloat64{}, nil
+	}
+	v, err := parseNumber(cell)
+	if err != nil {
+		return NullFloat64{}, err
+	}
+	return NewNullFloat64(v), nil
+}
+
+// decodeIntCell decodes a cell as an int, null cells are decoded as unset.
+// whole numbers written as floats ("2.0") are accepted.
+func decodeIntCell(cell string) (NullInt, error) {
+	cell = trimField(cell)
+	if isNullCell(cell) {
+		return NullInt{}, nil
+	}
+	if v, err := strconv.Atoi(cell); err == nil {
+		return NewNullInt(v), nil
+	}
+	v, err := parseNumber(cell)
+	if err != nil {
+		return NullInt{}, err
+	}
+	if v != math.Trunc(v) {
+		return NullInt{}, errors.Errorf("%q is not a whole number", cell)
+	}
+	return NewNullInt(int(v)), nil
+}
+
+// decodeTimePoint creates a TimePoint from the text of each cell in a row, the same way for every file type.
+// decodeTime decodes the datetime cell at index i, as the file types store datetimes differently. every cell is
+// decoded, the error is a *CellError if one of them is bad and CellErrors if several are.
+func (indices Indices) decodeTimePoint(errLog *log.Logger, cells []string,
+	decodeTime func(i int) (time.Time, error)) (*TimePoint, error) {
+
+	cell := func(i int) string {
+		if i < 0 || i >= len(cells) {
+			return ""
+		}
+		return cells[i]
+	}
+
+	var errs CellErrors
+	cellError := func(i int, header string, err error) {
+		errs = append(errs, &CellError{Column: i + 1, Header: header, Err: err})
+	}
+
+	tp := &TimePoint{}
+
+	if isNullCell(cell(indices.DatetimeIdx)) {
+		cellError(indices.DatetimeIdx, "datetime", errors.New("empty datetime"))
+	} else if t, err := decodeTime(indices.DatetimeIdx); err != nil {
+		cellError(indices.DatetimeIdx, "datetime", err)
+	} else {
+		tp.Datetime = t
+	}
+
+	if !isNullCell(cell(indices.SimDatetimeIdx)) {
+		t, err := decodeTime(indices.SimDatetimeIdx)
+		if err != nil {
+			errLog.Println("Couldn't get SimDatetime")
+		} else {
+			tp.SimDatetime = t
+		}
+	}
+
+	floats := []struct {
+		name  string
+		idx   int
+		value *NullFloat64
+	}{
+		{"temperature", indices.TemperatureIdx, &tp.Temperature},
+		{"humidity", indices.HumidityIdx, &tp.RelativeHumidity},
+		{"co2", indices.CO2Idx, &tp.CO2},
+		{"totalsolar", indices.TotalSolarIdx, &tp.TotalSolar},
+	}
+	for _, f := range floats {
+		v, err := decodeFloatCell(cell(f.idx))
+		if err != nil {
+			cellError(f.idx, f.name, err)
+		}
+		*f.value = v
+	}
+
+	ints := []struct {
+		name  string
+		idx   int
+		value *NullInt
+	}{
+		{"light1", indices.Light1Idx, &tp.Light1},
+		{"light2", indices.Light2Idx, &tp.Light2},
+	}
+	for _, f := range ints {
+		v, err := decodeIntCell(cell(f.idx))
+		if err != nil {
+			cellError(f.idx, f.name, err)
+		}
+		*f.value = v
+	}
+
+	// vpd is a humidity target that depends on the temperature
+	vpd, err := decodeFloatCell(cell(indices.VPDIdx))
+	switch {
+	case err != nil:
+		cellError(indices.VPDIdx, "vpd", err)
+	case !vpd.Valid:
+	case tp.RelativeHumidity.Valid:
+		cellError(indices.VPDIdx, "vpd", errors.New("humidity and vpd are both set"))
+	case !tp.Temperature.Valid:
+		cellError(indices.VPDIdx, "vpd", errors.New("vpd needs a temperature on the same row"))
+	default:
+		if rh, err := derived.RelativeHumidityFromVPD(tp.Temperature.Float64, vpd.Float64); err != nil {
+			cellError(indices.VPDIdx, "vpd", err)
+		} else {
+			tp.RelativeHumidity = NewNullFloat64(rh)
+		}
+	}
+
+	// do channels
+	for chanNumber, chanIdx := range indices.ChannelsIdx {
+		chanValue, err := decodeFloatCell(cell(chanIdx))
+		if err != nil {
+			cellError(chanIdx, fmt.Sprintf("channel-%d", chanNumber+1), err)
+		}
+		tp.Channels = append(tp.Channels, chanValue)
+	}
+
+	// spectrum is solved into the channels of the fixture
+	if !isNullCell(cell(indices.SpectrumIdx)) {
+		if err := indices.solveSpectrum(tp, trimField(cell(indices.SpectrumIdx))); err != nil {
+			cellError(indices.SpectrumIdx, "spectrum", err)
+		}
+	}
+	if err := errs.err(); err != nil {
+		return nil, err
+	}
+	return tp, nil
+}
+
+// solveSpectrum sets the channels of tp to the spectrum of a cell solved for the fixture
+func (indices Indices) solveSpectrum(tp *TimePoint, cell string) error {
+	if len(indices.ChannelsIdx) > 0 {
+		return errors.New("channels and spectrum are both set")
+	}
+	if indices.Fixture == nil {
+		return errors.New("spectrum needs a fixture profile")
+	}
+	spectrum, err := fixture.ParseSpectrum(cell)
+	if err != nil {
+		return err
+	}
+	values, err := indices.Fixture.Solve(spectrum)
+	if err != nil {
+		return err
+	}
+	for _, v := range values {
+		tp.Channels = append(tp.Channels, NewNullFloat64(v))
+	}
+	return nil
+}
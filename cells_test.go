@@ -0,0 +1,152 @@
+package chamber_tools
+
+import (
+	"github.com/tealeg/xlsx"
+	"testing"
+	"time"
+)
+
+func TestDecodeCells(t *testing.T) {
+	floats := []struct {
+		cell    string
+		want    NullFloat64
+		wantErr bool
+	}{
+		{"", NullFloat64{}, false},
+		{" NULL ", NullFloat64{}, false},
+		{"null", NullFloat64{}, false},
+		{"-", NullFloat64{}, false},
+		{"\t25.5", NewNullFloat64(25.5), false},
+		{"-3", NewNullFloat64(-3), false},
+		{"25 °C", NewNullFloat64(25), false},
+		{"-3 °C", NewNullFloat64(-3), false},
+		{"+1.5c", NewNullFloat64(1.5), false},
+		{"55 %RH", NewNullFloat64(55), false},
+		{"55%", NewNullFloat64(55), false},
+		{"400 ppm", NewNullFloat64(400), false},
+		{"1.2 kPa", NewNullFloat64(1.2), false},
+		{"warm", NullFloat64{}, true},
+		{"2o.5", NullFloat64{}, true},
+		{"25 °C warm", NullFloat64{}, true},
+		{"25 degrees", NullFloat64{}, true},
+		{"°C", NullFloat64{}, true},
+	}
+	for _, test := range floats {
+		got, err := decodeFloatCell(test.cell)
+		if (err != nil) != test.wantErr || got != test.want {
+			t.Errorf("decodeFloatCell(%q) = %v, %v, want %v", test.cell, got, err, test.want)
+		}
+	}
+
+	ints := []struct {
+		cell    string
+		want    NullInt
+		wantErr bool
+	}{
+		{"", NullInt{}, false},
+		{"NULL", NullInt{}, false},
+		{"-", NullInt{}, false},
+		{"2", NewNullInt(2), false},
+		{"2.0", NewNullInt(2), false},
+		{"2.5", NullInt{}, true},
+		{"-1", NewNullInt(-1), false},
+		{"50 %", NewNullInt(50), false},
+		{"on", NullInt{}, true},
+		{"2o", NullInt{}, true},
+	}
+	for _, test := range ints {
+		got, err := decodeIntCell(test.cell)
+		if (err != nil) != test.wantErr || got != test.want {
+			t.Errorf("decodeIntCell(%q) = %v, %v, want %v", test.cell, got, err, test.want)
+		}
+	}
+}
+
+// TestCsvAndXlsxParity loads the csv and xlsx versions of the same program, with empty, NULL and "-" cells, and
+// checks that they decode to the same timepoints, in timezones with daylight saving time in either hemisphere
+func TestCsvAndXlsxParity(t *testing.T) {
+	for _, name := range []string{"Local", "Europe/Berlin", "Australia/Sydney"} {
+		t.Run(name, func(t *testing.T) {
+			loc := inLocation(t, name)
+			testCsvAndXlsxParity(t, loc)
+		})
+	}
+}
+
+func testCsvAndXlsxParity(t *testing.T, loc *time.Location) {
+	csvSchedule, err := LoadSchedule(discardLog, "testdata/parity.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	xlsxSchedule, err := LoadSchedule(discardLog, "testdata/parity.xlsx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if csvSchedule.Len() != 4 || xlsxSchedule.Len() != 4 {
+		t.Fatalf("loaded %d csv and %d xlsx timepoints, want 4 of each", csvSchedule.Len(), xlsxSchedule.Len())
+	}
+	if start := time.Date(2020, 2, 24, 6, 0, 0, 0, loc); !csvSchedule.Start().Equal(start) {
+		t.Errorf("csv starts at %v, want %v", csvSchedule.Start(), start)
+	}
+	for i := range csvSchedule.TimePoints {
+		a, b := csvSchedule.TimePoints[i], xlsxSchedule.TimePoints[i]
+		if !a.Equal(b) {
+			t.Errorf("TimePoint %d differs:\n\tcsv  %s\n\txlsx %s", i, a.NulledString(), b.NulledString())
+		}
+	}
+
+	unset := []struct {
+		row   int
+		value interface{ String() string }
+	}{
+		{1, xlsxSchedule.TimePoints[1].RelativeHumidity},
+		{1, xlsxSchedule.TimePoints[1].CO2},
+		{2, xlsxSchedule.TimePoints[2].Temperature},
+		{2, xlsxSchedule.TimePoints[2].Light2},
+		{2, xlsxSchedule.TimePoints[2].Channels[0]},
+		{3, xlsxSchedule.TimePoints[3].Light1},
+		{3, xlsxSchedule.TimePoints[3].Channels[1]},
+	}
+	for _, u := range unset {
+		if u.value.String() != "NULL" {
+			t.Errorf("TimePoint %d has %s for a null cell, want it unset", u.row, u.value)
+		}
+	}
+	if !xlsxSchedule.TimePoints[3].SimDatetime.IsZero() {
+		t.Errorf("empty datetime-sim decoded as %v", xlsxSchedule.TimePoints[3].SimDatetime)
+	}
+}
+
+// TestXlsxRowDecoding pins down how xlsx cells are read: datetimes are spreadsheet dates taken as wall clock times in
+// time.Local, rounded to the millisecond, for both datetime columns, and numbers are read from the stored value of
+// the cell rather than the text it is formatted as.
+func TestXlsxRowDecoding(t *testing.T) {
+	indices := getIndices(discardLog, []string{"datetime", "datetime-sim", "temperature", "light1"})
+	want := time.Date(2020, 2, 24, 6, 10, 0, 0, time.Local)
+	wantSim := time.Date(2019, 12, 5, 6, 10, 0, 0, time.Local)
+
+	row := (&xlsx.Sheet{}).AddRow()
+	// days since 1900 are stored as floats, which are a few nanoseconds off after a round trip
+	row.AddCell().SetDateTimeWithFormat(
+		xlsx.TimeToExcelTime(time.Date(2020, 2, 24, 6, 10, 0, 0, time.UTC), false)+1e-10, "yyyy-mm-dd hh:mm")
+	row.AddCell().SetDateTime(time.Date(2019, 12, 5, 6, 10, 0, 0, time.UTC))
+	row.AddCell().SetFloatWithFormat(20.5, "0")
+	row.AddCell().SetFloatWithFormat(2, "0.00")
+
+	tp, err := indices.NewTimePointFromRow(discardLog, row)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !tp.Datetime.Equal(want) || tp.Datetime.Location() != time.Local {
+		t.Errorf("Datetime is %v, want %v", tp.Datetime, want)
+	}
+	if !tp.SimDatetime.Equal(wantSim) || tp.SimDatetime.Location() != time.Local {
+		t.Errorf("SimDatetime is %v, want %v", tp.SimDatetime, wantSim)
+	}
+	if tp.Temperature != NewNullFloat64(20.5) {
+		t.Errorf("Temperature is %v, want the stored 20.5 rather than the formatted 21", tp.Temperature)
+	}
+	if tp.Light1 != NewNullInt(2) {
+		t.Errorf("Light1 is %v, want 2", tp.Light1)
+	}
+}
@@ -0,0 +1,155 @@
+package chamber_tools
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock is the source of time for the schedule runners, so that they can be driven faster than real time.
+// a clock that keeps track of the channels returned by After can also implement Stopper, so that the runners release
+// the channels they stop waiting on.
+type Clock interface {
+	// Now returns the current time
+	Now() time.Time
+	// After waits for the duration to elapse and then sends the current time on the returned channel
+	After(d time.Duration) <-chan time.Time
+}
+
+// Stopper is implemented by clocks that can forget a channel returned by After that is no longer waited on
+type Stopper interface {
+	// Stop forgets a channel returned by After, returning false if it has already fired
+	Stop(c <-chan time.Time) bool
+}
+
+// stopAfter releases a channel returned by clock.After that won't be received from, if the clock is a Stopper
+func stopAfter(clock Clock, c <-chan time.Time) {
+	if stopper, ok := clock.(Stopper); ok {
+		stopper.Stop(c)
+	}
+}
+
+// sleep waits for d on clock or until ctx is done, returning the reason ctx was cancelled if it was
+func sleep(ctx context.Context, clock Clock, d time.Duration) error {
+	c := clock.After(d)
+	select {
+	case <-ctx.Done():
+		stopAfter(clock, c)
+		return context.Cause(ctx)
+	case <-c:
+		return nil
+	}
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// RealClock is the Clock backed by the time package
+var RealClock Clock = realClock{}
+
+type fakeWaiter struct {
+	until time.Time
+	c     chan time.Time
+}
+
+// FakeClock is a Clock that only moves when it is advanced
+type FakeClock struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	now     time.Time
+	waiters []fakeWaiter
+}
+
+// NewFakeClock returns a FakeClock stopped at now
+func NewFakeClock(now time.Time) *FakeClock {
+	c := &FakeClock{now: now}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+// Now returns the current fake time
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// After returns a channel that receives the fake time once the clock has been advanced by d
+func (c *FakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- c.now
+		return ch
+	}
+	c.waiters = append(c.waiters, fakeWaiter{until: c.now.Add(d), c: ch})
+	sort.SliceStable(c.waiters, func(i, j int) bool {
+		return c.waiters[i].until.Before(c.waiters[j].until)
+	})
+	c.cond.Broadcast()
+	return ch
+}
+
+// Stop forgets a channel returned by After so that it no longer counts as a waiter, returning false if it has already
+// fired
+func (c *FakeClock) Stop(ch <-chan time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, w := range c.waiters {
+		if w.c == ch {
+			c.waiters = append(c.waiters[:i], c.waiters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Advance moves the clock forward by d, waking every waiter whose deadline has passed
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.setLocked(c.now.Add(d))
+}
+
+// AdvanceToNext moves the clock forward to the earliest waiter deadline and wakes it.
+// returns false if nothing is waiting.
+func (c *FakeClock) AdvanceToNext() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.waiters) == 0 {
+		return false
+	}
+	c.setLocked(c.waiters[0].until)
+	return true
+}
+
+func (c *FakeClock) setLocked(t time.Time) {
+	if t.After(c.now) {
+		c.now = t
+	}
+	i := 0
+	for ; i < len(c.waiters) && !c.waiters[i].until.After(c.now); i++ {
+		c.waiters[i].c <- c.now
+	}
+	c.waiters = c.waiters[i:]
+}
+
+// Waiters returns the number of channels returned by After that have not fired yet
+func (c *FakeClock) Waiters() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.waiters)
+}
+
+// BlockUntil blocks until at least n channels returned by After are waiting to fire
+func (c *FakeClock) BlockUntil(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.waiters) < n {
+		c.cond.Wait()
+	}
+}
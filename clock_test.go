@@ -0,0 +1,50 @@
+package chamber_tools
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFakeClock(t *testing.T) {
+	clock := NewFakeClock(testStart)
+	soon, later := clock.After(time.Minute), clock.After(time.Hour)
+	if n := clock.Waiters(); n != 2 {
+		t.Fatalf("%d waiters, want 2", n)
+	}
+
+	clock.Advance(time.Second * 30)
+	select {
+	case <-soon:
+		t.Fatal("fired before its deadline")
+	default:
+	}
+
+	if !clock.AdvanceToNext() {
+		t.Fatal("nothing to advance to")
+	}
+	if got := <-soon; !got.Equal(testStart.Add(time.Minute)) {
+		t.Errorf("fired at %v, want %v", got, testStart.Add(time.Minute))
+	}
+	if now := clock.Now(); !now.Equal(testStart.Add(time.Minute)) {
+		t.Errorf("clock is at %v after advancing to the next waiter", now)
+	}
+
+	if !clock.Stop(later) {
+		t.Error("couldn't stop a waiter that hadn't fired")
+	}
+	if clock.Stop(soon) {
+		t.Error("stopped a waiter that had already fired")
+	}
+	if n := clock.Waiters(); n != 0 {
+		t.Errorf("%d waiters after stopping them all", n)
+	}
+	if clock.AdvanceToNext() {
+		t.Error("advanced with nothing waiting")
+	}
+
+	select {
+	case <-clock.After(0):
+	default:
+		t.Error("After(0) didn't fire straight away")
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/appf-anu/chamber-tools"
+	"github.com/appf-anu/chamber-tools/generator"
+	"github.com/pkg/errors"
+	"path/filepath"
+)
+
+// verifyWritten reads a written conditions file back and returns an error if it differs from the schedule
+func verifyWritten(s *chamber_tools.Schedule, conditionsPath string, verbose bool) error {
+	read, err := chamber_tools.LoadSchedule(loaderLog(verbose), conditionsPath)
+	if err != nil {
+		return err
+	}
+	if read.Len() != s.Len() {
+		return errors.Errorf("%s has %d timepoints, %d were written", conditionsPath, read.Len(), s.Len())
+	}
+	for i, tp := range s.TimePoints {
+		if !tp.Equal(read.TimePoints[i]) {
+			return errors.Errorf("TimePoint %05d of %s differs from what was written:\n\t%s\n\t%s", i,
+				conditionsPath, read.TimePoints[i].NulledString(), tp.NulledString())
+		}
+	}
+	return nil
+}
+
+// generate writes a conditions file for each spec file, returns 1 if any of them couldn't be written
+func generate(args []string) int {
+	flags := flag.NewFlagSet("generate", flag.ExitOnError)
+	output := flags.String("o", "", "file to write, only for a single spec. defaults to the spec's filename")
+	dir := flags.String("dir", ".", "directory to write files to that are named after their spec")
+	format := flags.String("format", "xlsx", "file type of files that are named after their spec, xlsx or csv")
+	verify := flags.Bool("verify", true, "read each file back and check that it is the same as what was written")
+	verbose := flags.Bool("v", false, "log what the parsers are doing")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: generate [flags] <spec.yaml|spec.json>...\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() == 0 || (*output != "" && flags.NArg() > 1) {
+		flags.Usage()
+		return 2
+	}
+	if *format != "xlsx" && *format != "csv" {
+		errLog.Printf("unsupported format %q", *format)
+		return 2
+	}
+
+	status := 0
+	for _, specPath := range flags.Args() {
+		spec, err := generator.LoadSpec(specPath)
+		if err != nil {
+			errLog.Println(err)
+			status = 1
+			continue
+		}
+		s, err := generator.Generate(errLog, spec)
+		if err != nil {
+			errLog.Printf("%s: %v", specPath, err)
+			status = 1
+			continue
+		}
+
+		conditionsPath := *output
+		if conditionsPath == "" {
+			conditionsPath = spec.Filename
+		}
+		if conditionsPath == "" {
+			conditionsPath = filepath.Join(*dir, spec.DefaultFilename("."+*format))
+		}
+		if err := s.WriteFile(conditionsPath); err != nil {
+			errLog.Println(err)
+			status = 1
+			continue
+		}
+		if *verify {
+			if err := verifyWritten(s, conditionsPath, *verbose); err != nil {
+				errLog.Println(err)
+				status = 1
+				continue
+			}
+		}
+		fmt.Printf("%s: wrote %d timepoints to %s\n", specPath, s.Len(), conditionsPath)
+	}
+	return status
+}
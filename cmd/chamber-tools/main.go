@@ -0,0 +1,135 @@
+// chamber-tools works with conditions files without running them on a chamber.
+//
+//	chamber-tools validate [-delimiter ;] [-require temperature] [-limits conviron] [-profiles fixtures.yaml] <file>...
+//	chamber-tools generate [-o file | -dir conditions -format csv] <spec.yaml>...
+//	chamber-tools summary [-calibrations ppfd.yaml | -profiles fixtures.yaml] [-fixture psi] [-json] <file>...
+//	chamber-tools weather -o file [-interval 10m] [-start 2006-01-02] [-temperature +2] <weather.csv>
+package main
+
+import (
+	"fmt"
+	"github.com/appf-anu/chamber-tools"
+	"github.com/appf-anu/chamber-tools/fixture"
+	"github.com/pkg/errors"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+var (
+	errLog *log.Logger
+)
+
+type command struct {
+	name  string
+	usage string
+	run   func(args []string) int
+}
+
+var commands = []command{
+	{"validate", "check conditions files for problems, exits non-zero if there are errors", validate},
+	{"generate", "write conditions files from yaml or json specs", generate},
+	{"summary", "print the photoperiod, DLI, temperature and humidity of each day of conditions files", summary},
+	{"weather", "convert a weather station export to a conditions file", weather},
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: %s <command> [flags] [args]\n\ncommands:\n", os.Args[0])
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "\t%-10s %s\n", c.name, c.usage)
+	}
+}
+
+// parseDelimiter parses a csv delimiter flag, "tab" or "\t" for tabs and empty to detect the delimiter
+func parseDelimiter(s string) (rune, error) {
+	switch s {
+	case "":
+		return 0, nil
+	case "tab", `\t`:
+		return '\t', nil
+	}
+	if utf8.RuneCountInString(s) != 1 {
+		return 0, errors.Errorf("delimiter must be a single character, not %q", s)
+	}
+	r, _ := utf8.DecodeRuneInString(s)
+	return r, nil
+}
+
+// splitList splits a comma separated flag value
+func splitList(s string) []string {
+	var list []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+// limitProfile returns the named limit profile from limitsPath, or from the default profiles if limitsPath is empty.
+// returns nil if name is empty.
+func limitProfile(name, limitsPath string) (*chamber_tools.LimitProfile, error) {
+	if name == "" {
+		return nil, nil
+	}
+	profiles := chamber_tools.DefaultLimitProfiles()
+	if limitsPath != "" {
+		var err error
+		if profiles, err = chamber_tools.LoadLimitProfiles(limitsPath); err != nil {
+			return nil, err
+		}
+	}
+	profile, ok := profiles[name]
+	if !ok {
+		return nil, errors.Errorf("no limit profile named %q", name)
+	}
+	return &profile, nil
+}
+
+// fixtureProfile returns the named fixture profile from profilesPath, or the only one in it if name is empty.
+// returns nil if profilesPath is empty, as the default profiles haven't been measured.
+func fixtureProfile(name, profilesPath string) (*fixture.Profile, error) {
+	if profilesPath == "" {
+		return nil, nil
+	}
+	profiles, err := fixture.LoadProfiles(profilesPath)
+	if err != nil {
+		return nil, err
+	}
+	if name == "" && len(profiles) == 1 {
+		for _, p := range profiles {
+			return &p, nil
+		}
+	}
+	profile, ok := profiles[name]
+	if !ok {
+		return nil, errors.Errorf("no fixture profile named %q in %s", name, profilesPath)
+	}
+	return &profile, nil
+}
+
+// loaderLog returns errLog if verbose, otherwise a logger that discards the row by row messages of the parsers
+func loaderLog(verbose bool) *log.Logger {
+	if verbose {
+		return errLog
+	}
+	return log.New(io.Discard, "", 0)
+}
+
+func main() {
+	errLog = log.New(os.Stderr, "[chamber-tools] ", log.Ldate|log.Ltime|log.Lshortfile)
+
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	for _, c := range commands {
+		if c.name == os.Args[1] {
+			os.Exit(c.run(os.Args[2:]))
+		}
+	}
+	usage()
+	os.Exit(2)
+}
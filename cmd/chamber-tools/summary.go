@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/appf-anu/chamber-tools"
+	"github.com/appf-anu/chamber-tools/fixture"
+	"github.com/pkg/errors"
+	"os"
+	"strconv"
+	"text/tabwriter"
+)
+
+// calibration returns the named calibration from calibrationsPath, or the only one in it if name is empty.
+// returns the calibration of profile if calibrationsPath is empty, or nil if there is no profile either.
+func calibration(name, calibrationsPath string, profile *fixture.Profile) (*chamber_tools.Calibration, error) {
+	if calibrationsPath == "" {
+		if profile != nil {
+			c := chamber_tools.ProfileCalibration(*profile)
+			return &c, nil
+		}
+		if name != "" {
+			return nil, errors.New("-fixture needs a -calibrations or -profiles file")
+		}
+		return nil, nil
+	}
+	calibrations, err := chamber_tools.LoadCalibrations(calibrationsPath)
+	if err != nil {
+		return nil, err
+	}
+	if name == "" && len(calibrations) == 1 {
+		for _, c := range calibrations {
+			return &c, nil
+		}
+	}
+	c, ok := calibrations[name]
+	if !ok {
+		return nil, errors.Errorf("no calibration named %q in %s", name, calibrationsPath)
+	}
+	return &c, nil
+}
+
+// formatValue formats a summary value for the table, unset values are "-"
+func formatValue(v chamber_tools.NullFloat64) string {
+	if !v.Valid {
+		return "-"
+	}
+	return strconv.FormatFloat(v.Float64, 'f', 2, 64)
+}
+
+// printSummaryTable prints one row for each day of a summary
+func printSummaryTable(summaries []chamber_tools.DaySummary) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "date\thours\tphotoperiod\tDLI\ttemp mean\tmin\tmax\tday\tnight\t"+
+		"RH mean\tmin\tmax\tday\tnight\t")
+	for _, d := range summaries {
+		t, rh := d.Temperature, d.RelativeHumidity
+		fmt.Fprintf(w, "%s\t%.2f\t%.2f\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t\n",
+			d.Date.Format("2006-01-02"), d.Duration.Hours(), d.Photoperiod.Hours(), formatValue(d.DLI),
+			formatValue(t.Mean), formatValue(t.Min), formatValue(t.Max), formatValue(t.DayMean), formatValue(t.NightMean),
+			formatValue(rh.Mean), formatValue(rh.Min), formatValue(rh.Max), formatValue(rh.DayMean),
+			formatValue(rh.NightMean))
+	}
+	w.Flush()
+}
+
+// summary prints the per day summary of each conditions file, returns 1 if any of them couldn't be loaded
+func summary(args []string) int {
+	flags := flag.NewFlagSet("summary", flag.ExitOnError)
+	delimiter := flags.String("delimiter", "", "csv delimiter, detected from the header line if empty")
+	calibrationsPath := flags.String("calibrations", "", "json or yaml file of PPFD calibrations to compute the DLI with")
+	profilesPath := flags.String("profiles", "",
+		"json or yaml file of fixture profiles to solve spectrum columns and compute the DLI with")
+	fixtureName := flags.String("fixture", "", "calibration and fixture profile to use, not needed if there is only one")
+	asJSON := flags.Bool("json", false, "print json instead of a table")
+	verbose := flags.Bool("v", false, "log what the parsers are doing")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: summary [flags] <file>...\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	var err error
+	var opts chamber_tools.LoadOptions
+	if opts.Delimiter, err = parseDelimiter(*delimiter); err != nil {
+		errLog.Println(err)
+		return 2
+	}
+	if opts.Fixture, err = fixtureProfile(*fixtureName, *profilesPath); err != nil {
+		errLog.Println(err)
+		return 2
+	}
+	c, err := calibration(*fixtureName, *calibrationsPath, opts.Fixture)
+	if err != nil {
+		errLog.Println(err)
+		return 2
+	}
+
+	status := 0
+	summaries := make(map[string][]chamber_tools.DaySummary)
+	for _, path := range flags.Args() {
+		s, err := chamber_tools.LoadScheduleWithOptions(loaderLog(*verbose), path, opts)
+		if err != nil {
+			errLog.Println(err)
+			status = 1
+			continue
+		}
+		summaries[path] = s.Summary(c)
+		if !*asJSON {
+			fmt.Printf("%s:\n", path)
+			printSummaryTable(summaries[path])
+		}
+	}
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(summaries); err != nil {
+			errLog.Println(err)
+			return 1
+		}
+	}
+	return status
+}
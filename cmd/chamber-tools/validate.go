@@ -0,0 +1,65 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/appf-anu/chamber-tools"
+)
+
+// validate reports the issues in each conditions file, returns 1 if any of them have errors
+func validate(args []string) int {
+	flags := flag.NewFlagSet("validate", flag.ExitOnError)
+	delimiter := flags.String("delimiter", "", "csv delimiter, detected from the header line if empty")
+	require := flags.String("require", "", "comma separated headers that must be present as well as datetime")
+	limits := flags.String("limits", "", "check values against this limit profile, eg. conviron, psi, heliospectra_s7")
+	limitsPath := flags.String("limits-file", "", "json or yaml file of limit profiles to use instead of the defaults")
+	profilesPath := flags.String("profiles", "", "json or yaml file of fixture profiles to solve spectrum columns with")
+	fixtureName := flags.String("fixture", "", "fixture profile to use, not needed if the file only has one")
+	errorsOnly := flags.Bool("errors", false, "only report errors, not warnings")
+	verbose := flags.Bool("v", false, "log what the parsers are doing")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: validate [flags] <file>...\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	var err error
+	opts := chamber_tools.ValidateOptions{Required: splitList(*require)}
+	if opts.Delimiter, err = parseDelimiter(*delimiter); err != nil {
+		errLog.Println(err)
+		return 2
+	}
+	if opts.Limits, err = limitProfile(*limits, *limitsPath); err != nil {
+		errLog.Println(err)
+		return 2
+	}
+	if opts.Fixture, err = fixtureProfile(*fixtureName, *profilesPath); err != nil {
+		errLog.Println(err)
+		return 2
+	}
+
+	status := 0
+	for _, path := range flags.Args() {
+		issues, err := chamber_tools.Validate(loaderLog(*verbose), path, opts)
+		if err != nil {
+			fmt.Printf("%s: error: %v\n", path, err)
+			status = 1
+			continue
+		}
+		for _, issue := range issues {
+			if *errorsOnly && issue.Severity != chamber_tools.SeverityError {
+				continue
+			}
+			fmt.Printf("%s: %s\n", path, issue)
+		}
+		if issues.Errors() > 0 {
+			status = 1
+		}
+		fmt.Printf("%s: %d errors, %d warnings\n", path, issues.Errors(), len(issues)-issues.Errors())
+	}
+	return status
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/appf-anu/chamber-tools/generator"
+	"github.com/pkg/errors"
+	"strings"
+	"time"
+)
+
+// parseColumns parses a comma separated list of column=header pairs over the default weather columns,
+// eg. "timestamp=Date,radiation=GlobalRad"
+func parseColumns(s string) (generator.WeatherColumns, error) {
+	columns := generator.DefaultWeatherColumns()
+	fields := map[string]*string{
+		"timestamp":   &columns.Timestamp,
+		"temperature": &columns.Temperature,
+		"humidity":    &columns.Humidity,
+		"radiation":   &columns.Radiation,
+		"co2":         &columns.CO2,
+	}
+	for _, pair := range splitList(s) {
+		kv := strings.SplitN(pair, "=", 2)
+		field, ok := fields[strings.TrimSpace(kv[0])]
+		if len(kv) != 2 || !ok {
+			return columns, errors.Errorf("column %q must be timestamp, temperature, humidity, radiation or co2="+
+				"header", pair)
+		}
+		*field = strings.TrimSpace(kv[1])
+	}
+	return columns, nil
+}
+
+// weather converts a weather station export to a conditions file, returns 1 if it couldn't be converted
+func weather(args []string) int {
+	flags := flag.NewFlagSet("weather", flag.ExitOnError)
+	output := flags.String("o", "", "conditions file to write, .xlsx or .csv")
+	columns := flags.String("columns", "",
+		"comma separated column=header pairs for headers that aren't the column names, eg. timestamp=Date")
+	delimiter := flags.String("delimiter", "", "csv delimiter, detected from the header line if empty")
+	layout := flags.String("layout", "", "go time layout of the timestamps, common layouts are tried if empty")
+	timezone := flags.String("timezone", "", "IANA timezone of the timestamps, defaults to the local timezone")
+	interval := flags.Duration("interval", 10*time.Minute, "resample to this interval, 0 keeps every record")
+	start := flags.String("start", "", "date (2006-01-02) or RFC3339 time to start at, defaults to the recorded times")
+	temperature := flags.String("temperature", "", "temperature transform, eg. +2 for a warming scenario")
+	humidity := flags.String("humidity", "", "humidity transform, eg. *0.9")
+	radiation := flags.String("radiation", "", "radiation transform, eg. *0.8")
+	co2 := flags.String("co2", "", "co2 transform, eg. +200")
+	verbose := flags.Bool("v", false, "log rows that are skipped")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: weather -o <conditions file> [flags] <weather.csv>\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 || *output == "" {
+		flags.Usage()
+		return 2
+	}
+
+	opts := generator.WeatherOptions{TimestampLayout: *layout, Interval: *interval}
+	err := func() (err error) {
+		if opts.Columns, err = parseColumns(*columns); err != nil {
+			return err
+		}
+		if opts.Delimiter, err = parseDelimiter(*delimiter); err != nil {
+			return err
+		}
+		if *timezone != "" {
+			if opts.Location, err = time.LoadLocation(*timezone); err != nil {
+				return err
+			}
+		}
+		if *start != "" {
+			if opts.Start, err = time.ParseInLocation("2006-01-02", *start, time.Local); err != nil {
+				if opts.Start, err = time.Parse(time.RFC3339, *start); err != nil {
+					return errors.Errorf("start %q must be a date or RFC3339 time", *start)
+				}
+			}
+		}
+		for _, t := range []struct {
+			flag      string
+			transform *generator.Transform
+		}{
+			{*temperature, &opts.Temperature},
+			{*humidity, &opts.Humidity},
+			{*radiation, &opts.Radiation},
+			{*co2, &opts.CO2},
+		} {
+			if *t.transform, err = generator.ParseTransform(t.flag); err != nil {
+				return err
+			}
+		}
+		return nil
+	}()
+	if err != nil {
+		errLog.Println(err)
+		return 2
+	}
+
+	s, err := generator.ImportWeather(loaderLog(*verbose), flags.Arg(0), opts)
+	if err != nil {
+		errLog.Println(err)
+		return 1
+	}
+	if err := s.WriteFile(*output); err != nil {
+		errLog.Println(err)
+		return 1
+	}
+	if err := verifyWritten(s, *output, *verbose); err != nil {
+		errLog.Println(err)
+		return 1
+	}
+	fmt.Printf("%s: wrote %d timepoints from %v to %v to %s\n", flags.Arg(0), s.Len(), s.Start(), s.End(), *output)
+	return 0
+}
@@ -0,0 +1,94 @@
+package chamber_tools
+
+import (
+	"bytes"
+	"encoding/csv"
+	"os"
+	"strings"
+)
+
+// utf8BOM is written at the start of csv files by Excel when saving as "CSV UTF-8"
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// csvDelimiters are the delimiters detectDelimiter chooses between, in order of preference when they are tied
+var csvDelimiters = []rune{',', ';', '\t'}
+
+// detectDelimiter guesses the delimiter of a csv file from its header line by counting the candidate delimiters
+// that are outside of quotes. European Excel installs export with ';', the python generators pad ',' with '\t'.
+func detectDelimiter(headerLine string) rune {
+	counts := make(map[rune]int)
+	quoted := false
+	for _, r := range headerLine {
+		if r == '"' {
+			quoted = !quoted
+			continue
+		}
+		if !quoted {
+			counts[r]++
+		}
+	}
+	best := csvDelimiters[0]
+	for _, d := range csvDelimiters[1:] {
+		if counts[d] > counts[best] {
+			best = d
+		}
+	}
+	return best
+}
+
+// trimField trims the padding around a header or data cell
+func trimField(field string) string {
+	return strings.TrimSpace(field)
+}
+
+// readCsvFile reads every record of a csv file with trimmed fields.
+// if delimiter is 0 it is detected from the header line, a leading byte order mark is stripped.
+func readCsvFile(conditionsPath string, delimiter rune) ([][]string, error) {
+	data, err := os.ReadFile(conditionsPath)
+	if err != nil {
+		return nil, err
+	}
+	data = bytes.TrimPrefix(data, utf8BOM)
+
+	if delimiter == 0 {
+		headerLine := data
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			headerLine = data[:i]
+		}
+		delimiter = detectDelimiter(string(headerLine))
+	}
+
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.Comma = delimiter
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	// leading tabs are padding unless they are the delimiter
+	reader.TrimLeadingSpace = delimiter != '\t'
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	for _, record := range records {
+		for i := range record {
+			record[i] = trimField(record[i])
+		}
+	}
+	return records, nil
+}
+
+// isEmptyRecord returns true if every field of a record is empty, like the trailing ",,,," rows Excel writes
+func isEmptyRecord(record []string) bool {
+	for _, field := range record {
+		if field != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// ReadCSVFile reads every record of a csv file the same way as conditions files are read, with trimmed fields, a
+// leading byte order mark stripped and the delimiter detected from the header line if it is 0.
+func ReadCSVFile(csvPath string, delimiter rune) ([][]string, error) {
+	return readCsvFile(csvPath, delimiter)
+}
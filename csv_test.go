@@ -0,0 +1,54 @@
+package chamber_tools
+
+import (
+	"testing"
+)
+
+func TestDetectDelimiter(t *testing.T) {
+	tests := map[string]rune{
+		"datetime,temperature,humidity":                    ',',
+		"datetime,\tdatetime-sim,\thumidity,\ttemperature": ',',
+		"datetime;temperature;humidity":                    ';',
+		"datetime\ttemperature\thumidity":                  '\t',
+		`"datetime;sim",temperature`:                       ',',
+		"datetime":                                         ',',
+	}
+	for headerLine, want := range tests {
+		if got := detectDelimiter(headerLine); got != want {
+			t.Errorf("detectDelimiter(%q) = %q, want %q", headerLine, got, want)
+		}
+	}
+}
+
+// TestLoadScheduleDelimiters loads the same conditions written with each delimiter, with and without the UTF-8 BOM that
+// Excel writes at the start of "CSV UTF-8" files
+func TestLoadScheduleDelimiters(t *testing.T) {
+	files := map[string]string{
+		"comma":         "datetime,temperature,humidity\n2020-01-01 00:00,20,55\n2020-01-01 06:00,25,60\n",
+		"padded":        "datetime,\ttemperature,\thumidity\n2020-01-01 00:00,\t20,\t55\n2020-01-01 06:00,\t25,\t60\n",
+		"semicolon":     "datetime;temperature;humidity\n2020-01-01 00:00;20;55\n2020-01-01 06:00;25;60\n",
+		"tab":           "datetime\ttemperature\thumidity\n2020-01-01 00:00\t20\t55\n2020-01-01 06:00\t25\t60\n",
+		"bom":           "\ufeffdatetime,temperature,humidity\n2020-01-01 00:00,20,55\n2020-01-01 06:00,25,60\n",
+		"bom semicolon": "\ufeffdatetime;temperature;humidity\r\n2020-01-01 00:00;20;55\r\n2020-01-01 06:00;25;60\r\n",
+	}
+	for name, contents := range files {
+		s, err := LoadSchedule(discardLog, writeFile(t, "conditions.csv", contents))
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if s.Indices.DatetimeIdx != 0 || s.Indices.TemperatureIdx != 1 || s.Indices.HumidityIdx != 2 {
+			t.Errorf("%s: column layout is %v", name, s.Indices.Headers())
+		}
+		if s.Len() != 2 || s.TimePoints[1].Temperature != NewNullFloat64(25) ||
+			s.TimePoints[1].RelativeHumidity != NewNullFloat64(60) {
+			t.Errorf("%s: loaded %v", name, s.TimePoints)
+		}
+	}
+
+	// a delimiter that is set isn't detected
+	p := writeFile(t, "conditions.csv", files["semicolon"])
+	if _, err := LoadScheduleWithOptions(discardLog, p, LoadOptions{Delimiter: ','}); err == nil {
+		t.Error("loaded a ';' delimited file split at ','")
+	}
+}
@@ -0,0 +1,84 @@
+// Package derived computes quantities that are derived from the temperature and relative humidity of a timepoint, like
+// vapour pressure deficit, which plant physiologists specify programs in.
+//
+// vapour pressures use the Magnus-Tetens approximation, which is accurate to within 0.1% from -20°C to 50°C.
+package derived
+
+import (
+	"github.com/pkg/errors"
+	"math"
+)
+
+// magnus coefficients for saturation vapour pressure over water
+const (
+	magnusA = 17.27
+	magnusB = 237.3 // °C
+	// magnusE0 is the saturation vapour pressure at 0°C in kPa
+	magnusE0 = 0.61078
+)
+
+// SaturationVapourPressure returns the saturation vapour pressure in kPa at a temperature in °C
+func SaturationVapourPressure(temperature float64) float64 {
+	return magnusE0 * math.Exp(magnusA*temperature/(temperature+magnusB))
+}
+
+// VapourPressure returns the actual vapour pressure in kPa at a temperature in °C and relative humidity in %RH
+func VapourPressure(temperature, relativeHumidity float64) float64 {
+	return SaturationVapourPressure(temperature) * relativeHumidity / 100
+}
+
+// VPD returns the vapour pressure deficit in kPa at a temperature in °C and relative humidity in %RH
+func VPD(temperature, relativeHumidity float64) float64 {
+	return SaturationVapourPressure(temperature) * (1 - relativeHumidity/100)
+}
+
+// DewPoint returns the dew point in °C at a temperature in °C and relative humidity in %RH.
+// relativeHumidity must be above 0, the dew point of perfectly dry air is -Inf.
+func DewPoint(temperature, relativeHumidity float64) float64 {
+	gamma := math.Log(relativeHumidity/100) + magnusA*temperature/(temperature+magnusB)
+	return magnusB * gamma / (magnusA - gamma)
+}
+
+// AbsoluteHumidity returns the mass of water vapour in g/m³ at a temperature in °C and relative humidity in %RH
+func AbsoluteHumidity(temperature, relativeHumidity float64) float64 {
+	// the ideal gas law with the specific gas constant of water vapour, 461.5 J/(kg·K)
+	return VapourPressure(temperature, relativeHumidity) * 1000 / (461.5 * (temperature + 273.15)) * 1000
+}
+
+// RelativeHumidityFromVPD returns the relative humidity in %RH that gives a vapour pressure deficit in kPa at a
+// temperature in °C. returns an error if the deficit is negative or larger than the saturation vapour pressure, as no
+// humidity gives it.
+func RelativeHumidityFromVPD(temperature, vpd float64) (float64, error) {
+	svp := SaturationVapourPressure(temperature)
+	if vpd < 0 || vpd > svp {
+		return 0, errors.Errorf("vpd %v kPa is outside 0 to %.4g kPa, the saturation vapour pressure at %v°C",
+			vpd, svp, temperature)
+	}
+	return 100 * (1 - vpd/svp), nil
+}
+
+// Values are the quantities derived from a temperature and relative humidity.
+// the fields are float64 so that they can be added to a telegraf measurement with
+// chamber_tools.DecodeStructFieldToMeasurement like the fields of a TimePoint.
+type Values struct {
+	// VPD is the vapour pressure deficit in kPa
+	VPD float64
+	// DewPoint is in °C
+	DewPoint float64
+	// AbsoluteHumidity is in g/m³
+	AbsoluteHumidity float64
+	// VapourPressure and SaturationVapourPressure are in kPa
+	VapourPressure           float64
+	SaturationVapourPressure float64
+}
+
+// FromTemperatureAndHumidity returns every derived value at a temperature in °C and relative humidity in %RH
+func FromTemperatureAndHumidity(temperature, relativeHumidity float64) Values {
+	return Values{
+		VPD:                      VPD(temperature, relativeHumidity),
+		DewPoint:                 DewPoint(temperature, relativeHumidity),
+		AbsoluteHumidity:         AbsoluteHumidity(temperature, relativeHumidity),
+		VapourPressure:           VapourPressure(temperature, relativeHumidity),
+		SaturationVapourPressure: SaturationVapourPressure(temperature),
+	}
+}
@@ -0,0 +1,71 @@
+package derived
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+// within returns true if got is within a fraction tolerance of want
+func within(got, want, tolerance float64) bool {
+	return math.Abs(got-want) <= math.Abs(want)*tolerance
+}
+
+func TestSaturationVapourPressure(t *testing.T) {
+	// saturation vapour pressures over water in kPa from the Smithsonian meteorological tables
+	reference := map[float64]float64{0: 0.6113, 10: 1.2281, 20: 2.3388, 25: 3.1690, 30: 4.2455}
+	for temperature, want := range reference {
+		if got := SaturationVapourPressure(temperature); !within(got, want, 0.001) {
+			t.Errorf("SaturationVapourPressure(%v) = %v, want %v", temperature, got, want)
+		}
+	}
+}
+
+func TestDerivedValues(t *testing.T) {
+	tests := []struct {
+		temperature, humidity float64
+		want                  Values
+	}{
+		// psychrometric chart values
+		{25, 60, Values{VPD: 1.268, DewPoint: 16.7, AbsoluteHumidity: 13.82, VapourPressure: 1.901}},
+		{20, 80, Values{VPD: 0.468, DewPoint: 16.44, AbsoluteHumidity: 13.83, VapourPressure: 1.871}},
+		{30, 100, Values{VPD: 0, DewPoint: 30, AbsoluteHumidity: 30.36, VapourPressure: 4.246}},
+	}
+	for _, test := range tests {
+		got := FromTemperatureAndHumidity(test.temperature, test.humidity)
+		if math.Abs(got.VPD-test.want.VPD) > 0.005 || math.Abs(got.DewPoint-test.want.DewPoint) > 0.1 ||
+			!within(got.AbsoluteHumidity, test.want.AbsoluteHumidity, 0.005) ||
+			!within(got.VapourPressure, test.want.VapourPressure, 0.005) ||
+			got.SaturationVapourPressure != SaturationVapourPressure(test.temperature) {
+			t.Errorf("FromTemperatureAndHumidity(%v, %v) = %+v, want %+v",
+				test.temperature, test.humidity, got, test.want)
+		}
+	}
+}
+
+func TestRelativeHumidityFromVPD(t *testing.T) {
+	tests := []struct{ temperature, vpd, want float64 }{
+		{25, 1.268, 60},
+		{20, 0.468, 80},
+		{30, 0, 100},
+		{25, SaturationVapourPressure(25), 0},
+	}
+	for _, test := range tests {
+		got, err := RelativeHumidityFromVPD(test.temperature, test.vpd)
+		if err != nil || math.Abs(got-test.want) > 0.2 {
+			t.Errorf("RelativeHumidityFromVPD(%v, %v) = %v, %v, want %v", test.temperature, test.vpd, got, err,
+				test.want)
+		}
+		// the humidity gives back the vpd it was computed from
+		if vpd := VPD(test.temperature, got); math.Abs(vpd-test.vpd) > 1e-9 {
+			t.Errorf("VPD(%v, %v) = %v, want %v", test.temperature, got, vpd, test.vpd)
+		}
+	}
+
+	for _, vpd := range []float64{-0.1, 3.2} {
+		_, err := RelativeHumidityFromVPD(25, vpd)
+		if err == nil || !strings.Contains(err.Error(), "outside 0 to 3.168") {
+			t.Errorf("RelativeHumidityFromVPD(25, %v) returned %v, want an error", vpd, err)
+		}
+	}
+}
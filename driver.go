@@ -0,0 +1,225 @@
+package chamber_tools
+
+import (
+	"context"
+	"fmt"
+	"github.com/mdaffin/go-telegraf"
+	"github.com/pkg/errors"
+	"log"
+	"reflect"
+	"time"
+)
+
+// Driver is a chamber or light controller that timepoints are applied to, so that device programs only implement the
+// device protocol and share the scheduling, retries, metrics and shutdown of RunDriver.
+type Driver interface {
+	// Apply sets the targets of a timepoint on the device. targets the device can't apply are unset. a returned error
+	// retries the timepoint with RunOptions.Retry.
+	Apply(ctx context.Context, tp TimePoint) error
+	// Read measures the current conditions of the device
+	Read(ctx context.Context) (Reading, error)
+	// Capabilities returns the targets the device can apply
+	Capabilities() Capabilities
+	// Close releases the device once the runner has finished with it
+	Close() error
+}
+
+// Capabilities are the targets a driver can apply
+type Capabilities struct {
+	Temperature      bool
+	RelativeHumidity bool
+	CO2              bool
+	TotalSolar       bool
+	Light1           bool
+	Light2           bool
+	// Channels is the number of light channels, channel-1 to channel-Channels
+	Channels int
+}
+
+// Filter returns tp with the targets that can't be applied unset
+func (c Capabilities) Filter(tp TimePoint) TimePoint {
+	if !c.Temperature {
+		tp.Temperature = NullFloat64{}
+	}
+	if !c.RelativeHumidity {
+		tp.RelativeHumidity = NullFloat64{}
+	}
+	if !c.CO2 {
+		tp.CO2 = NullFloat64{}
+	}
+	if !c.TotalSolar {
+		tp.TotalSolar = NullFloat64{}
+	}
+	if !c.Light1 {
+		tp.Light1 = NullInt{}
+	}
+	if !c.Light2 {
+		tp.Light2 = NullInt{}
+	}
+	if len(tp.Channels) > c.Channels {
+		tp.Channels = tp.Channels[:c.Channels]
+	}
+	return tp
+}
+
+// Supports returns true if the column with a header can be applied. datetimes are always supported.
+func (c Capabilities) Supports(header string) bool {
+	switch header {
+	case "datetime", "datetime-sim":
+		return true
+	case "temperature":
+		return c.Temperature
+	case "humidity", "vpd":
+		return c.RelativeHumidity
+	case "co2":
+		return c.CO2
+	case "totalsolar":
+		return c.TotalSolar
+	case "light1":
+		return c.Light1
+	case "light2":
+		return c.Light2
+	}
+	var n int
+	if _, err := fmt.Sscanf(header, "channel-%d", &n); err == nil {
+		return n >= 1 && n <= c.Channels
+	}
+	return false
+}
+
+// Unsupported returns the headers of the columns in a layout that can't be applied
+func (c Capabilities) Unsupported(indices Indices) []string {
+	var unsupported []string
+	for _, h := range indices.Headers() {
+		name, _ := splitHeaderAnnotation(h)
+		if name != "" && !c.Supports(name) {
+			unsupported = append(unsupported, name)
+		}
+	}
+	return unsupported
+}
+
+// Reading is the conditions a driver measured, values that weren't measured are unset
+type Reading struct {
+	Time             time.Time
+	Temperature      NullFloat64
+	RelativeHumidity NullFloat64
+	CO2              NullFloat64
+	TotalSolar       NullFloat64
+	Light1           NullInt
+	Light2           NullInt
+	Channels         []NullFloat64
+}
+
+// Measurement returns the reading as a telegraf measurement, with the same field names as a TimePoint
+func (r Reading) Measurement(name string) telegraf.Measurement {
+	m := telegraf.NewMeasurement(name)
+	va := reflect.ValueOf(r)
+	for i := 0; i < va.NumField(); i++ {
+		DecodeStructFieldToMeasurement(&m, va, i)
+	}
+	return m
+}
+
+// DriverOptions control how a schedule is run on a Driver
+type DriverOptions struct {
+	RunOptions
+	// ReadInterval, if it is not 0, reads the driver every ReadInterval while the schedule runs
+	ReadInterval time.Duration
+	// OnReading is called with every reading of the driver, eg. to write it to telegraf with Reading.Measurement
+	OnReading func(r Reading)
+}
+
+// readDriver reads the driver every opts.ReadInterval until ctx is done, returning a channel that is closed once it
+// has stopped. the first wait starts before it returns, and each wait is from when the last one ended, so that readings
+// keep to a FakeClock. a panic in driver.Read stops the reads and is passed to fail as an error, as it can't be
+// recovered by the caller in another goroutine.
+func readDriver(ctx context.Context, errLog *log.Logger, driver Driver, opts DriverOptions,
+	fail func(err error)) <-chan struct{} {
+
+	clock := opts.clock()
+	stopped := make(chan struct{})
+	next := clock.After(opts.ReadInterval)
+	go func() {
+		defer close(stopped)
+		defer func() {
+			if r := recover(); r != nil {
+				stopAfter(clock, next)
+				fail(errors.Errorf("panic reading driver: %v", r))
+			}
+		}()
+		for {
+			var t time.Time
+			select {
+			case <-ctx.Done():
+				stopAfter(clock, next)
+				return
+			case t = <-next:
+			}
+			next = clock.After(t.Add(opts.ReadInterval).Sub(clock.Now()))
+			reading, err := driver.Read(ctx)
+			if err != nil {
+				if ctx.Err() == nil {
+					errLog.Printf("couldn't read driver: %v", err)
+				}
+				continue
+			}
+			if reading.Time.IsZero() {
+				reading.Time = t
+			}
+			if opts.OnReading != nil {
+				opts.OnReading(reading)
+			}
+		}
+	}()
+	return stopped
+}
+
+// RunDriver runs the schedule on a driver until it ends or ctx is done, then closes the driver. the targets the driver
+// can't apply are logged and left unset. a panic reading the driver stops the run and is returned as an error.
+func (s *Schedule) RunDriver(ctx context.Context, errLog *log.Logger, driver Driver, opts DriverOptions) error {
+	defer func() {
+		if err := driver.Close(); err != nil {
+			errLog.Printf("couldn't close driver: %v", err)
+		}
+	}()
+
+	capabilities := driver.Capabilities()
+	if unsupported := capabilities.Unsupported(s.Indices); len(unsupported) > 0 {
+		errLog.Printf("the driver can't apply %v, they are ignored", unsupported)
+	}
+
+	if opts.ReadInterval > 0 {
+		// a panic reading the driver stops the run with an error
+		var fail context.CancelCauseFunc
+		ctx, fail = context.WithCancelCause(ctx)
+		defer fail(nil)
+		readCtx, stop := context.WithCancel(ctx)
+		stopped := readDriver(readCtx, errLog, driver, opts, fail)
+		// stop reading before the driver is closed
+		defer func() {
+			stop()
+			<-stopped
+		}()
+	}
+
+	apply := func(ctx context.Context, point *TimePoint) error {
+		return driver.Apply(ctx, capabilities.Filter(*point))
+	}
+	return s.RunWith(ctx, errLog, apply, opts.RunOptions)
+}
+
+// RunConditionsDriver runs conditions for a file on a driver until the conditions end or ctx is done, then closes the
+// driver.
+func RunConditionsDriver(ctx context.Context, errLog *log.Logger, driver Driver, conditionsPath string,
+	opts DriverOptions) error {
+
+	errLog.Printf("running conditions file: %s\n", conditionsPath)
+
+	schedule, err := LoadScheduleWithOptions(errLog, conditionsPath, opts.Load)
+	if err != nil {
+		driver.Close()
+		return err
+	}
+	return schedule.RunDriver(ctx, errLog, driver, opts)
+}
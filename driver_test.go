@@ -0,0 +1,171 @@
+package chamber_tools
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeDriver is a Driver that records the timepoints it is sent and reads back the last one
+type fakeDriver struct {
+	capabilities Capabilities
+	// fails is how many calls to Apply fail before they succeed
+	fails   int
+	mu      sync.Mutex
+	applied []TimePoint
+	closed  int
+}
+
+func (d *fakeDriver) Apply(ctx context.Context, tp TimePoint) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.fails > 0 {
+		d.fails--
+		return errors.New("the device is unreachable")
+	}
+	d.applied = append(d.applied, tp)
+	return nil
+}
+
+func (d *fakeDriver) Read(ctx context.Context) (Reading, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var last TimePoint
+	if len(d.applied) > 0 {
+		last = d.applied[len(d.applied)-1]
+	}
+	return Reading{Temperature: last.Temperature, Channels: last.Channels}, nil
+}
+
+func (d *fakeDriver) Capabilities() Capabilities {
+	return d.capabilities
+}
+
+func (d *fakeDriver) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.closed++
+	return nil
+}
+
+func TestCapabilities(t *testing.T) {
+	c := Capabilities{Temperature: true, Light1: true, Channels: 2}
+	tp := TimePoint{
+		Temperature:      NewNullFloat64(20),
+		RelativeHumidity: NewNullFloat64(55),
+		CO2:              NewNullFloat64(400),
+		Light1:           NewNullInt(1),
+		Light2:           NewNullInt(2),
+		Channels:         []NullFloat64{NewNullFloat64(1), NewNullFloat64(2), NewNullFloat64(3)},
+	}
+	want := TimePoint{
+		Temperature: NewNullFloat64(20),
+		Light1:      NewNullInt(1),
+		Channels:    []NullFloat64{NewNullFloat64(1), NewNullFloat64(2)},
+	}
+	if got := c.Filter(tp); !reflect.DeepEqual(got, want) {
+		t.Errorf("Filter = %s, want %s", got.NulledString(), want.NulledString())
+	}
+
+	supports := map[string]bool{
+		"datetime":    true,
+		"temperature": true,
+		"humidity":    false,
+		"light1":      true,
+		"light2":      false,
+		"channel-2":   true,
+		"channel-3":   false,
+		"channel-0":   false,
+		"colour":      false,
+	}
+	for header, want := range supports {
+		if got := c.Supports(header); got != want {
+			t.Errorf("Supports(%q) = %v, want %v", header, got, want)
+		}
+	}
+
+	indices := getIndices(discardLog,
+		[]string{"datetime", "temperature:step", "humidity", "channel-1", "channel-2", "channel-3"})
+	if got := c.Unsupported(indices); !reflect.DeepEqual(got, []string{"humidity", "channel-3"}) {
+		t.Errorf("Unsupported = %v, want [humidity channel-3]", got)
+	}
+}
+
+// TestRunDriver runs the end of testSchedule on a driver that only applies temperature, and reads it every 30 minutes
+func TestRunDriver(t *testing.T) {
+	clock := NewFakeClock(at(19))
+	driver := &fakeDriver{capabilities: Capabilities{Temperature: true}}
+	s := testSchedule()
+	for i := range s.TimePoints {
+		s.TimePoints[i].RelativeHumidity = NewNullFloat64(55)
+	}
+	var mu sync.Mutex
+	var readings []Reading
+	opts := DriverOptions{
+		RunOptions:   RunOptions{Clock: clock},
+		ReadInterval: time.Minute * 30,
+		OnReading: func(r Reading) {
+			mu.Lock()
+			defer mu.Unlock()
+			readings = append(readings, r)
+		},
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- s.RunDriver(context.Background(), discardLog, driver, opts)
+	}()
+	// the runner waits for row 7 and the reader for its next read, to 21:00 when row 7 runs and the schedule ends
+	for i := 0; i < 4; i++ {
+		clock.BlockUntil(2)
+		clock.Advance(opts.ReadInterval)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	if len(driver.applied) != 2 {
+		t.Fatalf("applied %d timepoints, want rows 6 and 7", len(driver.applied))
+	}
+	for _, tp := range driver.applied {
+		if tp.RelativeHumidity.Valid {
+			t.Errorf("applied %s to a driver without humidity", tp.NulledString())
+		}
+	}
+	if driver.closed != 1 {
+		t.Errorf("closed the driver %d times, want once", driver.closed)
+	}
+	if n := clock.Waiters(); n != 0 {
+		t.Errorf("%d waiters left on the clock, want the reads to stop with the run", n)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	// the read at 21:00 is at the same time as row 7 so it could read either, or be stopped by the end of the run
+	want := []Reading{
+		{Time: at(19.5), Temperature: NewNullFloat64(6)},
+		{Time: at(20), Temperature: NewNullFloat64(6)},
+		{Time: at(20.5), Temperature: NewNullFloat64(6)},
+	}
+	if len(readings) < len(want) {
+		t.Fatalf("read %d times, want every 30 minutes from 19:30", len(readings))
+	}
+	for i, w := range want {
+		if !readings[i].Time.Equal(w.Time) || readings[i].Temperature != w.Temperature {
+			t.Errorf("reading %d is %+v, want %+v", i, readings[i], w)
+		}
+	}
+}
+
+func TestRunConditionsDriverClosesOnLoadError(t *testing.T) {
+	driver := &fakeDriver{}
+	err := RunConditionsDriver(context.Background(), discardLog, driver, "testdata/missing.csv", DriverOptions{})
+	if err == nil {
+		t.Error("ran a conditions file that doesn't exist")
+	}
+	if driver.closed != 1 {
+		t.Errorf("closed the driver %d times, want once", driver.closed)
+	}
+}
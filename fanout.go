@@ -0,0 +1,292 @@
+package chamber_tools
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Mapping selects the targets of a timepoint that a driver receives
+type Mapping struct {
+	Temperature      bool
+	RelativeHumidity bool
+	CO2              bool
+	TotalSolar       bool
+	Light1           bool
+	Light2           bool
+	// Channels are the channels of the timepoint, numbered from 1, that are sent as the channels of the driver in
+	// order, eg. 8 to 14 for the second of two 7 channel fixtures
+	Channels []int
+}
+
+// ParseMapping parses a mapping from comma separated headers, with "channel-N..M" for a range of channels,
+// eg. "temperature,humidity" or "channel-8..14".
+func ParseMapping(s string) (Mapping, error) {
+	var m Mapping
+	for _, header := range strings.Split(s, ",") {
+		header = strings.TrimSpace(header)
+		switch header {
+		case "":
+		case "temperature":
+			m.Temperature = true
+		case "humidity", "vpd":
+			m.RelativeHumidity = true
+		case "co2":
+			m.CO2 = true
+		case "totalsolar":
+			m.TotalSolar = true
+		case "light1":
+			m.Light1 = true
+		case "light2":
+			m.Light2 = true
+		default:
+			var first, last int
+			if _, err := fmt.Sscanf(header, "channel-%d..%d", &first, &last); err == nil {
+				if first < 1 || last < first {
+					return m, errors.Errorf("%q is not a range of channels", header)
+				}
+				for n := first; n <= last; n++ {
+					m.Channels = append(m.Channels, n)
+				}
+				continue
+			}
+			if _, err := fmt.Sscanf(header, "channel-%d", &first); err == nil && first >= 1 &&
+				fmt.Sprintf("channel-%d", first) == header {
+
+				m.Channels = append(m.Channels, first)
+				continue
+			}
+			return m, errors.Errorf("unknown header %q", header)
+		}
+	}
+	return m, nil
+}
+
+// Apply returns a timepoint with only the targets of the mapping, channels that the timepoint doesn't have are unset
+func (m Mapping) Apply(tp TimePoint) TimePoint {
+	mapped := TimePoint{Datetime: tp.Datetime, SimDatetime: tp.SimDatetime}
+	if m.Temperature {
+		mapped.Temperature = tp.Temperature
+	}
+	if m.RelativeHumidity {
+		mapped.RelativeHumidity = tp.RelativeHumidity
+	}
+	if m.CO2 {
+		mapped.CO2 = tp.CO2
+	}
+	if m.TotalSolar {
+		mapped.TotalSolar = tp.TotalSolar
+	}
+	if m.Light1 {
+		mapped.Light1 = tp.Light1
+	}
+	if m.Light2 {
+		mapped.Light2 = tp.Light2
+	}
+	for _, n := range m.Channels {
+		var v NullFloat64
+		if n >= 1 && n <= len(tp.Channels) {
+			v = tp.Channels[n-1]
+		}
+		mapped.Channels = append(mapped.Channels, v)
+	}
+	return mapped
+}
+
+// Unsupported returns the targets of the mapping that a driver can't apply
+func (m Mapping) Unsupported(c Capabilities) []string {
+	var unsupported []string
+	check := func(mapped, supported bool, header string) {
+		if mapped && !supported {
+			unsupported = append(unsupported, header)
+		}
+	}
+	check(m.Temperature, c.Temperature, "temperature")
+	check(m.RelativeHumidity, c.RelativeHumidity, "humidity")
+	check(m.CO2, c.CO2, "co2")
+	check(m.TotalSolar, c.TotalSolar, "totalsolar")
+	check(m.Light1, c.Light1, "light1")
+	check(m.Light2, c.Light2, "light2")
+	if len(m.Channels) > c.Channels {
+		unsupported = append(unsupported, fmt.Sprintf("%d channels", len(m.Channels)))
+	}
+	return unsupported
+}
+
+// Target is a driver that a schedule fans out to
+type Target struct {
+	// Name identifies the driver in logs and results, eg. "chamber" or "fixture-a"
+	Name   string
+	Driver Driver
+	// Mapping selects the targets the driver receives, every target is sent if it is nil
+	Mapping *Mapping
+	// Retry is how timepoints are retried on this driver, independently of the other drivers
+	Retry RetryPolicy
+}
+
+// TargetResult is the result of running a timepoint on one target
+type TargetResult struct {
+	Name string
+	// Attempts is the number of times the timepoint was tried
+	Attempts int
+	// Err is nil if the timepoint was applied, otherwise why it was abandoned
+	Err error
+}
+
+// FanOutResult is the result of running a timepoint on every target, in the order of the targets
+type FanOutResult struct {
+	TimePoint TimePoint
+	Results   []TargetResult
+}
+
+// Err returns an error naming the targets that the timepoint couldn't be applied to, or nil if it was applied to all
+// of them
+func (r FanOutResult) Err() error {
+	var failed []string
+	for _, result := range r.Results {
+		if result.Err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", result.Name, result.Err))
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	return errors.Errorf("%d of %d drivers failed: %s", len(failed), len(r.Results), strings.Join(failed, "; "))
+}
+
+// FanOutOptions control how a schedule is run on several drivers
+type FanOutOptions struct {
+	// RunOptions are the options of the schedule. RunOptions.Retry is not used, each Target has its own, and OnAbandon
+	// is called with timepoints that couldn't be applied to every target.
+	RunOptions
+	// OnResult, if it is not nil, is called with the result of each timepoint once every target has finished with it
+	OnResult func(result FanOutResult)
+	// ReadInterval, if it is not 0, reads every driver every ReadInterval while the schedule runs
+	ReadInterval time.Duration
+	// OnReading is called with every reading and the name of the target it came from
+	OnReading func(name string, r Reading)
+}
+
+// targetLog returns a logger that prefixes messages with the name of a target
+func targetLog(errLog *log.Logger, name string) *log.Logger {
+	return log.New(errLog.Writer(), fmt.Sprintf("%s[%s] ", errLog.Prefix(), name), errLog.Flags())
+}
+
+// RunFanOut runs the schedule on several drivers until it ends or ctx is done, then closes the drivers. each timepoint
+// is sent to every target at the same time, and retried on each target with its own Retry until it is applied or the
+// next timepoint is due.
+func (s *Schedule) RunFanOut(ctx context.Context, errLog *log.Logger, targets []Target, opts FanOutOptions) error {
+	defer func() {
+		for _, t := range targets {
+			if err := t.Driver.Close(); err != nil {
+				errLog.Printf("couldn't close driver %s: %v", t.Name, err)
+			}
+		}
+	}()
+
+	// a panic reading a driver stops the run with an error
+	ctx, fail := context.WithCancelCause(ctx)
+	defer fail(nil)
+
+	runners := make([]*runner, len(targets))
+	// tries counts the attempts of the timepoint being dispatched on each target, for the results of those that panic
+	tries := make([]int, len(targets))
+	for i, t := range targets {
+		i, t := i, t
+		targetErrLog := targetLog(errLog, t.Name)
+		capabilities := t.Driver.Capabilities()
+		if t.Mapping != nil {
+			if unsupported := t.Mapping.Unsupported(capabilities); len(unsupported) > 0 {
+				targetErrLog.Printf("the driver can't apply %v, they are ignored", unsupported)
+			}
+		} else if unsupported := capabilities.Unsupported(s.Indices); len(unsupported) > 0 {
+			targetErrLog.Printf("the driver can't apply %v, they are ignored", unsupported)
+		}
+		apply := func(ctx context.Context, point *TimePoint) error {
+			tries[i]++
+			tp := *point
+			if t.Mapping != nil {
+				tp = t.Mapping.Apply(tp)
+			}
+			return t.Driver.Apply(ctx, capabilities.Filter(tp))
+		}
+		runners[i] = newRunner(targetErrLog, apply, RunOptions{Clock: opts.clock(), Retry: t.Retry})
+
+		if opts.ReadInterval > 0 {
+			onReading := func(r Reading) {
+				if opts.OnReading != nil {
+					opts.OnReading(t.Name, r)
+				}
+			}
+			readCtx, stop := context.WithCancel(ctx)
+			stopped := readDriver(readCtx, targetErrLog, t.Driver, DriverOptions{
+				RunOptions:   RunOptions{Clock: opts.clock()},
+				ReadInterval: opts.ReadInterval,
+				OnReading:    onReading,
+			}, fail)
+			// stop reading before the drivers are closed
+			defer func() {
+				stop()
+				<-stopped
+			}()
+		}
+	}
+
+	// dispatch runs a timepoint on every target concurrently, retrying independently. a panic applying it to a target
+	// is that target's error, as it can't be recovered by the caller in another goroutine.
+	dispatch := func(ctx context.Context, point *TimePoint) error {
+		next, _ := NextDue(ctx)
+		result := FanOutResult{TimePoint: *point, Results: make([]TargetResult, len(targets))}
+		var wg sync.WaitGroup
+		for i := range targets {
+			tries[i] = 0
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				defer func() {
+					if r := recover(); r != nil {
+						err := errors.Errorf("panic applying TimePoint: %v", r)
+						runners[i].errLog.Printf("attempt %d failed: %v", tries[i], err)
+						result.Results[i] = TargetResult{Name: targets[i].Name, Attempts: tries[i], Err: err}
+					}
+				}()
+				attempts, err := runners[i].retry(ctx, *point, next)
+				result.Results[i] = TargetResult{Name: targets[i].Name, Attempts: attempts, Err: err}
+			}(i)
+		}
+		wg.Wait()
+		if ctx.Err() != nil {
+			return context.Cause(ctx)
+		}
+		if opts.OnResult != nil {
+			opts.OnResult(result)
+		}
+		return result.Err()
+	}
+
+	runOpts := opts.RunOptions
+	// retries are per target, the fan out is only tried once
+	runOpts.Retry = RetryPolicy{MaxAttempts: 1}
+	return s.RunWith(ctx, errLog, dispatch, runOpts)
+}
+
+// RunConditionsFanOut runs conditions for a file on several drivers until the conditions end or ctx is done, then
+// closes the drivers.
+func RunConditionsFanOut(ctx context.Context, errLog *log.Logger, targets []Target, conditionsPath string,
+	opts FanOutOptions) error {
+
+	errLog.Printf("running conditions file: %s\n", conditionsPath)
+
+	schedule, err := LoadScheduleWithOptions(errLog, conditionsPath, opts.Load)
+	if err != nil {
+		for _, t := range targets {
+			t.Driver.Close()
+		}
+		return err
+	}
+	return schedule.RunFanOut(ctx, errLog, targets, opts)
+}
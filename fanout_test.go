@@ -0,0 +1,186 @@
+package chamber_tools
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestParseMapping(t *testing.T) {
+	tests := map[string]Mapping{
+		"":                              {},
+		"temperature, vpd":              {Temperature: true, RelativeHumidity: true},
+		"co2,totalsolar,light1,light2":  {CO2: true, TotalSolar: true, Light1: true, Light2: true},
+		"channel-8..10":                 {Channels: []int{8, 9, 10}},
+		"channel-3,channel-1,channel-2": {Channels: []int{3, 1, 2}},
+	}
+	for s, want := range tests {
+		got, err := ParseMapping(s)
+		if err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("ParseMapping(%q) = %+v, %v, want %+v", s, got, err, want)
+		}
+	}
+	for _, s := range []string{"colour", "channel-0", "channel-5..4", "channel-2x"} {
+		if _, err := ParseMapping(s); err == nil {
+			t.Errorf("parsed the mapping %q", s)
+		}
+	}
+}
+
+func TestMappingApply(t *testing.T) {
+	m := Mapping{Temperature: true, Channels: []int{3, 1, 9}}
+	tp := TimePoint{
+		Datetime:         at(1),
+		Temperature:      NewNullFloat64(20),
+		RelativeHumidity: NewNullFloat64(55),
+		Channels:         []NullFloat64{NewNullFloat64(1), NewNullFloat64(2), NewNullFloat64(3)},
+	}
+	want := TimePoint{
+		Datetime:    at(1),
+		Temperature: NewNullFloat64(20),
+		Channels:    []NullFloat64{NewNullFloat64(3), NewNullFloat64(1), {}},
+	}
+	if got := m.Apply(tp); !reflect.DeepEqual(got, want) {
+		t.Errorf("Apply = %s, want %s", got.NulledString(), want.NulledString())
+	}
+
+	unsupported := m.Unsupported(Capabilities{Channels: 2})
+	if !reflect.DeepEqual(unsupported, []string{"temperature", "3 channels"}) {
+		t.Errorf("Unsupported = %v, want [temperature 3 channels]", unsupported)
+	}
+}
+
+func TestFanOutResultErr(t *testing.T) {
+	result := FanOutResult{Results: []TargetResult{
+		{Name: "chamber", Attempts: 1},
+		{Name: "lights", Attempts: 3, Err: ErrNotApplied},
+	}}
+	err := result.Err()
+	if err == nil || !strings.Contains(err.Error(), "1 of 2 drivers failed: lights: ") {
+		t.Errorf("Err = %v, want it to name the driver that failed", err)
+	}
+	result.Results[1].Err = nil
+	if err := result.Err(); err != nil {
+		t.Errorf("Err = %v for a timepoint that was applied to every driver", err)
+	}
+}
+
+// TestRunFanOut runs the end of testSchedule on three drivers, one that applies every attempt, one that fails its first
+// attempt and one that always fails, each with its own retries
+func TestRunFanOut(t *testing.T) {
+	s := testSchedule()
+	for i := range s.TimePoints {
+		s.TimePoints[i].Channels = []NullFloat64{NewNullFloat64(10), NewNullFloat64(20), NewNullFloat64(30)}
+	}
+	chamber := &fakeDriver{capabilities: Capabilities{Temperature: true}}
+	lights := &fakeDriver{capabilities: Capabilities{Channels: 2}, fails: 1}
+	broken := &fakeDriver{capabilities: Capabilities{Temperature: true}, fails: 100}
+	targets := []Target{
+		{Name: "chamber", Driver: chamber},
+		{Name: "lights", Driver: lights, Mapping: &Mapping{Channels: []int{3, 2}}, Retry: RetryPolicy{MaxAttempts: 2}},
+		{Name: "broken", Driver: broken, Retry: RetryPolicy{MaxAttempts: 3}},
+	}
+
+	clock := NewFakeClock(at(19))
+	var mu sync.Mutex
+	var results []FanOutResult
+	var abandoned []*AbandonError
+	opts := FanOutOptions{
+		RunOptions: RunOptions{
+			Clock:     clock,
+			OnAbandon: func(err *AbandonError) { abandoned = append(abandoned, err) },
+		},
+		OnResult: func(result FanOutResult) {
+			mu.Lock()
+			defer mu.Unlock()
+			results = append(results, result)
+		},
+	}
+	err := drive(clock, func() error {
+		return s.RunFanOut(context.Background(), discardLog, targets, opts)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(results) != 2 {
+		t.Fatalf("%d results, want one for each of rows 6 and 7", len(results))
+	}
+	wantAttempts := [][]int{{1, 2, 3}, {1, 1, 3}}
+	for i, result := range results {
+		for j, r := range result.Results {
+			if r.Name != targets[j].Name || r.Attempts != wantAttempts[i][j] || (r.Err != nil) != (j == 2) {
+				t.Errorf("result %d of TimePoint %d is %+v, want %d attempts", j, i, r, wantAttempts[i][j])
+			}
+		}
+	}
+	if len(abandoned) != 2 || !strings.Contains(abandoned[0].Error(), "broken") {
+		t.Errorf("abandoned %v, want both timepoints abandoned by the broken driver", abandoned)
+	}
+
+	wantLights := []NullFloat64{NewNullFloat64(30), NewNullFloat64(20)}
+	if len(lights.applied) != 2 || !reflect.DeepEqual(lights.applied[0].Channels, wantLights) ||
+		lights.applied[0].Temperature.Valid {
+		t.Errorf("lights were sent %v, want the mapped channels", lights.applied)
+	}
+	if len(chamber.applied) != 2 || chamber.applied[1].Temperature != NewNullFloat64(7) ||
+		len(chamber.applied[1].Channels) != 0 {
+		t.Errorf("chamber was sent %v, want the temperature without channels", chamber.applied)
+	}
+	for _, d := range []*fakeDriver{chamber, lights, broken} {
+		if d.closed != 1 {
+			t.Errorf("closed a driver %d times, want once", d.closed)
+		}
+	}
+}
+
+// applyPanicDriver is a fakeDriver that panics when it is sent a timepoint
+type applyPanicDriver struct {
+	*fakeDriver
+}
+
+func (d applyPanicDriver) Apply(ctx context.Context, tp TimePoint) error {
+	panic("lost the serial port")
+}
+
+// TestRunFanOutPanic checks that a driver panicking in Apply is that target's error, and the other targets keep running
+func TestRunFanOutPanic(t *testing.T) {
+	chamber := &fakeDriver{capabilities: Capabilities{Temperature: true}}
+	broken := applyPanicDriver{&fakeDriver{capabilities: Capabilities{Temperature: true}}}
+	targets := []Target{
+		{Name: "chamber", Driver: chamber},
+		{Name: "broken", Driver: broken, Retry: RetryPolicy{MaxAttempts: 3}},
+	}
+
+	clock := NewFakeClock(at(19))
+	var results []FanOutResult
+	opts := FanOutOptions{
+		RunOptions: RunOptions{Clock: clock},
+		OnResult:   func(result FanOutResult) { results = append(results, result) },
+	}
+	err := drive(clock, func() error {
+		return testSchedule().RunFanOut(context.Background(), discardLog, targets, opts)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(results) != 2 {
+		t.Fatalf("%d results, want one for each of rows 6 and 7", len(results))
+	}
+	for i, result := range results {
+		panicked := result.Results[1]
+		if result.Results[0].Err != nil || panicked.Attempts != 1 || panicked.Err == nil ||
+			!strings.Contains(panicked.Err.Error(), "panic applying TimePoint: lost the serial port") {
+			t.Errorf("results of TimePoint %d are %+v, want the broken driver's panic as its error", i, result.Results)
+		}
+	}
+	if len(chamber.applied) != 2 {
+		t.Errorf("chamber was sent %d timepoints, want 2", len(chamber.applied))
+	}
+	if chamber.closed != 1 || broken.closed != 1 {
+		t.Errorf("closed the drivers %d and %d times, want once", chamber.closed, broken.closed)
+	}
+}
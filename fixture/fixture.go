@@ -0,0 +1,280 @@
+// Package fixture describes what the channel-N columns of a conditions file mean for each light fixture model, and
+// converts between the values a fixture takes and the photosynthetic photon flux density (PPFD) it delivers.
+package fixture
+
+import (
+	"github.com/appf-anu/chamber-tools/internal/config"
+	"github.com/pkg/errors"
+	"sort"
+)
+
+// Unit is what the channel values of a fixture are
+type Unit string
+
+const (
+	// Percent channels take 0 to 100 percent of full output
+	Percent Unit = "percent"
+	// Absolute channels take 0 to a fixture specific maximum, eg. the drive current or a DAC value
+	Absolute Unit = "absolute"
+)
+
+// wavebands that channels are grouped into by their peak wavelength
+const (
+	UV       = "uv"       // below 400nm
+	Blue     = "blue"     // 400-500nm
+	Green    = "green"    // 500-600nm
+	Red      = "red"      // 600-700nm
+	FarRed   = "far-red"  // 700-800nm
+	Infrared = "infrared" // 800nm and above
+	// White is broad spectrum channels, which don't have a peak wavelength
+	White = "white"
+)
+
+// Waveband returns the waveband of a peak wavelength in nm, White if it is 0
+func Waveband(wavelength float64) string {
+	switch {
+	case wavelength <= 0:
+		return White
+	case wavelength < 400:
+		return UV
+	case wavelength < 500:
+		return Blue
+	case wavelength < 600:
+		return Green
+	case wavelength < 700:
+		return Red
+	case wavelength < 800:
+		return FarRed
+	}
+	return Infrared
+}
+
+// Channel is a channel-N column of a fixture
+type Channel struct {
+	Name string `json:"name" yaml:"name"`
+	// Wavelength is the peak wavelength in nm, 0 for broad spectrum channels
+	Wavelength float64 `json:"wavelength,omitempty" yaml:"wavelength,omitempty"`
+	// Waveband overrides the waveband of the peak wavelength if it is set
+	Waveband string `json:"waveband,omitempty" yaml:"waveband,omitempty"`
+	// MaxPPFD is the PPFD in µmol/m²/s the channel delivers at the canopy at full output, measured with a quantum
+	// sensor. it is 0 until the fixture has been measured.
+	MaxPPFD float64 `json:"max_ppfd" yaml:"max_ppfd"`
+}
+
+// band returns the waveband of the channel
+func (c Channel) band() string {
+	if c.Waveband != "" {
+		return c.Waveband
+	}
+	return Waveband(c.Wavelength)
+}
+
+// Profile is a light fixture model, its channels are in the order of the channel-N columns
+type Profile struct {
+	Name string `json:"name" yaml:"name"`
+	Unit Unit   `json:"unit" yaml:"unit"`
+	// Max is the channel value at full output, 100 if it is 0 and the Unit is Percent
+	Max      float64   `json:"max,omitempty" yaml:"max,omitempty"`
+	Channels []Channel `json:"channels" yaml:"channels"`
+}
+
+// DefaultProfiles are the light fixtures that the generators in file_generators write conditions for, keyed by the same
+// names, with the channels listed in create_xlsx.py. MaxPPFD depends on the fixture's height and age, so it is 0 and
+// must be measured and set in a profile file before converting to and from PPFD.
+func DefaultProfiles() map[string]Profile {
+	return map[string]Profile{
+		// psi lights are absolute in create_csv.py, create_xlsx.py writes them from 0 to 100 at full output
+		"psi": {
+			Name: "psi",
+			Unit: Absolute,
+			Max:  100,
+			Channels: []Channel{
+				{Name: "white", Waveband: White},
+				{Name: "blue", Waveband: Blue},
+				{Name: "green", Waveband: Green},
+				{Name: "nearest red", Waveband: Red},
+				{Name: "near red", Waveband: Red},
+				{Name: "far red", Waveband: FarRed},
+				{Name: "infra red", Waveband: Infrared},
+				{Name: "unknown, further infra red?", Waveband: Infrared},
+			},
+		},
+		"heliospectra_s7": {
+			Name: "heliospectra_s7",
+			Unit: Percent,
+			Channels: []Channel{
+				{Name: "400nm", Wavelength: 400},
+				{Name: "420nm", Wavelength: 420},
+				{Name: "450nm", Wavelength: 450},
+				{Name: "530nm", Wavelength: 530},
+				{Name: "630nm", Wavelength: 630},
+				{Name: "660nm", Wavelength: 660},
+				{Name: "735nm", Wavelength: 735},
+			},
+		},
+		"heliospectra_s10": {
+			Name: "heliospectra_s10",
+			Unit: Percent,
+			Channels: []Channel{
+				{Name: "370nm", Wavelength: 370},
+				{Name: "400nm", Wavelength: 400},
+				{Name: "420nm", Wavelength: 420},
+				{Name: "450nm", Wavelength: 450},
+				{Name: "530nm", Wavelength: 530},
+				{Name: "620nm", Wavelength: 620},
+				{Name: "660nm", Wavelength: 660},
+				{Name: "735nm", Wavelength: 735},
+				{Name: "850nm", Wavelength: 850},
+				{Name: "6500k", Waveband: White},
+			},
+		},
+	}
+}
+
+// LoadProfiles reads fixture profiles keyed by name from a .json or .yaml file.
+// profiles without a name are named after their key.
+func LoadProfiles(configPath string) (map[string]Profile, error) {
+	profiles := make(map[string]Profile)
+	if err := config.Decode(configPath, "fixture profiles", &profiles); err != nil {
+		return nil, err
+	}
+	for name, p := range profiles {
+		if p.Name == "" {
+			p.Name = name
+		}
+		if err := p.Validate(); err != nil {
+			return nil, errors.Wrapf(err, "fixture profile %s in %s", name, configPath)
+		}
+		profiles[name] = p
+	}
+	return profiles, nil
+}
+
+// Validate returns an error if the profile's unit or channels are invalid
+func (p Profile) Validate() error {
+	switch p.Unit {
+	case Percent, Absolute:
+	default:
+		return errors.Errorf("unit %q must be percent or absolute", p.Unit)
+	}
+	if p.Unit == Absolute && p.Max <= 0 {
+		return errors.New("absolute fixtures need a max channel value")
+	}
+	for i, c := range p.Channels {
+		if c.MaxPPFD < 0 {
+			return errors.Errorf("channel-%d (%s) max_ppfd %v can't be negative", i+1, c.Name, c.MaxPPFD)
+		}
+	}
+	return nil
+}
+
+// FullOutput returns the channel value at full output
+func (p Profile) FullOutput() float64 {
+	if p.Max == 0 && p.Unit == Percent {
+		return 100
+	}
+	return p.Max
+}
+
+// checkLength returns an error if there isn't a value for each channel
+func (p Profile) checkLength(n int) error {
+	if n != len(p.Channels) {
+		return errors.Errorf("%d values for the %d channels of %s", n, len(p.Channels), p.Name)
+	}
+	return nil
+}
+
+// ToPPFD converts the value of each channel to the PPFD in µmol/m²/s it delivers
+func (p Profile) ToPPFD(values []float64) ([]float64, error) {
+	if err := p.checkLength(len(values)); err != nil {
+		return nil, err
+	}
+	ppfd := make([]float64, len(values))
+	for i, v := range values {
+		c := p.Channels[i]
+		if v != 0 && c.MaxPPFD == 0 {
+			return nil, errors.Errorf("channel-%d (%s) of %s has no max_ppfd", i+1, c.Name, p.Name)
+		}
+		ppfd[i] = v / p.FullOutput() * c.MaxPPFD
+	}
+	return ppfd, nil
+}
+
+// FromPPFD converts the PPFD in µmol/m²/s of each channel to the value the fixture takes for it.
+// returns an error if a channel can't deliver its PPFD.
+func (p Profile) FromPPFD(ppfd []float64) ([]float64, error) {
+	if err := p.checkLength(len(ppfd)); err != nil {
+		return nil, err
+	}
+	values := make([]float64, len(ppfd))
+	for i, v := range ppfd {
+		c := p.Channels[i]
+		switch {
+		case v < 0:
+			return nil, errors.Errorf("channel-%d (%s) PPFD %v can't be negative", i+1, c.Name, v)
+		case v > c.MaxPPFD:
+			return nil, errors.Errorf("channel-%d (%s) of %s delivers at most %v µmol/m²/s, not %v",
+				i+1, c.Name, p.Name, c.MaxPPFD, v)
+		case v == 0:
+			continue
+		}
+		values[i] = v / c.MaxPPFD * p.FullOutput()
+	}
+	return values, nil
+}
+
+// Wavebands returns the PPFD in µmol/m²/s of each waveband that channel values deliver
+func (p Profile) Wavebands(values []float64) (map[string]float64, error) {
+	ppfd, err := p.ToPPFD(values)
+	if err != nil {
+		return nil, err
+	}
+	bands := make(map[string]float64)
+	for i, v := range ppfd {
+		bands[p.Channels[i].band()] += v
+	}
+	return bands, nil
+}
+
+// BandChannels returns the indices of the channels in each waveband
+func (p Profile) BandChannels() map[string][]int {
+	bands := make(map[string][]int)
+	for i, c := range p.Channels {
+		bands[c.band()] = append(bands[c.band()], i)
+	}
+	return bands
+}
+
+// FromWavebands returns the channel values that deliver a PPFD in µmol/m²/s for each waveband. the PPFD of a waveband
+// is split between its channels in proportion to their MaxPPFD, so they are all at the same fraction of full output.
+// returns an error if the fixture has no channels in a waveband or they can't deliver its PPFD.
+func (p Profile) FromWavebands(bands map[string]float64) ([]float64, error) {
+	names := make([]string, 0, len(bands))
+	for band := range bands {
+		names = append(names, band)
+	}
+	sort.Strings(names)
+
+	channels := p.BandChannels()
+	ppfd := make([]float64, len(p.Channels))
+	for _, band := range names {
+		target := bands[band]
+		var max float64
+		for _, i := range channels[band] {
+			max += p.Channels[i].MaxPPFD
+		}
+		switch {
+		case target == 0:
+			continue
+		case len(channels[band]) == 0:
+			return nil, errors.Errorf("%s has no %s channels", p.Name, band)
+		case target > max:
+			return nil, errors.Errorf("the %s channels of %s deliver at most %v µmol/m²/s, not %v",
+				band, p.Name, max, target)
+		}
+		for _, i := range channels[band] {
+			ppfd[i] = target * p.Channels[i].MaxPPFD / max
+		}
+	}
+	return p.FromPPFD(ppfd)
+}
@@ -0,0 +1,152 @@
+package fixture
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWaveband(t *testing.T) {
+	tests := map[float64]string{0: White, 370: UV, 400: Blue, 530: Green, 660: Red, 735: FarRed, 850: Infrared}
+	for wavelength, want := range tests {
+		if got := Waveband(wavelength); got != want {
+			t.Errorf("Waveband(%v) = %q, want %q", wavelength, got, want)
+		}
+	}
+}
+
+func TestDefaultProfiles(t *testing.T) {
+	for name, p := range DefaultProfiles() {
+		if err := p.Validate(); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+		// the generators write every fixture from 0 to 100 at full output like create_xlsx.py
+		if p.FullOutput() != 100 {
+			t.Errorf("%s has a full output of %v, want 100", name, p.FullOutput())
+		}
+	}
+	if psi := DefaultProfiles()["psi"]; psi.Unit != Absolute {
+		t.Errorf("psi unit is %q, want absolute like create_csv.py", psi.Unit)
+	}
+}
+
+func TestToPPFD(t *testing.T) {
+	p := testProfile()
+	got, err := p.ToPPFD([]float64{50, 100, 0, 10})
+	if want := []float64{100, 150, 0, 5}; err != nil || !closeTo(got, want) {
+		t.Errorf("ToPPFD = %v, %v, want %v", got, err, want)
+	}
+
+	absolute := Profile{Name: "dac", Unit: Absolute, Max: 4095, Channels: []Channel{{Name: "red", MaxPPFD: 300}}}
+	got, err = absolute.ToPPFD([]float64{1365})
+	if want := []float64{100}; err != nil || !closeTo(got, want) {
+		t.Errorf("ToPPFD of an absolute fixture = %v, %v, want %v", got, err, want)
+	}
+
+	if _, err := p.ToPPFD([]float64{50}); err == nil || !strings.Contains(err.Error(), "1 values for the 4 channels") {
+		t.Errorf("ToPPFD of too few values returned %v", err)
+	}
+	unmeasured := DefaultProfiles()["heliospectra_s7"]
+	if _, err := unmeasured.ToPPFD([]float64{0, 0, 10, 0, 0, 0, 0}); err == nil ||
+		!strings.Contains(err.Error(), "has no max_ppfd") {
+		t.Errorf("ToPPFD of an unmeasured channel returned %v", err)
+	}
+	if _, err := unmeasured.ToPPFD(make([]float64, 7)); err != nil {
+		t.Errorf("ToPPFD of unmeasured channels that are off returned %v", err)
+	}
+}
+
+func TestFromPPFD(t *testing.T) {
+	p := testProfile()
+	got, err := p.FromPPFD([]float64{100, 150, 0, 5})
+	if want := []float64{50, 100, 0, 10}; err != nil || !closeTo(got, want) {
+		t.Errorf("FromPPFD = %v, %v, want %v", got, err, want)
+	}
+
+	errs := map[string][]float64{
+		"can't be negative":             {-1, 0, 0, 0},
+		"delivers at most 50 µmol/m²/s": {0, 0, 0, 60},
+		"3 values for the 4 channels":   {0, 0, 0},
+	}
+	for want, ppfd := range errs {
+		if _, err := p.FromPPFD(ppfd); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("FromPPFD(%v) returned %v, want an error containing %q", ppfd, err, want)
+		}
+	}
+}
+
+func TestFromWavebands(t *testing.T) {
+	p := testProfile()
+	// 150 red is split between the red channels by their max_ppfd
+	got, err := p.FromWavebands(map[string]float64{Blue: 100, Red: 150, FarRed: 0})
+	if want := []float64{50, 50, 50, 0}; err != nil || !closeTo(got, want) {
+		t.Errorf("FromWavebands = %v, %v, want %v", got, err, want)
+	}
+
+	errs := map[string]map[string]float64{
+		"has no uv channels":                        {UV: 10},
+		"red channels of test deliver at most 300":  {Red: 301},
+		"blue channels of test deliver at most 200": {Blue: 201},
+	}
+	for want, bands := range errs {
+		if _, err := p.FromWavebands(bands); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("FromWavebands(%v) returned %v, want an error containing %q", bands, err, want)
+		}
+	}
+
+	bands, err := p.Wavebands([]float64{50, 50, 50, 0})
+	if want := map[string]float64{Blue: 100, Red: 150, FarRed: 0}; err != nil || !reflect.DeepEqual(bands, want) {
+		t.Errorf("Wavebands = %v, %v, want %v", bands, err, want)
+	}
+}
+
+func TestLoadProfiles(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, contents string) string {
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+
+	profiles, err := LoadProfiles(write("fixtures.yaml", `
+led:
+  unit: absolute
+  max: 4095
+  channels:
+    - {name: 450nm, wavelength: 450, max_ppfd: 200}
+    - {name: white, waveband: white, max_ppfd: 300}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Profile{Name: "led", Unit: Absolute, Max: 4095, Channels: []Channel{
+		{Name: "450nm", Wavelength: 450, MaxPPFD: 200},
+		{Name: "white", Waveband: White, MaxPPFD: 300},
+	}}
+	if !reflect.DeepEqual(profiles["led"], want) {
+		t.Errorf("loaded %+v, want %+v", profiles["led"], want)
+	}
+
+	errs := []struct {
+		name, contents, want string
+	}{
+		{"unit.yaml", "led:\n  unit: lumens\n", `unit "lumens" must be percent or absolute`},
+		{"max.yaml", "led:\n  unit: absolute\n", "need a max channel value"},
+		{"negative.yaml", "led:\n  unit: percent\n  channels:\n    - {name: red, max_ppfd: -1}\n", "can't be negative"},
+		{"unknown.json", `{"led": {"unit": "percent", "colour": "red"}}`, `unknown field "colour"`},
+	}
+	for _, e := range errs {
+		if _, err := LoadProfiles(write(e.name, e.contents)); err == nil || !strings.Contains(err.Error(), e.want) {
+			t.Errorf("loading %s returned %v, want an error containing %q", e.name, err, e.want)
+		}
+	}
+
+	// the example profiles that come with the generators load
+	if _, err := LoadProfiles(filepath.Join("..", "file_generators", "fixtures", "example.yaml")); err != nil {
+		t.Error(err)
+	}
+}
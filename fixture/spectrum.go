@@ -0,0 +1,219 @@
+package fixture
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Spectrum is a light target as a total PPFD and how it is shared between wavebands, eg. 400 µmol/m²/s with red and
+// blue at 3:1 and 5% far-red.
+type Spectrum struct {
+	// Total is the PPFD in µmol/m²/s
+	Total float64
+	// Percent is the percentage of Total of wavebands that have a fixed share
+	Percent map[string]float64
+	// Ratio is how the rest of Total is shared between the other wavebands
+	Ratio map[string]float64
+}
+
+// ParseSpectrum parses a spectrum from fields separated by spaces, eg. "400 red:blue=3:1 far-red=5%".
+// the field that is a number is the total PPFD in µmol/m²/s, "band=N%" is a fixed percentage of the total, and
+// "band=N" or "band:band=N:N" are ratios that the rest of the total is shared in. "0" on its own is lights off.
+func ParseSpectrum(s string) (Spectrum, error) {
+	spectrum := Spectrum{Total: -1, Percent: map[string]float64{}, Ratio: map[string]float64{}}
+	for _, field := range strings.Fields(s) {
+		eq := strings.Index(field, "=")
+		if eq < 0 {
+			total, err := strconv.ParseFloat(field, 64)
+			if err != nil {
+				return spectrum, errors.Errorf("%q is not a total PPFD or band=value", field)
+			}
+			if spectrum.Total >= 0 {
+				return spectrum, errors.Errorf("more than one total PPFD in %q", s)
+			}
+			spectrum.Total = total
+			continue
+		}
+		bands, values := strings.Split(field[:eq], ":"), field[eq+1:]
+		if percent := strings.TrimSuffix(values, "%"); percent != values {
+			if len(bands) != 1 {
+				return spectrum, errors.Errorf("%q: percentages are for one band, not ratios", field)
+			}
+			v, err := strconv.ParseFloat(percent, 64)
+			if err != nil {
+				return spectrum, errors.Errorf("%q: %q is not a percentage", field, values)
+			}
+			spectrum.Percent[bands[0]] = v
+			continue
+		}
+		ratio := strings.Split(values, ":")
+		if len(ratio) != len(bands) {
+			return spectrum, errors.Errorf("%q has %d bands and %d ratios", field, len(bands), len(ratio))
+		}
+		for i, band := range bands {
+			v, err := strconv.ParseFloat(ratio[i], 64)
+			if err != nil {
+				return spectrum, errors.Errorf("%q: %q is not a ratio", field, ratio[i])
+			}
+			spectrum.Ratio[band] = v
+		}
+	}
+	if spectrum.Total < 0 {
+		return spectrum, errors.Errorf("no total PPFD in %q", s)
+	}
+	return spectrum, spectrum.Validate()
+}
+
+// Validate returns an error if the shares of the spectrum don't add up to its total
+func (s Spectrum) Validate() error {
+	var percent, ratio float64
+	for band, v := range s.Percent {
+		if v < 0 {
+			return errors.Errorf("%s percentage %v can't be negative", band, v)
+		}
+		if _, ok := s.Ratio[band]; ok {
+			return errors.Errorf("%s has both a percentage and a ratio", band)
+		}
+		percent += v
+	}
+	for band, v := range s.Ratio {
+		if v < 0 {
+			return errors.Errorf("%s ratio %v can't be negative", band, v)
+		}
+		ratio += v
+	}
+	switch {
+	case s.Total < 0:
+		return errors.Errorf("total PPFD %v can't be negative", s.Total)
+	case percent > 100:
+		return errors.Errorf("percentages add up to %v%%", percent)
+	case percent < 100 && ratio == 0 && s.Total > 0 && len(s.Ratio) > 0:
+		return errors.Errorf("ratios add up to 0, so %v%% of the total isn't shared between bands", 100-percent)
+	case percent < 100 && ratio == 0 && s.Total > 0:
+		return errors.Errorf("only %v%% of the total is shared between bands", percent)
+	}
+	return nil
+}
+
+// Shares returns the fraction of the total of each waveband. bands with a ratio get none of the total if the ratios add
+// up to 0.
+func (s Spectrum) Shares() map[string]float64 {
+	shares := make(map[string]float64)
+	rest := 1.0
+	for band, v := range s.Percent {
+		shares[band] = v / 100
+		rest -= v / 100
+	}
+	var ratio float64
+	for _, v := range s.Ratio {
+		ratio += v
+	}
+	for band, v := range s.Ratio {
+		if ratio == 0 {
+			shares[band] = 0
+			continue
+		}
+		shares[band] = math.Max(0, rest) * v / ratio
+	}
+	return shares
+}
+
+// Wavebands returns the PPFD in µmol/m²/s of each waveband
+func (s Spectrum) Wavebands() map[string]float64 {
+	bands := s.Shares()
+	for band, share := range bands {
+		bands[band] = s.Total * share
+	}
+	return bands
+}
+
+// Scale returns the spectrum with its total scaled by level, eg. during a ramp
+func (s Spectrum) Scale(level float64) Spectrum {
+	s.Total *= level
+	return s
+}
+
+// String formats the spectrum the way ParseSpectrum parses it, with bands in alphabetical order
+func (s Spectrum) String() string {
+	format := func(v float64) string {
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	fields := []string{format(s.Total)}
+	var ratioBands, ratios []string
+	for _, band := range sortedKeys(s.Ratio) {
+		ratioBands = append(ratioBands, band)
+		ratios = append(ratios, format(s.Ratio[band]))
+	}
+	if len(ratioBands) > 0 {
+		fields = append(fields, strings.Join(ratioBands, ":")+"="+strings.Join(ratios, ":"))
+	}
+	for _, band := range sortedKeys(s.Percent) {
+		fields = append(fields, fmt.Sprintf("%s=%s%%", band, format(s.Percent[band])))
+	}
+	return strings.Join(fields, " ")
+}
+
+// sortedKeys returns the keys of m in alphabetical order
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// MaxTotal returns the largest total PPFD in µmol/m²/s of a spectrum that the fixture can deliver, which is limited by
+// the waveband that reaches the MaxPPFD of its channels first. returns an error if the fixture has no channels in a
+// waveband of the spectrum.
+func (p Profile) MaxTotal(s Spectrum) (float64, error) {
+	channels := p.BandChannels()
+	shares := s.Shares()
+	max := math.Inf(1)
+	for _, band := range sortedKeys(shares) {
+		share := shares[band]
+		if share == 0 {
+			continue
+		}
+		if len(channels[band]) == 0 {
+			return 0, errors.Errorf("%s has no %s channels", p.Name, band)
+		}
+		var bandMax float64
+		for _, i := range channels[band] {
+			bandMax += p.Channels[i].MaxPPFD
+		}
+		max = math.Min(max, bandMax/share)
+	}
+	return max, nil
+}
+
+// Solve returns the channel values that deliver a spectrum. the channels of each waveband are all set to the same
+// fraction of their full output. returns an error if the fixture can't deliver the spectrum's total, with the largest
+// total it can deliver.
+func (p Profile) Solve(s Spectrum) ([]float64, error) {
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	max, err := p.MaxTotal(s)
+	if err != nil {
+		return nil, err
+	}
+	// allow for rounding in the shares of bands that are at their limit
+	if s.Total > max*(1+1e-9) {
+		return nil, errors.Errorf("%s can deliver at most %.4g µmol/m²/s of %q", p.Name, max, s.String())
+	}
+	bands := s.Wavebands()
+	channels := p.BandChannels()
+	for band, v := range bands {
+		var bandMax float64
+		for _, i := range channels[band] {
+			bandMax += p.Channels[i].MaxPPFD
+		}
+		bands[band] = math.Min(v, bandMax)
+	}
+	return p.FromWavebands(bands)
+}
@@ -0,0 +1,164 @@
+package fixture
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testProfile is a measured percent fixture with one blue, two red and one far-red channel
+func testProfile() Profile {
+	return Profile{
+		Name: "test",
+		Unit: Percent,
+		Channels: []Channel{
+			{Name: "450nm", Wavelength: 450, MaxPPFD: 200},
+			{Name: "630nm", Wavelength: 630, MaxPPFD: 150},
+			{Name: "660nm", Wavelength: 660, MaxPPFD: 150},
+			{Name: "735nm", Wavelength: 735, MaxPPFD: 50},
+		},
+	}
+}
+
+// closeTo returns true if every value of got is within 1e-9 of want
+func closeTo(got, want []float64) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range want {
+		if math.IsNaN(got[i]) || math.Abs(got[i]-want[i]) > 1e-9 {
+			return false
+		}
+	}
+	return true
+}
+
+func TestParseSpectrum(t *testing.T) {
+	tests := map[string]Spectrum{
+		"400 red:blue=3:1 far-red=5%": {
+			Total:   400,
+			Percent: map[string]float64{"far-red": 5},
+			Ratio:   map[string]float64{"red": 3, "blue": 1},
+		},
+		"0":           {Total: 0, Percent: map[string]float64{}, Ratio: map[string]float64{}},
+		"white=1 250": {Total: 250, Percent: map[string]float64{}, Ratio: map[string]float64{"white": 1}},
+		"400 red=100% blue=0": {
+			Total:   400,
+			Percent: map[string]float64{"red": 100},
+			Ratio:   map[string]float64{"blue": 0},
+		},
+		"0 red:blue=0:0": {Total: 0, Percent: map[string]float64{}, Ratio: map[string]float64{"red": 0, "blue": 0}},
+	}
+	for s, want := range tests {
+		got, err := ParseSpectrum(s)
+		if err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("ParseSpectrum(%q) = %+v, %v, want %+v", s, got, err, want)
+		}
+	}
+
+	errs := map[string]string{
+		"red=50%":                 "no total PPFD",
+		"400 500":                 "more than one total",
+		"400 bright":              "not a total PPFD",
+		"400 red:blue=3":          "2 bands and 1 ratios",
+		"400 red:blue=50%":        "percentages are for one band",
+		"400 red=x":               "not a ratio",
+		"400 red=50% blue=60%":    "add up to 110%",
+		"400 red=50%":             "only 50% of the total",
+		"400 red:blue=0:0":        "ratios add up to 0",
+		"400 red=80% blue:uv=0:0": "ratios add up to 0, so 20% of the total",
+		"400 red=-1":              "can't be negative",
+	}
+	for s, want := range errs {
+		if _, err := ParseSpectrum(s); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("ParseSpectrum(%q) returned %v, want an error containing %q", s, err, want)
+		}
+	}
+}
+
+func TestSpectrumValidate(t *testing.T) {
+	tests := []struct {
+		spectrum Spectrum
+		valid    bool
+	}{
+		{Spectrum{Total: 0}, true},
+		{Spectrum{Total: 400, Ratio: map[string]float64{"red": 1}}, true},
+		{Spectrum{Total: 400, Percent: map[string]float64{"red": 100}, Ratio: map[string]float64{"blue": 0}}, true},
+		{Spectrum{Total: 0, Ratio: map[string]float64{"red": 0, "blue": 0}}, true},
+		{Spectrum{Total: -1, Ratio: map[string]float64{"red": 1}}, false},
+		{Spectrum{Total: 400}, false},
+		{Spectrum{Total: 400, Ratio: map[string]float64{"red": 0, "blue": 0}}, false},
+		{Spectrum{Total: 400, Percent: map[string]float64{"red": 10}, Ratio: map[string]float64{"red": 1}}, false},
+		{Spectrum{Total: 400, Ratio: map[string]float64{"red": -1, "blue": 2}}, false},
+	}
+	for _, test := range tests {
+		if err := test.spectrum.Validate(); (err == nil) != test.valid {
+			t.Errorf("Validate of %+v returned %v, want valid %v", test.spectrum, err, test.valid)
+		}
+	}
+}
+
+func TestSpectrumShares(t *testing.T) {
+	tests := map[string]map[string]float64{
+		"400 red:blue=3:1 far-red=5%": {"red": 0.7125, "blue": 0.2375, "far-red": 0.05},
+		"400 red=100% blue=0":         {"red": 1, "blue": 0},
+		"0 red:blue=0:0":              {"red": 0, "blue": 0},
+		"0":                           {},
+	}
+	for s, want := range tests {
+		spectrum, err := ParseSpectrum(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		shares := spectrum.Shares()
+		if len(shares) != len(want) {
+			t.Errorf("Shares of %q = %v, want %v", s, shares, want)
+		}
+		for band, w := range want {
+			if got := shares[band]; math.IsNaN(got) || math.Abs(got-w) > 1e-9 {
+				t.Errorf("Shares of %q = %v, want %v", s, shares, want)
+			}
+		}
+	}
+}
+
+func TestSolve(t *testing.T) {
+	p := testProfile()
+	tests := map[string][]float64{
+		// 285 red is shared between the red channels by their max_ppfd, 95 blue and 20 far-red
+		"400 red:blue=3:1 far-red=5%": {47.5, 95, 95, 40},
+		"200 red=100% blue=0":         {0, 200.0 / 3, 200.0 / 3, 0},
+		"0 red:blue=0:0":              {0, 0, 0, 0},
+		"0":                           {0, 0, 0, 0},
+		// red runs out first at 300, which is three quarters of 400
+		"400 red:blue=3:1": {50, 100, 100, 0},
+	}
+	for s, want := range tests {
+		spectrum, err := ParseSpectrum(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := p.Solve(spectrum)
+		if err != nil || !closeTo(got, want) {
+			t.Errorf("Solve(%q) = %v, %v, want %v", s, got, err, want)
+		}
+	}
+
+	errs := map[string]string{
+		"401 red:blue=3:1": "can deliver at most 400",
+		"400 uv=100%":      "has no uv channels",
+	}
+	for s, want := range errs {
+		spectrum, err := ParseSpectrum(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := p.Solve(spectrum); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Solve(%q) returned %v, want an error containing %q", s, err, want)
+		}
+	}
+	if _, err := p.Solve(Spectrum{Total: 400, Ratio: map[string]float64{"red": 0}}); err == nil {
+		t.Error("solved a spectrum whose ratios add up to 0")
+	}
+}
@@ -0,0 +1,333 @@
+// Package generator writes conditions files from a declarative spec, replacing the python generators in
+// file_generators so that programs can be made on the chamber hosts without python.
+package generator
+
+import (
+	"fmt"
+	"github.com/appf-anu/chamber-tools"
+	"github.com/appf-anu/chamber-tools/fixture"
+	"github.com/appf-anu/chamber-tools/internal/config"
+	"github.com/pkg/errors"
+	"log"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LightPreset is the light columns of a chamber or light fixture model
+type LightPreset struct {
+	Headers []string
+	// Full is the value of every light column at full output
+	Full float64
+}
+
+// LightPresets are the lights_headers of create_xlsx.py keyed by the same names, with a channel for each channel of
+// the fixture.DefaultProfiles of the same name.
+// conviron's light1 and light2 are written as 0 to 100 like every light header of create_xlsx.py, which is what the
+// conviron DefaultLimitProfiles allow.
+func LightPresets() map[string]LightPreset {
+	presets := map[string]LightPreset{
+		"conviron": {Headers: []string{"light1", "light2"}, Full: 100},
+	}
+	for name, profile := range fixture.DefaultProfiles() {
+		headers := make([]string, len(profile.Channels))
+		for i := range headers {
+			headers[i] = fmt.Sprintf("channel-%d", i+1)
+		}
+		presets[name] = LightPreset{Headers: headers, Full: profile.FullOutput()}
+	}
+	return presets
+}
+
+// Spec is a diurnal conditions program, with the same keys as the settings of create_xlsx.py.
+// the lights are at full output, scaled by ChannelsScaling, from DayStart until DayEnd, or from sunrise until sunset at
+// Site, and off at night, with optional dawn and dusk ramps.
+type Spec struct {
+	// Start is the date of the first timepoint as YYYY-MM-DD, defaults to today
+	Start string `json:"start,omitempty" yaml:"start,omitempty"`
+	// IntervalMinutes is the time between timepoints
+	IntervalMinutes float64 `json:"interval_m" yaml:"interval_m"`
+	LengthDays      int     `json:"length_days" yaml:"length_days"`
+	// DayStart and DayEnd are the hours of the day that the day starts and ends at
+	DayStart float64 `json:"day_start" yaml:"day_start"`
+	DayEnd   float64 `json:"day_end" yaml:"day_end"`
+	// Site, if it is set, replaces DayStart and DayEnd with the sunrise and sunset at the site on each day, and adds a
+	// totalsolar column that follows the elevation of the sun
+	Site *Site `json:"site,omitempty" yaml:"site,omitempty"`
+	// DayTemperature and NightTemperature are in °C
+	DayTemperature   float64 `json:"day_temp" yaml:"day_temp"`
+	NightTemperature float64 `json:"night_temp" yaml:"night_temp"`
+	// Humidity is in %RH
+	Humidity float64 `json:"humidity" yaml:"humidity"`
+	// Lights is the name of a LightPresets entry
+	Lights string `json:"lights" yaml:"lights"`
+	// Full overrides the full output of the light preset if it is set
+	Full float64 `json:"full,omitempty" yaml:"full,omitempty"`
+	// ChannelsScaling scales the full output of each light column, it must be empty or have one entry per column
+	ChannelsScaling []float64 `json:"channels_scaling,omitempty" yaml:"channels_scaling,omitempty"`
+	// Spectrum, if it is set, replaces the full output of the lights with the channel values that deliver a spectrum
+	// like "400 red:blue=3:1 far-red=5%", see fixture.ParseSpectrum. ramps scale its total.
+	Spectrum string `json:"spectrum,omitempty" yaml:"spectrum,omitempty"`
+	// Profiles is the json or yaml file of fixture profiles that Spectrum is solved with, using the profile named
+	// after Lights. LoadSpec makes relative paths relative to the spec file.
+	Profiles string `json:"profiles,omitempty" yaml:"profiles,omitempty"`
+	// LightRamp ramps the lights instead of switching them on at DayStart and off at DayEnd
+	LightRamp Ramp `json:"light_ramp,omitempty" yaml:"light_ramp,omitempty"`
+	// TemperatureRamp ramps between NightTemperature and DayTemperature
+	TemperatureRamp Ramp `json:"temperature_ramp,omitempty" yaml:"temperature_ramp,omitempty"`
+	// RampStepMinutes is the time between the rows that are added during ramps, defaults to IntervalMinutes
+	RampStepMinutes float64 `json:"ramp_step_m,omitempty" yaml:"ramp_step_m,omitempty"`
+	// Filename is the file to write, a name is made from the spec if it is empty
+	Filename string `json:"filename,omitempty" yaml:"filename,omitempty"`
+}
+
+// DefaultSpec returns the defaults of create_xlsx.py
+func DefaultSpec() Spec {
+	return Spec{
+		IntervalMinutes:  10,
+		LengthDays:       2,
+		DayStart:         7,
+		DayEnd:           23,
+		DayTemperature:   28,
+		NightTemperature: 20,
+		Humidity:         55,
+		Lights:           "conviron",
+	}
+}
+
+// LoadSpec reads a spec from a .json or .yaml file, keys that are missing from the file keep their DefaultSpec value
+func LoadSpec(specPath string) (Spec, error) {
+	spec := DefaultSpec()
+	if err := config.Decode(specPath, "spec", &spec); err != nil {
+		return spec, err
+	}
+	if spec.Profiles != "" && !filepath.IsAbs(spec.Profiles) {
+		spec.Profiles = filepath.Join(filepath.Dir(specPath), spec.Profiles)
+	}
+	return spec, nil
+}
+
+// interval returns the time between timepoints, rounded to the second as conditions files store whole seconds
+func (spec Spec) interval() time.Duration {
+	return time.Duration(spec.IntervalMinutes * float64(time.Minute)).Round(time.Second)
+}
+
+// start returns midnight at the start of the first day
+func (spec Spec) start(now time.Time) (time.Time, error) {
+	if spec.Start == "" {
+		return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local), nil
+	}
+	start, err := time.ParseInLocation("2006-01-02", spec.Start, time.Local)
+	if err != nil {
+		return start, errors.Wrapf(err, "start %q", spec.Start)
+	}
+	return start, nil
+}
+
+// Validate returns an error if the spec can't be generated
+func (spec Spec) Validate() error {
+	preset, ok := LightPresets()[spec.Lights]
+	switch {
+	case !ok:
+		return errors.Errorf("unknown lights %q", spec.Lights)
+	case spec.interval() < time.Second:
+		return errors.Errorf("interval_m %v is shorter than a second", spec.IntervalMinutes)
+	case spec.LengthDays <= 0:
+		return errors.Errorf("length_days %d must be at least 1", spec.LengthDays)
+	case spec.DayStart < 0 || spec.DayStart > 24 || spec.DayEnd < 0 || spec.DayEnd > 24:
+		return errors.Errorf("day_start %v and day_end %v must be hours from 0 to 24", spec.DayStart, spec.DayEnd)
+	case spec.DayEnd < spec.DayStart:
+		return errors.Errorf("day_end %v is before day_start %v", spec.DayEnd, spec.DayStart)
+	case len(spec.ChannelsScaling) > 0 && len(spec.ChannelsScaling) != len(preset.Headers):
+		return errors.Errorf("%d channels_scaling for %d %s lights",
+			len(spec.ChannelsScaling), len(preset.Headers), spec.Lights)
+	}
+	if spec.Spectrum != "" {
+		switch {
+		case preset.Headers[0] == "light1":
+			return errors.Errorf("%s lights don't have channels to solve a spectrum for", spec.Lights)
+		case spec.Full != 0 || len(spec.ChannelsScaling) > 0:
+			return errors.New("spectrum replaces full and channels_scaling, they can't be used together")
+		case spec.Profiles == "":
+			return errors.New("spectrum needs a profiles file with the max_ppfd of each channel")
+		}
+		if _, err := fixture.ParseSpectrum(spec.Spectrum); err != nil {
+			return errors.Wrap(err, "spectrum")
+		}
+	}
+	// the photoperiod of a site changes, ramps that are longer than the day are cut short on short days
+	dayHours := 24.0
+	if spec.Site != nil {
+		if err := spec.Site.validate(); err != nil {
+			return errors.Wrap(err, "site")
+		}
+	} else {
+		dayHours = spec.DayEnd - spec.DayStart
+	}
+	if err := spec.LightRamp.validate("light_ramp", dayHours); err != nil {
+		return err
+	}
+	if err := spec.TemperatureRamp.validate("temperature_ramp", dayHours); err != nil {
+		return err
+	}
+	_, err := spec.start(time.Now())
+	return err
+}
+
+// clockTime formats hours of the day as HHMM, create_xlsx.py writes whole hours as "0700" with {day_start:02d}00
+func clockTime(hours float64) string {
+	minutes := int(math.Round(hours * 60))
+	return fmt.Sprintf("%02d%02d", minutes/60, minutes%60)
+}
+
+// pythonFloat formats a float like python's str(), which always has a decimal point
+func pythonFloat(v float64) string {
+	s := strconv.FormatFloat(v, 'f', -1, 64)
+	if !strings.ContainsAny(s, ".eIN") {
+		s += ".0"
+	}
+	return s
+}
+
+// DefaultFilename returns the file name create_xlsx.py gives the spec, with the extension ext.
+// fractional day hours, which create_xlsx.py can't format, are written as minutes, eg. 7.5 as "0730".
+func (spec Spec) DefaultFilename(ext string) string {
+	day := clockTime(spec.DayStart) + "to" + clockTime(spec.DayEnd)
+	if spec.Site != nil {
+		day = fmt.Sprintf("%v,%v", spec.Site.Latitude, spec.Site.Longitude)
+	}
+	name := fmt.Sprintf("%vm-for-%dd-%s_%vC-%vC_%vrh_%s", spec.IntervalMinutes, spec.LengthDays,
+		day, spec.DayTemperature, spec.NightTemperature, spec.Humidity, spec.Lights)
+	if len(spec.ChannelsScaling) > 0 {
+		// create_xlsx.py writes the python list without spaces, eg. "-[1.0,0.6]"
+		scaling := make([]string, len(spec.ChannelsScaling))
+		for i, v := range spec.ChannelsScaling {
+			scaling[i] = pythonFloat(v)
+		}
+		name += "-[" + strings.Join(scaling, ",") + "]"
+	}
+	if spec.Spectrum != "" {
+		if spectrum, err := fixture.ParseSpectrum(spec.Spectrum); err == nil {
+			name += fmt.Sprintf("-%vppfd", spectrum.Total)
+		}
+	}
+	name += spec.LightRamp.filename("lights") + spec.TemperatureRamp.filename("temp")
+	return name + ext
+}
+
+// Headers returns the header line of the conditions file for the spec
+func (spec Spec) Headers() []string {
+	headers := []string{"datetime", "datetime-sim", "humidity", "temperature"}
+	if spec.Site != nil {
+		headers = append(headers, "totalsolar")
+	}
+	return append(headers, LightPresets()[spec.Lights].Headers...)
+}
+
+// daylight returns the hours of the day that a day starts and ends at, and the elevation of the sun at solar noon
+// if the spec has a Site
+func (spec Spec) daylight(day time.Time) (start, end, noonElevation float64) {
+	if spec.Site != nil {
+		return spec.Site.daylight(day)
+	}
+	return spec.DayStart, spec.DayEnd, 90
+}
+
+// levels returns the value of each light column at full output, which is the channel values that deliver the
+// Spectrum if it is set
+func (spec Spec) levels() ([]float64, error) {
+	preset := LightPresets()[spec.Lights]
+	if spec.Spectrum == "" {
+		full := preset.Full
+		if spec.Full != 0 {
+			full = spec.Full
+		}
+		levels := make([]float64, len(preset.Headers))
+		for i := range levels {
+			levels[i] = full
+			if len(spec.ChannelsScaling) > 0 {
+				levels[i] *= spec.ChannelsScaling[i]
+			}
+		}
+		return levels, nil
+	}
+
+	profiles, err := fixture.LoadProfiles(spec.Profiles)
+	if err != nil {
+		return nil, err
+	}
+	profile, ok := profiles[spec.Lights]
+	if !ok {
+		return nil, errors.Errorf("no fixture profile named %q in %s", spec.Lights, spec.Profiles)
+	}
+	if len(profile.Channels) != len(preset.Headers) {
+		return nil, errors.Errorf("fixture profile %s has %d channels, %s lights have %d",
+			profile.Name, len(profile.Channels), spec.Lights, len(preset.Headers))
+	}
+	spectrum, _ := fixture.ParseSpectrum(spec.Spectrum)
+	levels, err := profile.Solve(spectrum)
+	if err != nil {
+		return nil, errors.Wrap(err, "spectrum")
+	}
+	return levels, nil
+}
+
+// round rounds ramped values to 2 decimal places, so that they are readable in the conditions file
+func round(v float64) float64 {
+	return math.Round(v*100) / 100
+}
+
+// Generate returns the schedule described by the spec, from midnight on the start date for LengthDays days.
+// ramps are written as rows every RampStepMinutes, so that they are followed by runners that step between rows.
+func Generate(errLog *log.Logger, spec Spec) (*chamber_tools.Schedule, error) {
+	if err := spec.Validate(); err != nil {
+		return nil, err
+	}
+	preset := LightPresets()[spec.Lights]
+	levels, err := spec.levels()
+	if err != nil {
+		return nil, err
+	}
+	// conviron lights are whole levels, the other presets are channels
+	conviron := preset.Headers[0] == "light1"
+
+	start, _ := spec.start(time.Now())
+	end := start.AddDate(0, 0, spec.LengthDays)
+	var timepoints []chamber_tools.TimePoint
+	for _, t := range spec.datetimes(start, end) {
+		hour := hourOfDay(t)
+		dayStart, dayEnd, noonElevation := spec.daylight(t)
+		temperature := spec.TemperatureRamp.level(hour, dayStart, dayEnd)
+		tp := chamber_tools.TimePoint{
+			Datetime:         t,
+			SimDatetime:      t,
+			RelativeHumidity: chamber_tools.NewNullFloat64(spec.Humidity),
+			Temperature: chamber_tools.NewNullFloat64(
+				round(spec.NightTemperature + (spec.DayTemperature-spec.NightTemperature)*temperature)),
+		}
+		light := spec.LightRamp.level(hour, dayStart, dayEnd)
+		if spec.Site != nil {
+			tp.TotalSolar = chamber_tools.NewNullFloat64(round(spec.Site.totalSolar(t)))
+			if spec.Site.FollowElevation && light > 0 {
+				elevation := math.Max(0, spec.Site.Elevation(spec.Site.atSite(t)))
+				light *= math.Min(1, math.Sin(radians(elevation))/math.Sin(radians(noonElevation)))
+			}
+		}
+		lights := make([]float64, len(levels))
+		for i, v := range levels {
+			lights[i] = round(v * light)
+		}
+		if conviron {
+			tp.Light1 = chamber_tools.NewNullInt(int(math.Round(lights[0])))
+			tp.Light2 = chamber_tools.NewNullInt(int(math.Round(lights[1])))
+		} else {
+			for _, v := range lights {
+				tp.Channels = append(tp.Channels, chamber_tools.NewNullFloat64(v))
+			}
+		}
+		timepoints = append(timepoints, tp)
+	}
+	return chamber_tools.NewSchedule(errLog, spec.Headers(), timepoints), nil
+}
@@ -0,0 +1,205 @@
+package generator
+
+import (
+	"github.com/appf-anu/chamber-tools"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// discardLog is the errLog of tests, the loaders log every file they read
+var discardLog = log.New(io.Discard, "", 0)
+
+// ch36 is the "settings for ch36 2020-02-24" of create_xlsx.py
+func ch36() Spec {
+	spec := DefaultSpec()
+	spec.Start = "2020-02-24"
+	spec.Lights = "psi"
+	spec.DayTemperature, spec.NightTemperature = 28, 22
+	spec.DayStart, spec.DayEnd = 6, 22
+	spec.Humidity = 60
+	spec.ChannelsScaling = []float64{1.0, 1.0, 1.0, 0.6, 0.6, 0.6, 0.0, 0.0}
+	return spec
+}
+
+func TestDefaultFilename(t *testing.T) {
+	halfHours := DefaultSpec()
+	halfHours.DayStart, halfHours.DayEnd = 7.5, 19.25
+	tests := []struct {
+		spec Spec
+		want string
+	}{
+		{DefaultSpec(), "10m-for-2d-0700to2300_28C-20C_55rh_conviron.xlsx"},
+		{ch36(), "10m-for-2d-0600to2200_28C-22C_60rh_psi-[1.0,1.0,1.0,0.6,0.6,0.6,0.0,0.0].xlsx"},
+		{halfHours, "10m-for-2d-0730to1915_28C-20C_55rh_conviron.xlsx"},
+	}
+	for _, test := range tests {
+		if got := test.spec.DefaultFilename(".xlsx"); got != test.want {
+			t.Errorf("DefaultFilename = %q, want %q", got, test.want)
+		}
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	s, err := Generate(discardLog, ch36())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Len() != 2*24*6 {
+		t.Errorf("generated %d timepoints, want one every 10 minutes for 2 days", s.Len())
+	}
+	start := time.Date(2020, 2, 24, 0, 0, 0, 0, time.Local)
+	if !s.Start().Equal(start) {
+		t.Errorf("starts at %v, want %v", s.Start(), start)
+	}
+
+	night, _ := s.At(start.Add(time.Hour * 5))
+	day, _ := s.At(start.Add(time.Hour * 12))
+	if night.Temperature.Float64 != 22 || night.Channels[0].Float64 != 0 {
+		t.Errorf("night is %s", night.NulledString())
+	}
+	if day.Temperature.Float64 != 28 || day.RelativeHumidity.Float64 != 60 || day.Channels[0].Float64 != 100 ||
+		day.Channels[3].Float64 != 60 || day.Channels[7].Float64 != 0 {
+		t.Errorf("day is %s", day.NulledString())
+	}
+	if len(s.Indices.ChannelsIdx) != 8 || s.Indices.Light1Idx != -1 {
+		t.Errorf("psi column layout is %v", s.Indices.Headers())
+	}
+
+	// conviron lights are written at 100 during the day, like create_xlsx.py, and are inside the conviron limits
+	s, err = Generate(discardLog, DefaultSpec())
+	if err != nil {
+		t.Fatal(err)
+	}
+	day, _ = s.At(s.Start().Add(time.Hour * 12))
+	if day.Light1.Int != 100 || day.Light2.Int != 100 {
+		t.Errorf("conviron day is %s", day.NulledString())
+	}
+	if violations := chamber_tools.DefaultLimitProfiles()["conviron"].Check(s.TimePoints); len(violations) != 0 {
+		t.Errorf("conviron schedule is outside the conviron limits: %v", violations)
+	}
+}
+
+// TestGenerateRoundTrip writes generated schedules and checks that LoadSchedule reads back the same timepoints
+// inLocation sets time.Local to the timezone name for the rest of the test
+func inLocation(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Skipf("no %s timezone: %v", name, err)
+	}
+	local := time.Local
+	time.Local = loc
+	t.Cleanup(func() { time.Local = local })
+	return loc
+}
+
+func TestGenerateRoundTrip(t *testing.T) {
+	conviron := DefaultSpec()
+	conviron.Start = "2020-06-01"
+	conviron.LengthDays = 1
+	conviron.LightRamp = Ramp{DawnMinutes: 60, DuskMinutes: 30, Shape: Sine}
+	site := ch36()
+	site.Site = &Site{Latitude: -35.28, Longitude: 149.13}
+	site.LengthDays = 1
+	// the days start in the hour that is skipped or repeated when daylight saving time starts or ends in Berlin
+	spring := DefaultSpec()
+	spring.Start = "2020-03-28"
+	spring.DayStart = 2.5
+	spring.LightRamp = Ramp{DawnMinutes: 60, DuskMinutes: 60, Shape: Linear}
+	autumn := spring
+	autumn.Start = "2020-10-24"
+
+	specs := map[string]Spec{"conviron": conviron, "psi": ch36(), "site": site, "spring": spring, "autumn": autumn}
+	for _, name := range []string{"Local", "Europe/Berlin"} {
+		t.Run(name, func(t *testing.T) {
+			inLocation(t, name)
+			for name, spec := range specs {
+				testGenerateRoundTrip(t, name, spec)
+			}
+		})
+	}
+}
+
+// testGenerateRoundTrip generates spec and checks that the csv and xlsx files written from it are read back the same
+func testGenerateRoundTrip(t *testing.T, name string, spec Spec) {
+	t.Helper()
+	s, err := Generate(discardLog, spec)
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	for _, ext := range []string{".csv", ".xlsx"} {
+		conditionsPath := filepath.Join(t.TempDir(), spec.DefaultFilename(ext))
+		if err := s.WriteFile(conditionsPath); err != nil {
+			t.Fatalf("%s%s: %v", name, ext, err)
+		}
+		read, err := chamber_tools.LoadSchedule(discardLog, conditionsPath)
+		if err != nil {
+			t.Fatalf("%s%s: %v", name, ext, err)
+		}
+		if read.Len() != s.Len() {
+			t.Fatalf("%s%s: read %d timepoints, wrote %d", name, ext, read.Len(), s.Len())
+		}
+		for i, tp := range s.TimePoints {
+			if !tp.Equal(read.TimePoints[i]) {
+				t.Errorf("%s%s: TimePoint %d differs:\n\twrote %s\n\tread  %s", name, ext, i,
+					tp.NulledString(), read.TimePoints[i].NulledString())
+				break
+			}
+		}
+	}
+}
+
+func TestLoadSpec(t *testing.T) {
+	dir := t.TempDir()
+	specPath := filepath.Join(dir, "spec.yaml")
+	contents := "lights: heliospectra_s7\nday_temp: 25\nprofiles: fixtures.yaml\n"
+	if err := os.WriteFile(specPath, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	spec, err := LoadSpec(specPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := DefaultSpec()
+	if spec.Lights != "heliospectra_s7" || spec.DayTemperature != 25 ||
+		spec.NightTemperature != want.NightTemperature || spec.IntervalMinutes != want.IntervalMinutes {
+		t.Errorf("loaded %+v, want the defaults for missing keys", spec)
+	}
+	if spec.Profiles != filepath.Join(dir, "fixtures.yaml") {
+		t.Errorf("profiles is %q, want it relative to the spec", spec.Profiles)
+	}
+
+	if err := os.WriteFile(specPath, []byte("day_temperature: 25\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadSpec(specPath); err == nil {
+		t.Error("loaded a spec with an unknown key")
+	}
+}
+
+func TestSpecValidate(t *testing.T) {
+	invalid := map[string]func(spec *Spec){
+		"unknown lights":    func(spec *Spec) { spec.Lights = "sun" },
+		"short interval":    func(spec *Spec) { spec.IntervalMinutes = 0.001 },
+		"no days":           func(spec *Spec) { spec.LengthDays = 0 },
+		"day end too late":  func(spec *Spec) { spec.DayEnd = 25 },
+		"day ends early":    func(spec *Spec) { spec.DayStart, spec.DayEnd = 18, 6 },
+		"scaling mismatch":  func(spec *Spec) { spec.ChannelsScaling = []float64{1} },
+		"conviron spectrum": func(spec *Spec) { spec.Spectrum = "400" },
+		"bad start":         func(spec *Spec) { spec.Start = "24/02/2020" },
+	}
+	for name, change := range invalid {
+		spec := DefaultSpec()
+		change(&spec)
+		if err := spec.Validate(); err == nil {
+			t.Errorf("%s: Validate didn't fail", name)
+		}
+	}
+	if err := ch36().Validate(); err != nil {
+		t.Errorf("ch36: %v", err)
+	}
+}
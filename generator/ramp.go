@@ -0,0 +1,179 @@
+package generator
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"math"
+	"sort"
+	"time"
+)
+
+// Shape is how a ramp changes from night to day
+type Shape string
+
+const (
+	// Linear ramps at a constant rate
+	Linear Shape = "linear"
+	// Sigmoid ramps slowly at the start and end and quickly in the middle, like a logistic curve
+	Sigmoid Shape = "sigmoid"
+	// Sine ramps along half a cosine wave, slower at the start and end than Linear but not as sharply as Sigmoid
+	Sine Shape = "sine"
+)
+
+// sigmoidSteepness is how steep the middle of a Sigmoid ramp is
+const sigmoidSteepness = 10
+
+// logistic is the logistic function centered on 0.5
+func logistic(x float64) float64 {
+	return 1 / (1 + math.Exp(-sigmoidSteepness*(x-0.5)))
+}
+
+// level returns how far a ramp of the shape is from 0 (night) to 1 (day), x is how far through the ramp it is
+func (shape Shape) level(x float64) float64 {
+	x = math.Max(0, math.Min(1, x))
+	switch shape {
+	case Sigmoid:
+		// scaled so that the ramp starts at 0 and ends at 1
+		return (logistic(x) - logistic(0)) / (logistic(1) - logistic(0))
+	case Sine:
+		return (1 - math.Cos(math.Pi*x)) / 2
+	}
+	return x
+}
+
+// Ramp is the dawn and dusk of a column. dawn starts at the start of the day and dusk ends at the end of the day, so
+// the column is at its night value outside of the day like without a ramp. on days that are shorter than the ramps the
+// column doesn't reach its day value.
+type Ramp struct {
+	// DawnMinutes is how long it takes to ramp from night to day, the column steps at DayStart if it is 0
+	DawnMinutes float64 `json:"dawn_m" yaml:"dawn_m"`
+	// DuskMinutes is how long it takes to ramp from day to night, the column steps at DayEnd if it is 0
+	DuskMinutes float64 `json:"dusk_m" yaml:"dusk_m"`
+	// Shape defaults to Linear
+	Shape Shape `json:"shape,omitempty" yaml:"shape,omitempty"`
+}
+
+// validate returns an error if the ramp doesn't fit in a day that is dayHours long
+func (r Ramp) validate(name string, dayHours float64) error {
+	switch r.Shape {
+	case "", Linear, Sigmoid, Sine:
+	default:
+		return errors.Errorf("%s shape %q must be linear, sigmoid or sine", name, r.Shape)
+	}
+	if r.DawnMinutes < 0 || r.DuskMinutes < 0 {
+		return errors.Errorf("%s dawn_m %v and dusk_m %v can't be negative", name, r.DawnMinutes, r.DuskMinutes)
+	}
+	if (r.DawnMinutes+r.DuskMinutes)/60 > dayHours {
+		return errors.Errorf("%s dawn_m %v and dusk_m %v are longer than the %v hour day",
+			name, r.DawnMinutes, r.DuskMinutes, dayHours)
+	}
+	return nil
+}
+
+// level returns how far the column is from night (0) to day (1) at hour of the day, for a day from dayStart to dayEnd
+func (r Ramp) level(hour, dayStart, dayEnd float64) float64 {
+	if hour < dayStart || hour >= dayEnd {
+		return 0
+	}
+	level := 1.0
+	if r.DawnMinutes > 0 {
+		level = math.Min(level, r.Shape.level((hour-dayStart)/(r.DawnMinutes/60)))
+	}
+	if r.DuskMinutes > 0 {
+		level = math.Min(level, r.Shape.level((dayEnd-hour)/(r.DuskMinutes/60)))
+	}
+	return level
+}
+
+// filename returns the part of DefaultFilename that describes the ramp, "" if there isn't a ramp
+func (r Ramp) filename(name string) string {
+	if r.DawnMinutes == 0 && r.DuskMinutes == 0 {
+		return ""
+	}
+	shape := r.Shape
+	if shape == "" {
+		shape = Linear
+	}
+	return fmt.Sprintf("_%s-%vm-%vm-%s", name, r.DawnMinutes, r.DuskMinutes, shape)
+}
+
+// hours returns the hours of the day that the ramp is ramping between
+func (r Ramp) hours(dayStart, dayEnd float64) [][2]float64 {
+	var hours [][2]float64
+	if r.DawnMinutes > 0 {
+		hours = append(hours, [2]float64{dayStart, math.Min(dayEnd, dayStart+r.DawnMinutes/60)})
+	}
+	if r.DuskMinutes > 0 {
+		hours = append(hours, [2]float64{math.Max(dayStart, dayEnd-r.DuskMinutes/60), dayEnd})
+	}
+	return hours
+}
+
+// hourOfDay returns the hour of the day that the clock shows at t, which isn't the hours since midnight on days that
+// daylight saving time starts or ends
+func hourOfDay(t time.Time) float64 {
+	return float64(t.Hour()) + float64(t.Minute())/60 + float64(t.Second())/3600 + float64(t.Nanosecond())/3600e9
+}
+
+// atHour returns the time on day that the clock shows hour, rounded to the second. hours that are skipped when daylight
+// saving time starts are an hour later.
+func atHour(day time.Time, hour float64) time.Time {
+	seconds := int(math.Round(hour * 3600))
+	return time.Date(day.Year(), day.Month(), day.Day(), 0, 0, seconds, 0, day.Location())
+}
+
+// wallTime returns the time that a conditions file stores for t, which only has the time on the clock. the clock
+// repeats an hour when daylight saving time ends and the times in it are read back as one of them.
+func wallTime(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+}
+
+// rampStep returns the time between the extra rows that are added during ramps
+func (spec Spec) rampStep() time.Duration {
+	step := time.Duration(spec.RampStepMinutes * float64(time.Minute)).Round(time.Second)
+	if step < time.Second || step > spec.interval() {
+		return spec.interval()
+	}
+	return step
+}
+
+// datetimes returns the datetime of every timepoint from start until end: every interval, the start and end of each
+// day, and every ramp step during the ramps so that the runners follow the ramps without interpolating. the hour that
+// is repeated when daylight saving time ends only has timepoints once, as conditions files can't tell them apart.
+func (spec Spec) datetimes(start, end time.Time) []time.Time {
+	seen := make(map[int64]bool)
+	var datetimes []time.Time
+	add := func(t time.Time) {
+		t = wallTime(t)
+		if t.Before(start) || !t.Before(end) || seen[t.UnixNano()] {
+			return
+		}
+		seen[t.UnixNano()] = true
+		datetimes = append(datetimes, t)
+	}
+
+	for t := start; t.Before(end); t = t.Add(spec.interval()) {
+		add(t)
+	}
+	step := spec.rampStep()
+	for day := start; day.Before(end); day = day.AddDate(0, 0, 1) {
+		dayStart, dayEnd, _ := spec.daylight(day)
+		if dayStart < dayEnd {
+			add(atHour(day, dayStart))
+			add(atHour(day, dayEnd))
+		}
+		for _, r := range []Ramp{spec.LightRamp, spec.TemperatureRamp} {
+			for _, hours := range r.hours(dayStart, dayEnd) {
+				from, to := atHour(day, hours[0]), atHour(day, hours[1])
+				for t := from; t.Before(to); t = t.Add(step) {
+					add(t)
+				}
+				add(to)
+			}
+		}
+	}
+	sort.Slice(datetimes, func(i, j int) bool {
+		return datetimes[i].Before(datetimes[j])
+	})
+	return datetimes
+}
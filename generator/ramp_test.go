@@ -0,0 +1,151 @@
+package generator
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestShapeLevel(t *testing.T) {
+	for _, shape := range []Shape{Linear, Sigmoid, Sine, ""} {
+		// every shape starts at night, ends at day, is halfway in the middle and stays in between outside the ramp
+		ends := map[float64]float64{-1: 0, 0: 0, 0.5: 0.5, 1: 1, 2: 1}
+		for x, want := range ends {
+			if got := shape.level(x); math.Abs(got-want) > 1e-9 {
+				t.Errorf("%q level(%v) = %v, want %v", shape, x, got, want)
+			}
+		}
+		previous := 0.0
+		for x := 0.05; x <= 1; x += 0.05 {
+			level := shape.level(x)
+			if level < previous {
+				t.Errorf("%q level(%v) = %v, less than %v before it", shape, x, level, previous)
+			}
+			previous = level
+		}
+	}
+
+	// a quarter of the way through, sigmoid is slower than sine which is slower than linear
+	linear, sine, sigmoid := Linear.level(0.25), Sine.level(0.25), Sigmoid.level(0.25)
+	if linear != 0.25 || math.Abs(sine-(1-math.Sqrt2/2)/2) > 1e-9 || !(sigmoid < sine && sine < linear) {
+		t.Errorf("levels a quarter of the way through are linear %v, sine %v, sigmoid %v", linear, sine, sigmoid)
+	}
+}
+
+func TestRampLevel(t *testing.T) {
+	r := Ramp{DawnMinutes: 60, DuskMinutes: 30, Shape: Linear}
+	tests := map[float64]float64{
+		6.99: 0, 7: 0, 7.5: 0.5, 8: 1, 12: 1,
+		22.5: 1, 22.75: 0.5, 22.99: 0.02, 23: 0, 23.5: 0,
+	}
+	for hour, want := range tests {
+		if got := r.level(hour, 7, 23); math.Abs(got-want) > 1e-9 {
+			t.Errorf("linear level at %v = %v, want %v", hour, got, want)
+		}
+	}
+
+	// without ramps the column steps at the start and end of the day
+	if (Ramp{}).level(6.99, 7, 23) != 0 || (Ramp{}).level(7, 7, 23) != 1 || (Ramp{}).level(23, 7, 23) != 0 {
+		t.Error("a column without ramps doesn't step at the start and end of the day")
+	}
+	// a day shorter than its ramps doesn't reach its day value
+	short := Ramp{DawnMinutes: 120, DuskMinutes: 120, Shape: Sine}
+	if level := short.level(8, 7, 9); math.Abs(level-Sine.level(0.5)) > 1e-9 {
+		t.Errorf("level in the middle of a 2 hour day with 2 hour ramps is %v", level)
+	}
+
+	hours := r.hours(7, 23)
+	if len(hours) != 2 || hours[0] != [2]float64{7, 8} || hours[1] != [2]float64{22.5, 23} {
+		t.Errorf("ramps are during %v", hours)
+	}
+}
+
+func TestRampValidate(t *testing.T) {
+	errs := map[string]Ramp{
+		`shape "cubic" must be`:      {DawnMinutes: 30, Shape: "cubic"},
+		"can't be negative":          {DawnMinutes: -1},
+		"are longer than the 1 hour": {DawnMinutes: 40, DuskMinutes: 30},
+	}
+	for want, r := range errs {
+		if err := r.validate("lights", 1); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("validate of %+v returned %v, want an error containing %q", r, err, want)
+		}
+	}
+	if err := (Ramp{DawnMinutes: 30, DuskMinutes: 30, Shape: Sigmoid}).validate("lights", 1); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestGenerateRamps checks that ramps have a row every ramp step that follows their shape, including their endpoints
+func TestGenerateRamps(t *testing.T) {
+	spec := DefaultSpec()
+	spec.Start = "2020-01-01"
+	spec.LengthDays = 1
+	spec.IntervalMinutes = 60
+	spec.RampStepMinutes = 15
+	spec.LightRamp = Ramp{DawnMinutes: 30, Shape: Linear}
+	spec.TemperatureRamp = Ramp{DuskMinutes: 60, Shape: Sigmoid}
+	s, err := Generate(discardLog, spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a row every hour, and 07:15, 07:30, 22:15, 22:30 and 22:45 during the ramps
+	if s.Len() != 24+5 {
+		t.Errorf("generated %d timepoints, want 29", s.Len())
+	}
+
+	day := s.Start()
+	tests := []struct {
+		hour        float64
+		light       int
+		temperature float64
+	}{
+		{6, 0, 20},
+		{7, 0, 28},
+		{7.25, 50, 28},
+		{7.5, 100, 28},
+		{22, 100, 28},
+		{22.5, 100, round(20 + 8*Sigmoid.level(0.5))},
+		{22.75, 100, round(20 + 8*Sigmoid.level(0.25))},
+		{23, 0, 20},
+	}
+	for _, test := range tests {
+		datetime := atHour(day, test.hour)
+		i := s.Index(datetime)
+		if i < 0 || !s.TimePoints[i].Datetime.Equal(datetime) {
+			t.Errorf("no TimePoint at %v", datetime)
+			continue
+		}
+		tp := s.TimePoints[i]
+		if tp.Light1.Int != test.light || tp.Temperature.Float64 != test.temperature {
+			t.Errorf("TimePoint at %v is %s, want light %d and temperature %v", datetime, tp.NulledString(),
+				test.light, test.temperature)
+		}
+	}
+}
+
+func TestAtHour(t *testing.T) {
+	berlin := inLocation(t, "Europe/Berlin")
+	tests := []struct {
+		day  time.Time
+		hour float64
+		want time.Time
+	}{
+		{time.Date(2020, 1, 1, 0, 0, 0, 0, berlin), 7.5, time.Date(2020, 1, 1, 7, 30, 0, 0, berlin)},
+		// the clock goes from 02:00 to 03:00 on the 29th of March, so 07:00 is 6 hours after midnight
+		{time.Date(2020, 3, 29, 0, 0, 0, 0, berlin), 7, time.Date(2020, 3, 29, 7, 0, 0, 0, berlin)},
+		// and from 03:00 to 02:00 on the 25th of October, so 07:00 is 8 hours after midnight
+		{time.Date(2020, 10, 25, 0, 0, 0, 0, berlin), 7, time.Date(2020, 10, 25, 7, 0, 0, 0, berlin)},
+	}
+	for _, test := range tests {
+		got := atHour(test.day, test.hour)
+		if !got.Equal(test.want) || hourOfDay(got) != test.hour {
+			t.Errorf("atHour(%v, %v) = %v, want %v", test.day, test.hour, got, test.want)
+		}
+	}
+	spring := time.Date(2020, 3, 29, 0, 0, 0, 0, berlin)
+	if elapsed := atHour(spring, 7).Sub(spring); elapsed != 6*time.Hour {
+		t.Errorf("07:00 is %v after midnight when daylight saving time starts, want 6h", elapsed)
+	}
+}
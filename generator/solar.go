@@ -0,0 +1,170 @@
+package generator
+
+import (
+	"github.com/pkg/errors"
+	"math"
+	"time"
+)
+
+// sunriseZenith is the zenith of the sun at sunrise and sunset in degrees, allowing for atmospheric refraction and
+// the radius of the sun
+const sunriseZenith = 90.833
+
+// defaultMaxTotalSolar is the TotalSolar in W/m² when the sun is directly overhead on a clear day
+const defaultMaxTotalSolar = 1000
+
+// Site is a location on earth whose photoperiod is followed instead of DayStart and DayEnd
+type Site struct {
+	// Latitude is in degrees north
+	Latitude float64 `json:"latitude" yaml:"latitude"`
+	// Longitude is in degrees east
+	Longitude float64 `json:"longitude" yaml:"longitude"`
+	// Timezone is the IANA name of the timezone of the site, eg. "Australia/Darwin". sunrise and sunset are
+	// written at the same time of day at the site as in the chamber. defaults to the chamber's timezone.
+	Timezone string `json:"timezone,omitempty" yaml:"timezone,omitempty"`
+	// FollowElevation scales the lights by the elevation of the sun, so that they are only at full output at solar
+	// noon, instead of being at full output from sunrise until sunset
+	FollowElevation bool `json:"follow_elevation,omitempty" yaml:"follow_elevation,omitempty"`
+	// MaxTotalSolar is the TotalSolar in W/m² when the sun is directly overhead, defaults to 1000
+	MaxTotalSolar float64 `json:"max_totalsolar,omitempty" yaml:"max_totalsolar,omitempty"`
+}
+
+// validate returns an error if the site isn't on earth or its timezone doesn't exist
+func (site Site) validate() error {
+	if site.Latitude < -90 || site.Latitude > 90 {
+		return errors.Errorf("latitude %v must be from -90 to 90", site.Latitude)
+	}
+	if site.Longitude < -180 || site.Longitude > 180 {
+		return errors.Errorf("longitude %v must be from -180 to 180", site.Longitude)
+	}
+	if site.MaxTotalSolar < 0 {
+		return errors.Errorf("max_totalsolar %v can't be negative", site.MaxTotalSolar)
+	}
+	_, err := site.location()
+	return err
+}
+
+// location returns the timezone of the site
+func (site Site) location() (*time.Location, error) {
+	if site.Timezone == "" {
+		return time.Local, nil
+	}
+	loc, err := time.LoadLocation(site.Timezone)
+	if err != nil {
+		return nil, errors.Wrapf(err, "timezone %q", site.Timezone)
+	}
+	return loc, nil
+}
+
+// atSite returns the instant at the site that has the same time of day as t in the chamber
+func (site Site) atSite(t time.Time) time.Time {
+	loc, err := site.location()
+	if err != nil {
+		loc = time.Local
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), loc)
+}
+
+func radians(deg float64) float64 {
+	return deg * math.Pi / 180
+}
+
+func degrees(rad float64) float64 {
+	return rad * 180 / math.Pi
+}
+
+// sunPosition returns the declination of the sun in degrees and the equation of time in minutes at t, using the NOAA
+// solar calculator equations.
+func sunPosition(t time.Time) (declination, equationOfTime float64) {
+	julianDay := float64(t.UnixNano())/float64(24*time.Hour) + 2440587.5
+	// julian centuries since J2000
+	jc := (julianDay - 2451545) / 36525
+
+	meanLongitude := math.Mod(280.46646+jc*(36000.76983+jc*0.0003032), 360)
+	meanAnomaly := 357.52911 + jc*(35999.05029-0.0001537*jc)
+	eccentricity := 0.016708634 - jc*(0.000042037+0.0000001267*jc)
+	center := math.Sin(radians(meanAnomaly))*(1.914602-jc*(0.004817+0.000014*jc)) +
+		math.Sin(radians(2*meanAnomaly))*(0.019993-0.000101*jc) +
+		math.Sin(radians(3*meanAnomaly))*0.000289
+	apparentLongitude := meanLongitude + center - 0.00569 - 0.00478*math.Sin(radians(125.04-1934.136*jc))
+	meanObliquity := 23 + (26+(21.448-jc*(46.815+jc*(0.00059-jc*0.001813)))/60)/60
+	obliquity := meanObliquity + 0.00256*math.Cos(radians(125.04-1934.136*jc))
+
+	declination = degrees(math.Asin(math.Sin(radians(obliquity)) * math.Sin(radians(apparentLongitude))))
+	y := math.Pow(math.Tan(radians(obliquity/2)), 2)
+	l, m := radians(meanLongitude), radians(meanAnomaly)
+	equationOfTime = 4 * degrees(y*math.Sin(2*l)-2*eccentricity*math.Sin(m)+
+		4*eccentricity*y*math.Sin(m)*math.Cos(2*l)-0.5*y*y*math.Sin(4*l)-
+		1.25*eccentricity*eccentricity*math.Sin(2*m))
+	return declination, equationOfTime
+}
+
+// Elevation returns the elevation of the sun above the horizon at the site at t in degrees, without refraction
+func (site Site) Elevation(t time.Time) float64 {
+	declination, equationOfTime := sunPosition(t)
+	utc := t.UTC()
+	minutes := float64(utc.Hour()*60+utc.Minute()) + float64(utc.Second())/60
+	trueSolarTime := math.Mod(minutes+equationOfTime+4*site.Longitude, 1440)
+	hourAngle := trueSolarTime/4 - 180
+
+	lat, dec := radians(site.Latitude), radians(declination)
+	cosZenith := math.Sin(lat)*math.Sin(dec) + math.Cos(lat)*math.Cos(dec)*math.Cos(radians(hourAngle))
+	return 90 - degrees(math.Acos(math.Max(-1, math.Min(1, cosZenith))))
+}
+
+// Sun returns the sunrise and sunset at the site on a date, and the elevation of the sun at solar noon.
+// if the sun doesn't rise that day sunrise and sunset are both solar noon, if it doesn't set they are solar noon
+// minus and plus 12 hours.
+func (site Site) Sun(date time.Time) (sunrise, sunset time.Time, noonElevation float64) {
+	midnight := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.UTC)
+	// solar noon is on the date at the site whichever side of greenwich it is
+	noon := midnight.Add(time.Duration((720 - 4*site.Longitude) * float64(time.Minute)))
+	_, equationOfTime := sunPosition(noon)
+	noon = midnight.Add(time.Duration((720 - 4*site.Longitude - equationOfTime) * float64(time.Minute)))
+	declination, _ := sunPosition(noon)
+
+	lat, dec := radians(site.Latitude), radians(declination)
+	cosHourAngle := math.Cos(radians(sunriseZenith))/(math.Cos(lat)*math.Cos(dec)) - math.Tan(lat)*math.Tan(dec)
+	// hour angle of sunrise in degrees, 0 if the sun doesn't rise and 180 if it doesn't set
+	hourAngle := degrees(math.Acos(math.Max(-1, math.Min(1, cosHourAngle))))
+	halfDay := time.Duration(hourAngle * 4 * float64(time.Minute))
+	return noon.Add(-halfDay), noon.Add(halfDay), 90 - math.Abs(site.Latitude-declination)
+}
+
+// daylight returns the hours of the day that the day starts and ends on a day in the chamber, and the elevation of the
+// sun at solar noon that day
+func (site Site) daylight(day time.Time) (start, end, noonElevation float64) {
+	loc, err := site.location()
+	if err != nil {
+		loc = time.Local
+	}
+	sunrise, sunset, noonElevation := site.Sun(day)
+	// the sun doesn't set, solar noon minus and plus 12 hours aren't midnight at the site
+	if sunset.Sub(sunrise) >= 24*time.Hour {
+		return 0, 24, noonElevation
+	}
+	// conditions files store whole seconds, the rows at sunrise and sunset must be on the right side of them
+	sunrise, sunset = sunrise.Round(time.Second), sunset.Round(time.Second)
+	midnight := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, loc)
+	start, end = 0, 24
+	if sunrise.After(midnight) {
+		start = hourOfDay(sunrise.In(loc))
+	}
+	if sunset.Before(midnight.AddDate(0, 0, 1)) {
+		end = hourOfDay(sunset.In(loc))
+	}
+	return start, end, noonElevation
+}
+
+// maxTotalSolar returns the TotalSolar when the sun is directly overhead
+func (site Site) maxTotalSolar() float64 {
+	if site.MaxTotalSolar == 0 {
+		return defaultMaxTotalSolar
+	}
+	return site.MaxTotalSolar
+}
+
+// totalSolar returns the clear sky TotalSolar at the site at the time of day of t in the chamber, 0 at night
+func (site Site) totalSolar(t time.Time) float64 {
+	return site.maxTotalSolar() * math.Max(0, math.Sin(radians(site.Elevation(site.atSite(t)))))
+}
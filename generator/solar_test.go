@@ -0,0 +1,151 @@
+package generator
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// sunTolerance is how far the calculated sunrise and sunset may be from the published times, which are rounded to the
+// minute
+const sunTolerance = 2 * time.Minute
+
+func TestSiteSun(t *testing.T) {
+	tests := []struct {
+		name            string
+		site            Site
+		date            time.Time
+		sunrise, sunset time.Time
+	}{
+		{
+			// 08:03 and 15:53 GMT
+			name:    "greenwich winter solstice",
+			site:    Site{Latitude: 51.4769, Longitude: 0},
+			date:    time.Date(2020, 12, 21, 0, 0, 0, 0, time.UTC),
+			sunrise: time.Date(2020, 12, 21, 8, 3, 0, 0, time.UTC),
+			sunset:  time.Date(2020, 12, 21, 15, 53, 0, 0, time.UTC),
+		},
+		{
+			// 04:43 and 21:21 BST
+			name:    "greenwich summer solstice",
+			site:    Site{Latitude: 51.4769, Longitude: 0},
+			date:    time.Date(2020, 6, 21, 0, 0, 0, 0, time.UTC),
+			sunrise: time.Date(2020, 6, 21, 3, 43, 0, 0, time.UTC),
+			sunset:  time.Date(2020, 6, 21, 20, 21, 0, 0, time.UTC),
+		},
+	}
+	for _, test := range tests {
+		sunrise, sunset, _ := test.site.Sun(test.date)
+		if d := sunrise.Sub(test.sunrise); d < -sunTolerance || d > sunTolerance {
+			t.Errorf("%s: sunrise at %v, want %v", test.name, sunrise, test.sunrise)
+		}
+		if d := sunset.Sub(test.sunset); d < -sunTolerance || d > sunTolerance {
+			t.Errorf("%s: sunset at %v, want %v", test.name, sunset, test.sunset)
+		}
+	}
+}
+
+func TestSitePolarDays(t *testing.T) {
+	tromso := Site{Latitude: 69.65, Longitude: 18.96}
+	sunrise, sunset, _ := tromso.Sun(time.Date(2020, 12, 21, 0, 0, 0, 0, time.UTC))
+	if !sunrise.Equal(sunset) {
+		t.Errorf("polar night from %v to %v, want no day", sunrise, sunset)
+	}
+	sunrise, sunset, _ = tromso.Sun(time.Date(2020, 6, 21, 0, 0, 0, 0, time.UTC))
+	if sunset.Sub(sunrise) != 24*time.Hour {
+		t.Errorf("midnight sun from %v to %v, want 24 hours of day", sunrise, sunset)
+	}
+}
+
+func TestSiteElevation(t *testing.T) {
+	equator := Site{Latitude: 0, Longitude: 0}
+	date := time.Date(2020, 3, 20, 0, 0, 0, 0, time.UTC)
+	sunrise, sunset, noonElevation := equator.Sun(date)
+	if math.Abs(noonElevation-90) > 0.5 {
+		t.Errorf("noon elevation at the equator on the equinox is %v, want 90", noonElevation)
+	}
+	noon := sunrise.Add(sunset.Sub(sunrise) / 2)
+	if e := equator.Elevation(noon); math.Abs(e-noonElevation) > 0.1 {
+		t.Errorf("elevation at solar noon is %v, want %v", e, noonElevation)
+	}
+	// the sun is below the horizon at sunrise without refraction
+	if e := equator.Elevation(sunrise); e > 0 || e < -1 {
+		t.Errorf("elevation at sunrise is %v, want just below 0", e)
+	}
+	if e := equator.Elevation(noon.Add(12 * time.Hour)); e > -80 {
+		t.Errorf("elevation at midnight is %v, want close to -90", e)
+	}
+}
+
+// TestSiteDaylight checks that the photoperiod is the time of day at the site, written at the same time of day in the
+// chamber
+func TestSiteDaylight(t *testing.T) {
+	canberra := Site{Latitude: -35.28, Longitude: 149.13, Timezone: "Australia/Sydney"}
+	day := time.Date(2020, 12, 21, 0, 0, 0, 0, time.UTC)
+	sunrise, sunset, _ := canberra.Sun(day)
+	loc, _ := canberra.location()
+	hours := func(t time.Time) float64 {
+		t = t.In(loc).Round(time.Second)
+		return float64(t.Hour()) + float64(t.Minute())/60 + float64(t.Second())/3600
+	}
+	start, end, _ := canberra.daylight(day)
+	if math.Abs(start-hours(sunrise)) > 1e-9 || math.Abs(end-hours(sunset)) > 1e-9 {
+		t.Errorf("daylight is from %v to %v hours, want sunrise and sunset in Sydney at %v and %v",
+			start, end, hours(sunrise), hours(sunset))
+	}
+
+	tromso := Site{Latitude: 69.65, Longitude: 18.96, Timezone: "Europe/Oslo"}
+	if start, end, _ := tromso.daylight(time.Date(2020, 6, 21, 0, 0, 0, 0, time.UTC)); start != 0 || end != 24 {
+		t.Errorf("midnight sun daylight is from %v to %v hours, want the whole day", start, end)
+	}
+
+	if err := (Site{Latitude: 0, Longitude: 0, Timezone: "Mars/Olympus_Mons"}).validate(); err == nil {
+		t.Error("validated an unknown timezone")
+	}
+	if err := (Site{Latitude: 91}).validate(); err == nil {
+		t.Error("validated a latitude off the earth")
+	}
+}
+
+func TestSiteTotalSolar(t *testing.T) {
+	canberra := Site{Latitude: -35.28, Longitude: 149.13, Timezone: "Australia/Sydney", MaxTotalSolar: 800}
+	midnight := time.Date(2020, 12, 21, 0, 0, 0, 0, time.UTC)
+	if v := canberra.totalSolar(midnight); v != 0 {
+		t.Errorf("totalsolar at midnight is %v, want 0", v)
+	}
+	_, _, noonElevation := canberra.Sun(midnight)
+	want := 800 * math.Sin(radians(noonElevation))
+	if v := canberra.totalSolar(midnight.Add(13 * time.Hour)); math.Abs(v-want) > 10 {
+		t.Errorf("totalsolar at 13:00 is %v, want about %v at solar noon", v, want)
+	}
+}
+
+// TestGenerateSite checks that the lights follow the sun at the site and are scaled by its elevation
+func TestGenerateSite(t *testing.T) {
+	spec := DefaultSpec()
+	spec.Start = "2020-12-21"
+	spec.LengthDays = 1
+	spec.Lights = "psi"
+	spec.Site = &Site{Latitude: -35.28, Longitude: 149.13, Timezone: "Australia/Sydney", FollowElevation: true}
+	s, err := Generate(discardLog, spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Indices.TotalSolarIdx < 0 {
+		t.Fatalf("no totalsolar column in %v", s.Indices.Headers())
+	}
+	start := s.Start()
+	dark, _ := s.At(start.Add(5*time.Hour + 30*time.Minute))
+	morning, _ := s.At(start.Add(8 * time.Hour))
+	noon, _ := s.At(start.Add(13 * time.Hour))
+	if dark.Channels[0].Float64 != 0 || dark.TotalSolar.Float64 != 0 {
+		t.Errorf("before sunrise is %s", dark.NulledString())
+	}
+	if morning.Channels[0].Float64 <= 0 || morning.Channels[0].Float64 >= noon.Channels[0].Float64 {
+		t.Errorf("lights are %v in the morning and %v at noon, want them to rise with the sun",
+			morning.Channels[0], noon.Channels[0])
+	}
+	if noon.Channels[0].Float64 < 99 || noon.TotalSolar.Float64 < 900 {
+		t.Errorf("solar noon is %s", noon.NulledString())
+	}
+}
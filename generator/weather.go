@@ -0,0 +1,277 @@
+package generator
+
+import (
+	"fmt"
+	"github.com/appf-anu/chamber-tools"
+	"github.com/pkg/errors"
+	"log"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WeatherColumns are the headers of the columns of a weather station export, matched without case.
+// CO2 is optional, the conditions file only has a co2 column if the export does.
+type WeatherColumns struct {
+	Timestamp   string
+	Temperature string
+	Humidity    string
+	// Radiation is global radiation in W/m², it is written to the totalsolar column
+	Radiation string
+	CO2       string
+}
+
+// DefaultWeatherColumns returns the headers of a weather export with plain column names
+func DefaultWeatherColumns() WeatherColumns {
+	return WeatherColumns{
+		Timestamp:   "timestamp",
+		Temperature: "temperature",
+		Humidity:    "humidity",
+		Radiation:   "radiation",
+		CO2:         "co2",
+	}
+}
+
+// timestampLayouts are tried in order to parse weather timestamps when WeatherOptions.TimestampLayout is empty
+var timestampLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04",
+	"02/01/2006 15:04:05",
+	"02/01/2006 15:04",
+}
+
+// Transform changes a weather value to Value*Scale + Offset, eg. for a +2°C warming scenario.
+// the zero Transform doesn't change values.
+type Transform struct {
+	// Scale is 1 if it is 0
+	Scale  float64
+	Offset float64
+}
+
+var transformExp = regexp.MustCompile(`^(?:\*([-+]?[\d.]+))?([-+][\d.]+)?$`)
+
+// ParseTransform parses a transform written as "*scale", "+offset" or "*scale+offset", eg. "+2" or "*0.9-1".
+// an empty string doesn't change values.
+func ParseTransform(s string) (Transform, error) {
+	var t Transform
+	match := transformExp.FindStringSubmatch(strings.Replace(s, " ", "", -1))
+	if match == nil {
+		return t, errors.Errorf("transform %q must be *scale, +offset or *scale+offset", s)
+	}
+	var err error
+	if match[1] != "" {
+		if t.Scale, err = strconv.ParseFloat(match[1], 64); err != nil {
+			return t, errors.Wrapf(err, "transform %q", s)
+		}
+		if t.Scale == 0 {
+			return t, errors.Errorf("transform %q can't scale by 0", s)
+		}
+	}
+	if match[2] != "" {
+		if t.Offset, err = strconv.ParseFloat(match[2], 64); err != nil {
+			return t, errors.Wrapf(err, "transform %q", s)
+		}
+	}
+	return t, nil
+}
+
+// scale returns the scale of the transform, 1 if Scale is 0
+func (t Transform) scale() float64 {
+	if t.Scale == 0 {
+		return 1
+	}
+	return t.Scale
+}
+
+func (t Transform) String() string {
+	return fmt.Sprintf("*%v%+v", t.scale(), t.Offset)
+}
+
+// apply transforms a value and clamps it to between min and max, unset values stay unset
+func (t Transform) apply(v chamber_tools.NullFloat64, min, max float64) chamber_tools.NullFloat64 {
+	if !v.Valid {
+		return v
+	}
+	return chamber_tools.NewNullFloat64(round(chamber_tools.ClampFloat64(v.Float64*t.scale()+t.Offset, min, max)))
+}
+
+// WeatherOptions control how a weather station export is converted to conditions
+type WeatherOptions struct {
+	// Columns are the headers of the export, the zero WeatherColumns is DefaultWeatherColumns
+	Columns WeatherColumns
+	// Delimiter is the field delimiter of the export, it is detected from the header line if it is 0
+	Delimiter rune
+	// TimestampLayout is the time.Parse layout of the timestamps, common layouts are tried if it is empty
+	TimestampLayout string
+	// Location is the timezone of the timestamps, defaults to the local timezone
+	Location *time.Location
+	// Interval resamples the weather to every Interval from midnight by linear interpolation, 0 keeps every record
+	Interval time.Duration
+	// Start is when the first timepoint is run, the zero time runs the weather at its recorded times
+	Start time.Time
+	// Temperature, Humidity, Radiation and CO2 transform the recorded values
+	Temperature Transform
+	Humidity    Transform
+	Radiation   Transform
+	CO2         Transform
+}
+
+// parseTimestamp parses a weather timestamp with the layout from the options or the first common layout that fits
+func (opts WeatherOptions) parseTimestamp(s string) (time.Time, error) {
+	loc := opts.Location
+	if loc == nil {
+		loc = time.Local
+	}
+	if opts.TimestampLayout != "" {
+		return time.ParseInLocation(opts.TimestampLayout, s, loc)
+	}
+	for _, layout := range timestampLayouts {
+		if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, errors.Errorf("unknown timestamp format %q", s)
+}
+
+// readWeather reads a weather export into timepoints at the recorded times, with the recorded time as SimDatetime.
+// rows that can't be parsed are logged to errLog and skipped.
+func readWeather(errLog *log.Logger, weatherPath string, opts WeatherOptions) ([]chamber_tools.TimePoint, bool, error) {
+	records, err := chamber_tools.ReadCSVFile(weatherPath, opts.Delimiter)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(records) == 0 {
+		return nil, false, errors.Errorf("no header line in weather file %s", weatherPath)
+	}
+	column := func(header string) int {
+		for i, h := range records[0] {
+			if header != "" && strings.EqualFold(h, header) {
+				return i
+			}
+		}
+		return -1
+	}
+	columns := opts.Columns
+	if columns == (WeatherColumns{}) {
+		columns = DefaultWeatherColumns()
+	}
+	timestampIdx := column(columns.Timestamp)
+	if timestampIdx < 0 {
+		return nil, false, errors.Errorf("no %q column in weather file %s", columns.Timestamp, weatherPath)
+	}
+	floats := []struct {
+		header string
+		idx    int
+		value  func(tp *chamber_tools.TimePoint) *chamber_tools.NullFloat64
+	}{
+		{columns.Temperature, column(columns.Temperature),
+			func(tp *chamber_tools.TimePoint) *chamber_tools.NullFloat64 { return &tp.Temperature }},
+		{columns.Humidity, column(columns.Humidity),
+			func(tp *chamber_tools.TimePoint) *chamber_tools.NullFloat64 { return &tp.RelativeHumidity }},
+		{columns.Radiation, column(columns.Radiation),
+			func(tp *chamber_tools.TimePoint) *chamber_tools.NullFloat64 { return &tp.TotalSolar }},
+		{columns.CO2, column(columns.CO2),
+			func(tp *chamber_tools.TimePoint) *chamber_tools.NullFloat64 { return &tp.CO2 }},
+	}
+	for _, f := range floats[:3] {
+		if f.idx < 0 {
+			return nil, false, errors.Errorf("no %q column in weather file %s", f.header, weatherPath)
+		}
+	}
+
+	var timepoints []chamber_tools.TimePoint
+rows:
+	for i, record := range records[1:] {
+		if strings.Join(record, "") == "" {
+			continue
+		}
+		if timestampIdx >= len(record) {
+			errLog.Printf("skipping row %d: no timestamp", i+2)
+			continue
+		}
+		t, err := opts.parseTimestamp(record[timestampIdx])
+		if err != nil {
+			errLog.Printf("skipping row %d: %v", i+2, err)
+			continue
+		}
+		tp := chamber_tools.TimePoint{Datetime: t, SimDatetime: t}
+		for _, f := range floats {
+			if f.idx < 0 || f.idx >= len(record) {
+				continue
+			}
+			if err := f.value(&tp).UnmarshalText([]byte(record[f.idx])); err != nil {
+				errLog.Printf("skipping row %d: %s: %v", i+2, f.header, err)
+				continue rows
+			}
+		}
+		timepoints = append(timepoints, tp)
+	}
+	return timepoints, floats[3].idx >= 0, nil
+}
+
+// ImportWeather converts a weather station export csv to a conditions schedule. the weather is resampled to
+// opts.Interval, transformed, and moved to start at opts.Start. SimDatetime is the recorded time of each timepoint.
+// transformed humidity is clamped to 0-100 %RH, radiation and CO2 can't be negative.
+func ImportWeather(errLog *log.Logger, weatherPath string, opts WeatherOptions) (*chamber_tools.Schedule, error) {
+	timepoints, hasCO2, err := readWeather(errLog, weatherPath, opts)
+	if err != nil {
+		return nil, err
+	}
+	headers := []string{"datetime", "datetime-sim", "temperature", "humidity", "totalsolar"}
+	if hasCO2 {
+		headers = append(headers, "co2")
+	}
+	// the recorded weather is interpolated linearly, every column is a measurement
+	recorded := chamber_tools.NewSchedule(errLog, headers, timepoints)
+	recorded.Interpolation = chamber_tools.InterpolationPolicy{
+		"temperature": chamber_tools.Linear,
+		"humidity":    chamber_tools.Linear,
+		"totalsolar":  chamber_tools.Linear,
+		"co2":         chamber_tools.Linear,
+	}
+	sort.SliceStable(recorded.TimePoints, func(i, j int) bool {
+		return recorded.TimePoints[i].Datetime.Before(recorded.TimePoints[j].Datetime)
+	})
+	if recorded.Len() == 0 {
+		return nil, errors.Errorf("no weather records in %s", weatherPath)
+	}
+
+	resampled := recorded.TimePoints
+	if opts.Interval > 0 {
+		resampled = nil
+		// timepoints are a multiple of Interval after midnight where the weather was recorded, Truncate would count
+		// from the zero time in UTC and put them off the hour in timezones that aren't whole hours from UTC
+		start := recorded.Start()
+		first := time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, start.Location())
+		if since := start.Sub(first); since > 0 {
+			first = first.Add((since + opts.Interval - 1) / opts.Interval * opts.Interval)
+		}
+		for t := first; !t.After(recorded.End()); t = t.Add(opts.Interval) {
+			tp, _ := recorded.At(t)
+			resampled = append(resampled, tp)
+		}
+	}
+
+	shift := time.Duration(0)
+	if !opts.Start.IsZero() && len(resampled) > 0 {
+		shift = opts.Start.Sub(resampled[0].Datetime)
+	}
+	out := make([]chamber_tools.TimePoint, len(resampled))
+	for i, tp := range resampled {
+		out[i] = chamber_tools.TimePoint{
+			Datetime:         tp.Datetime.Add(shift),
+			SimDatetime:      tp.SimDatetime,
+			Temperature:      opts.Temperature.apply(tp.Temperature, math.Inf(-1), math.Inf(1)),
+			RelativeHumidity: opts.Humidity.apply(tp.RelativeHumidity, 0, 100),
+			TotalSolar:       opts.Radiation.apply(tp.TotalSolar, 0, math.Inf(1)),
+			CO2:              opts.CO2.apply(tp.CO2, 0, math.Inf(1)),
+		}
+	}
+	return chamber_tools.NewSchedule(errLog, headers, out), nil
+}
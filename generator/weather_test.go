@@ -0,0 +1,149 @@
+package generator
+
+import (
+	"github.com/appf-anu/chamber-tools"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeWeather writes a weather export to a temporary file and returns its path
+func writeWeather(t *testing.T, contents string) string {
+	t.Helper()
+	weatherPath := filepath.Join(t.TempDir(), "weather.csv")
+	if err := os.WriteFile(weatherPath, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return weatherPath
+}
+
+func TestParseTransform(t *testing.T) {
+	tests := map[string]Transform{
+		"":         {},
+		"+2":       {Offset: 2},
+		"-1.5":     {Offset: -1.5},
+		"*0.9":     {Scale: 0.9},
+		"*1.1 - 1": {Scale: 1.1, Offset: -1},
+	}
+	for s, want := range tests {
+		got, err := ParseTransform(s)
+		if err != nil || got != want {
+			t.Errorf("ParseTransform(%q) = %v, %v, want %v", s, got, err, want)
+		}
+	}
+	for _, s := range []string{"2", "*0", "/2", "+two"} {
+		if _, err := ParseTransform(s); err == nil {
+			t.Errorf("parsed the transform %q", s)
+		}
+	}
+
+	humidity := Transform{Scale: 1.5}
+	if v := humidity.apply(chamber_tools.NewNullFloat64(80), 0, 100); v != chamber_tools.NewNullFloat64(100) {
+		t.Errorf("transformed humidity is %v, want it clamped to 100", v)
+	}
+	if v := humidity.apply(chamber_tools.NullFloat64{}, 0, 100); v.Valid {
+		t.Errorf("transformed an unset value to %v", v)
+	}
+}
+
+func TestImportWeather(t *testing.T) {
+	weatherPath := writeWeather(t, `Time;Air Temp;RH;Global Rad
+2020-01-01 00:10;20;50;0
+2020-01-01 00:50;24;30;100
+not a time;1;2;3
+2020-01-01 00:30;22;40;
+2020-01-01 01:10;26;20;300
+`)
+	opts := WeatherOptions{
+		Columns: WeatherColumns{
+			Timestamp:   "time",
+			Temperature: "air temp",
+			Humidity:    "rh",
+			Radiation:   "global rad",
+		},
+		Location:    time.UTC,
+		Temperature: Transform{Offset: 2},
+		Humidity:    Transform{Scale: 3},
+		Radiation:   Transform{Offset: -50},
+	}
+	s, err := ImportWeather(discardLog, weatherPath, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Indices.CO2Idx >= 0 {
+		t.Errorf("wrote a co2 column for an export without one: %v", s.Indices.Headers())
+	}
+	want := []chamber_tools.TimePoint{
+		{Temperature: chamber_tools.NewNullFloat64(22), RelativeHumidity: chamber_tools.NewNullFloat64(100),
+			TotalSolar: chamber_tools.NewNullFloat64(0)},
+		{Temperature: chamber_tools.NewNullFloat64(24), RelativeHumidity: chamber_tools.NewNullFloat64(100)},
+		{Temperature: chamber_tools.NewNullFloat64(26), RelativeHumidity: chamber_tools.NewNullFloat64(90),
+			TotalSolar: chamber_tools.NewNullFloat64(50)},
+		{Temperature: chamber_tools.NewNullFloat64(28), RelativeHumidity: chamber_tools.NewNullFloat64(60),
+			TotalSolar: chamber_tools.NewNullFloat64(250)},
+	}
+	if s.Len() != len(want) {
+		t.Fatalf("imported %d timepoints, want the %d valid records sorted", s.Len(), len(want))
+	}
+	for i, w := range want {
+		got := s.TimePoints[i]
+		recorded := time.Date(2020, 1, 1, 0, 10+20*i, 0, 0, time.UTC)
+		if !got.Datetime.Equal(recorded) || !got.SimDatetime.Equal(recorded) {
+			t.Errorf("TimePoint %d is at %v and %v, want the recorded time %v",
+				i, got.Datetime, got.SimDatetime, recorded)
+		}
+		if got.Temperature != w.Temperature || got.RelativeHumidity != w.RelativeHumidity ||
+			got.TotalSolar != w.TotalSolar {
+			t.Errorf("TimePoint %d is %s, want %s", i, got.NulledString(), w.NulledString())
+		}
+	}
+
+	opts.Columns.Humidity = "humidity"
+	if _, err := ImportWeather(discardLog, weatherPath, opts); err == nil {
+		t.Error("imported an export without a humidity column")
+	}
+}
+
+// TestImportWeatherResample checks that resampled timepoints are a multiple of the interval after local midnight, in
+// a timezone that is half an hour off UTC, and are moved to Start
+func TestImportWeatherResample(t *testing.T) {
+	adelaide, err := time.LoadLocation("Australia/Adelaide")
+	if err != nil {
+		t.Skip(err)
+	}
+	weatherPath := writeWeather(t, `timestamp,temperature,humidity,radiation,co2
+2020-01-01 00:10,20,50,0,400
+2020-01-01 02:10,24,70,200,420
+`)
+	opts := WeatherOptions{Location: adelaide, Interval: time.Hour}
+	s, err := ImportWeather(discardLog, weatherPath, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Len() != 2 {
+		t.Fatalf("resampled to %d timepoints, want 01:00 and 02:00", s.Len())
+	}
+	for i, tp := range s.TimePoints {
+		want := time.Date(2020, 1, 1, i+1, 0, 0, 0, adelaide)
+		if !tp.Datetime.Equal(want) {
+			t.Errorf("TimePoint %d is at %v, want %v", i, tp.Datetime.In(adelaide), want)
+		}
+	}
+	if tp := s.TimePoints[0]; tp.Temperature != chamber_tools.NewNullFloat64(21.67) ||
+		tp.CO2 != chamber_tools.NewNullFloat64(408.33) {
+		t.Errorf("01:00 is %s, want it interpolated between the records", tp.NulledString())
+	}
+
+	opts.Start = time.Date(2021, 6, 1, 9, 0, 0, 0, adelaide)
+	s, err = ImportWeather(discardLog, weatherPath, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.Start().Equal(opts.Start) || !s.TimePoints[1].Datetime.Equal(opts.Start.Add(time.Hour)) {
+		t.Errorf("moved to start at %v, want %v", s.Start(), opts.Start)
+	}
+	if want := time.Date(2020, 1, 1, 1, 0, 0, 0, adelaide); !s.TimePoints[0].SimDatetime.Equal(want) {
+		t.Errorf("SimDatetime is %v, want the recorded time %v", s.TimePoints[0].SimDatetime, want)
+	}
+}
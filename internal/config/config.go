@@ -0,0 +1,47 @@
+// Package config reads the .json and .yaml files that profiles, specs and manifests are written in
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Decode reads a .json or .yaml file into v, files of either type fail to decode if they have keys that v doesn't.
+// kind is what the file holds for error messages, eg. "limit profiles".
+func Decode(configPath, kind string, v interface{}) error {
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return err
+	}
+	switch strings.ToLower(filepath.Ext(configPath)) {
+	case ".json":
+		err = decodeJSON(data, v)
+	case ".yaml", ".yml":
+		err = yaml.UnmarshalStrict(data, v)
+	default:
+		err = errors.Errorf("unsupported %s file type %q", kind, filepath.Ext(configPath))
+	}
+	if err != nil {
+		return errors.Wrapf(err, "reading %s from %s", kind, configPath)
+	}
+	return nil
+}
+
+// decodeJSON decodes data into v like json.Unmarshal, except that keys v doesn't have are errors like in
+// yaml.UnmarshalStrict
+func decodeJSON(data []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	if dec.More() {
+		return errors.New("invalid data after the top-level value")
+	}
+	return nil
+}
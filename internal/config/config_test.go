@@ -0,0 +1,52 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type chamber struct {
+	Name  string  `json:"name" yaml:"name"`
+	Hours float64 `json:"hours" yaml:"hours"`
+}
+
+func TestDecode(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, contents string) string {
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+
+	tests := []struct {
+		path    string
+		wantErr string
+	}{
+		{write("a.json", `{"a": {"name": "ch1", "hours": 12}}`), ""},
+		{write("a.yaml", "a:\n  name: ch1\n  hours: 12\n"), ""},
+		{write("a.YML", "a:\n  name: ch1\n  hours: 12\n"), ""},
+		{write("unknown.yaml", "a:\n  name: ch1\n  colour: red\n"), "reading chambers from"},
+		{write("unknown.json", `{"a": {"name": "ch1", "colour": "red"}}`), `unknown field "colour"`},
+		{write("trailing.json", `{"a": {"name": "ch1"}} {}`), "after the top-level value"},
+		{write("a.toml", ""), `unsupported chambers file type ".toml"`},
+		{filepath.Join(dir, "missing.json"), "no such file"},
+	}
+	for _, test := range tests {
+		chambers := make(map[string]chamber)
+		err := Decode(test.path, "chambers", &chambers)
+		if test.wantErr == "" {
+			if err != nil || chambers["a"] != (chamber{Name: "ch1", Hours: 12}) {
+				t.Errorf("decoded %v, %v from %s", chambers, err, filepath.Base(test.path))
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+			t.Errorf("decoding %s returned %v, want an error containing %q", filepath.Base(test.path), err,
+				test.wantErr)
+		}
+	}
+}
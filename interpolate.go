@@ -0,0 +1,164 @@
+package chamber_tools
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"math"
+	"strings"
+	"time"
+)
+
+// Interpolation is how the value of a column changes between two timepoints
+type Interpolation int
+
+const (
+	// Step holds the value of a timepoint until the next timepoint
+	Step Interpolation = iota
+	// Linear changes the value linearly from one timepoint to the next
+	Linear
+)
+
+func (i Interpolation) String() string {
+	switch i {
+	case Step:
+		return "step"
+	case Linear:
+		return "linear"
+	}
+	return fmt.Sprintf("Interpolation(%d)", int(i))
+}
+
+// ParseInterpolation parses "step" or "linear"
+func ParseInterpolation(s string) (Interpolation, error) {
+	switch strings.ToLower(trimField(s)) {
+	case "step":
+		return Step, nil
+	case "linear":
+		return Linear, nil
+	}
+	return Step, errors.Errorf("unknown interpolation %q", s)
+}
+
+// channelsKey is the InterpolationPolicy key that applies to every channel without its own entry
+const channelsKey = "channels"
+
+// InterpolationPolicy is the Interpolation of each column, keyed by header name.
+// channels use their own header ("channel-3") if it is present, then "spectrum" as they may be solved from a spectrum
+// column, otherwise "channels".
+// columns that aren't in the policy use Step.
+type InterpolationPolicy map[string]Interpolation
+
+// DefaultInterpolationPolicy steps lights that are switched between levels and ramps everything else
+func DefaultInterpolationPolicy() InterpolationPolicy {
+	return InterpolationPolicy{
+		"temperature": Linear,
+		"humidity":    Linear,
+		"co2":         Linear,
+		"totalsolar":  Linear,
+		"light1":      Step,
+		"light2":      Step,
+		channelsKey:   Linear,
+	}
+}
+
+// Merge returns a copy of the policy with every entry of other added, replacing existing entries
+func (p InterpolationPolicy) Merge(other InterpolationPolicy) InterpolationPolicy {
+	merged := make(InterpolationPolicy, len(p)+len(other))
+	for k, v := range p {
+		merged[k] = v
+	}
+	for k, v := range other {
+		merged[k] = v
+	}
+	return merged
+}
+
+// For returns the Interpolation of a column
+func (p InterpolationPolicy) For(header string) Interpolation {
+	if i, ok := p[header]; ok {
+		return i
+	}
+	if strings.HasPrefix(header, "channel-") {
+		if i, ok := p["spectrum"]; ok {
+			return i
+		}
+		return p[channelsKey]
+	}
+	return Step
+}
+
+// splitHeaderAnnotation splits the interpolation annotation from a header, "temperature:step" declares that
+// temperature is stepped.
+func splitHeaderAnnotation(header string) (string, string) {
+	if i := strings.LastIndex(header, ":"); i >= 0 {
+		return trimField(header[:i]), trimField(header[i+1:])
+	}
+	return header, ""
+}
+
+// lerp linearly interpolates between a and b
+func lerp(a, b, frac float64) float64 {
+	return a + (b-a)*frac
+}
+
+func interpolateFloat(a, b NullFloat64, frac float64, i Interpolation) NullFloat64 {
+	if i != Linear || !a.Valid || !b.Valid {
+		return a
+	}
+	return NewNullFloat64(lerp(a.Float64, b.Float64, frac))
+}
+
+func interpolateInt(a, b NullInt, frac float64, i Interpolation) NullInt {
+	if i != Linear || !a.Valid || !b.Valid {
+		return a
+	}
+	return NewNullInt(int(math.Round(lerp(float64(a.Int), float64(b.Int), frac))))
+}
+
+// interpolate returns the timepoint between a and b at t, using policy for each column.
+// unset values are never interpolated, they are stepped.
+func interpolate(a, b TimePoint, t time.Time, policy InterpolationPolicy) TimePoint {
+	span := b.Datetime.Sub(a.Datetime)
+	if span <= 0 {
+		return a
+	}
+	frac := float64(t.Sub(a.Datetime)) / float64(span)
+
+	tp := a
+	tp.Datetime = t
+	if !a.SimDatetime.IsZero() && !b.SimDatetime.IsZero() {
+		tp.SimDatetime = a.SimDatetime.Add(time.Duration(float64(b.SimDatetime.Sub(a.SimDatetime)) * frac))
+	}
+	tp.Temperature = interpolateFloat(a.Temperature, b.Temperature, frac, policy.For("temperature"))
+	tp.RelativeHumidity = interpolateFloat(a.RelativeHumidity, b.RelativeHumidity, frac, policy.For("humidity"))
+	tp.CO2 = interpolateFloat(a.CO2, b.CO2, frac, policy.For("co2"))
+	tp.TotalSolar = interpolateFloat(a.TotalSolar, b.TotalSolar, frac, policy.For("totalsolar"))
+	tp.Light1 = interpolateInt(a.Light1, b.Light1, frac, policy.For("light1"))
+	tp.Light2 = interpolateInt(a.Light2, b.Light2, frac, policy.For("light2"))
+
+	tp.Channels = make([]NullFloat64, len(a.Channels))
+	for i := range a.Channels {
+		if i >= len(b.Channels) {
+			tp.Channels[i] = a.Channels[i]
+			continue
+		}
+		header := fmt.Sprintf("channel-%d", i+1)
+		tp.Channels[i] = interpolateFloat(a.Channels[i], b.Channels[i], frac, policy.For(header))
+	}
+	return tp
+}
+
+// At returns the timepoint the schedule specifies at t, interpolating between rows using the schedule's
+// Interpolation policy. returns false if t is before the first timepoint.
+func (s *Schedule) At(t time.Time) (TimePoint, bool) {
+	i := s.Index(t)
+	if i < 0 {
+		return TimePoint{}, false
+	}
+	if i == len(s.TimePoints)-1 {
+		tp := s.TimePoints[i]
+		tp.Datetime = t
+		return tp, true
+	}
+	return interpolate(s.TimePoints[i], s.TimePoints[i+1], t, s.Interpolation), true
+}
@@ -0,0 +1,119 @@
+package chamber_tools
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseInterpolation(t *testing.T) {
+	for s, want := range map[string]Interpolation{"step": Step, " Linear ": Linear} {
+		got, err := ParseInterpolation(s)
+		if err != nil || got != want {
+			t.Errorf("ParseInterpolation(%q) = %v, %v, want %v", s, got, err, want)
+		}
+	}
+	if _, err := ParseInterpolation("cubic"); err == nil {
+		t.Error("parsed an unknown interpolation")
+	}
+}
+
+func TestInterpolationPolicyFor(t *testing.T) {
+	policy := DefaultInterpolationPolicy().Merge(InterpolationPolicy{"channel-2": Step, "temperature": Step})
+	tests := map[string]Interpolation{
+		"temperature": Step,
+		"humidity":    Linear,
+		"light1":      Step,
+		"channel-1":   Linear,
+		"channel-2":   Step,
+		"unknown":     Step,
+	}
+	for header, want := range tests {
+		if got := policy.For(header); got != want {
+			t.Errorf("For(%q) = %v, want %v", header, got, want)
+		}
+	}
+	spectrum := policy.Merge(InterpolationPolicy{"spectrum": Step})
+	if got := spectrum.For("channel-1"); got != Step {
+		t.Errorf("channels solved from a spectrum column are %v, want them to follow the spectrum", got)
+	}
+}
+
+func TestScheduleAt(t *testing.T) {
+	s := &Schedule{
+		Interpolation: DefaultInterpolationPolicy(),
+		TimePoints: []TimePoint{
+			{
+				Datetime:    at(0),
+				Temperature: NewNullFloat64(20),
+				Light1:      NewNullInt(0),
+				CO2:         NewNullFloat64(400),
+				Channels:    []NullFloat64{NewNullFloat64(0), {}},
+			},
+			{
+				Datetime:    at(4),
+				Temperature: NewNullFloat64(28),
+				Light1:      NewNullInt(2),
+				Channels:    []NullFloat64{NewNullFloat64(100), NewNullFloat64(50)},
+			},
+		},
+	}
+
+	if _, ok := s.At(at(-1)); ok {
+		t.Error("At returned a timepoint before the schedule starts")
+	}
+
+	tp, ok := s.At(at(1))
+	if !ok {
+		t.Fatal("no timepoint during the schedule")
+	}
+	if !tp.Datetime.Equal(at(1)) {
+		t.Errorf("Datetime is %v, want %v", tp.Datetime, at(1))
+	}
+	if tp.Temperature != NewNullFloat64(22) {
+		t.Errorf("Temperature is %v, want it linear at 22", tp.Temperature)
+	}
+	if tp.Light1 != NewNullInt(0) {
+		t.Errorf("Light1 is %v, want it stepped at 0", tp.Light1)
+	}
+	if tp.CO2 != NewNullFloat64(400) {
+		t.Errorf("CO2 is %v, want it held as the next timepoint doesn't set it", tp.CO2)
+	}
+	if tp.Channels[0] != NewNullFloat64(25) || tp.Channels[1].Valid {
+		t.Errorf("Channels are %v, want [25 NULL]", tp.Channels)
+	}
+
+	stepped := *s
+	stepped.Interpolation = DefaultInterpolationPolicy().Merge(InterpolationPolicy{"temperature": Step})
+	if tp, _ := stepped.At(at(3)); tp.Temperature != NewNullFloat64(20) {
+		t.Errorf("stepped Temperature is %v, want 20", tp.Temperature)
+	}
+
+	last, _ := s.At(at(4).Add(time.Hour))
+	if last.Temperature != NewNullFloat64(28) || !last.Datetime.Equal(at(5)) {
+		t.Errorf("after the last timepoint At returned %s, want the last timepoint held", last.NulledString())
+	}
+}
+
+func TestLoadScheduleInterpolationHeaders(t *testing.T) {
+	p := writeFile(t, "annotated.csv", `datetime,temperature:step,humidity
+2020-01-01 00:00,20,50
+2020-01-01 02:00,30,70
+`)
+	s, err := LoadSchedule(discardLog, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tp, _ := s.At(s.Start().Add(time.Hour))
+	if tp.Temperature != NewNullFloat64(20) || tp.RelativeHumidity != NewNullFloat64(60) {
+		t.Errorf("annotated temperature and default humidity interpolated to %v and %v, want 20 and 60",
+			tp.Temperature, tp.RelativeHumidity)
+	}
+
+	s, err = LoadScheduleWithOptions(discardLog, p, LoadOptions{Interpolation: InterpolationPolicy{"temperature": Linear}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tp, _ := s.At(s.Start().Add(time.Hour)); tp.Temperature != NewNullFloat64(25) {
+		t.Errorf("Temperature is %v, want the option to override the header", tp.Temperature)
+	}
+}
@@ -0,0 +1,256 @@
+package chamber_tools
+
+import (
+	"fmt"
+	"github.com/appf-anu/chamber-tools/internal/config"
+	"math"
+	"strings"
+	"time"
+)
+
+// Range is an inclusive range of allowed values, a nil bound is not checked
+type Range struct {
+	Min *float64 `json:"min,omitempty" yaml:"min,omitempty"`
+	Max *float64 `json:"max,omitempty" yaml:"max,omitempty"`
+}
+
+// between returns a Range from min to max
+func between(min, max float64) Range {
+	return Range{Min: &min, Max: &max}
+}
+
+// atLeast returns a Range with no upper bound
+func atLeast(min float64) Range {
+	return Range{Min: &min}
+}
+
+// check returns a description of how v is outside the range, or "" if it is inside it
+func (r Range) check(v float64) string {
+	if r.Min != nil && v < *r.Min {
+		return fmt.Sprintf("%v is below the minimum %v", v, *r.Min)
+	}
+	if r.Max != nil && v > *r.Max {
+		return fmt.Sprintf("%v is above the maximum %v", v, *r.Max)
+	}
+	return ""
+}
+
+// clamp clamps v to the range
+func (r Range) clamp(v float64) float64 {
+	min, max := math.Inf(-1), math.Inf(1)
+	if r.Min != nil {
+		min = *r.Min
+	}
+	if r.Max != nil {
+		max = *r.Max
+	}
+	return ClampFloat64(v, min, max)
+}
+
+// LimitProfile is the physical limits of a chamber or light fixture model
+type LimitProfile struct {
+	Name string `json:"name" yaml:"name"`
+	// Temperature in °C
+	Temperature Range `json:"temperature" yaml:"temperature"`
+	// RelativeHumidity in %RH
+	RelativeHumidity Range `json:"humidity" yaml:"humidity"`
+	// CO2 in ppm
+	CO2        Range `json:"co2" yaml:"co2"`
+	TotalSolar Range `json:"totalsolar" yaml:"totalsolar"`
+	Light1     Range `json:"light1" yaml:"light1"`
+	Light2     Range `json:"light2" yaml:"light2"`
+	// Channels applies to every channel
+	Channels Range `json:"channels" yaml:"channels"`
+	// ChannelCount is the number of channels the fixture has, 0 if it isn't checked
+	ChannelCount int `json:"channel_count" yaml:"channel_count"`
+	// MaxTemperatureRate is the fastest the temperature can change in °C per hour, 0 if it isn't checked
+	MaxTemperatureRate float64 `json:"max_temperature_rate" yaml:"max_temperature_rate"`
+	// MaxHumidityRate is the fastest the humidity can change in %RH per hour, 0 if it isn't checked
+	MaxHumidityRate float64 `json:"max_humidity_rate" yaml:"max_humidity_rate"`
+	// MaxCO2Rate is the fastest the CO2 can change in ppm per hour, 0 if it isn't checked
+	MaxCO2Rate float64 `json:"max_co2_rate" yaml:"max_co2_rate"`
+}
+
+// DefaultLimitProfiles are the limits of the chambers and light fixtures that create_xlsx.py writes conditions for,
+// keyed by the names of its lights_headers. only the limits it implies are checked:
+//   - ChannelCount is the number of channel headers lights_headers lists for each fixture.
+//   - Light1, Light2 and Channels are 0 to 100, as it writes every light header, conviron's light1 and light2 included,
+//     as 0 at night and 100 times its channels_scaling factor of at most 1 during the day.
+//   - RelativeHumidity is 0 to 100 %RH for the chambers, as that is every relative humidity.
+//
+// it has no temperature, CO2 or rate limits, use LoadLimitProfiles for the limits of a particular chamber.
+func DefaultLimitProfiles() map[string]LimitProfile {
+	return map[string]LimitProfile{
+		"conviron": {
+			Name:             "conviron",
+			RelativeHumidity: between(0, 100),
+			Light1:           between(0, 100),
+			Light2:           between(0, 100),
+		},
+		"psi": {
+			Name:             "psi",
+			RelativeHumidity: between(0, 100),
+			Channels:         between(0, 100),
+			ChannelCount:     8,
+		},
+		"heliospectra_s7": {
+			Name:         "heliospectra_s7",
+			Channels:     between(0, 100),
+			ChannelCount: 7,
+		},
+		"heliospectra_s10": {
+			Name:         "heliospectra_s10",
+			Channels:     between(0, 100),
+			ChannelCount: 10,
+		},
+	}
+}
+
+// LoadLimitProfiles reads limit profiles keyed by name from a .json or .yaml file.
+// profiles without a name are named after their key.
+func LoadLimitProfiles(configPath string) (map[string]LimitProfile, error) {
+	profiles := make(map[string]LimitProfile)
+	if err := config.Decode(configPath, "limit profiles", &profiles); err != nil {
+		return nil, err
+	}
+	for name, p := range profiles {
+		if p.Name == "" {
+			p.Name = name
+			profiles[name] = p
+		}
+	}
+	return profiles, nil
+}
+
+// LimitViolation is a timepoint that is outside of the limits of a LimitProfile
+type LimitViolation struct {
+	// Index of the timepoint in the schedule
+	Index    int
+	Datetime time.Time
+	// Header of the column that is outside the limits
+	Header  string
+	Message string
+}
+
+func (v LimitViolation) String() string {
+	return fmt.Sprintf("TimePoint %05d at %v: %s %s", v.Index, v.Datetime, v.Header, v.Message)
+}
+
+// LimitViolations is every violation of a LimitProfile in a schedule
+type LimitViolations []LimitViolation
+
+func (vs LimitViolations) Error() string {
+	lines := make([]string, len(vs))
+	for i, v := range vs {
+		lines[i] = v.String()
+	}
+	return fmt.Sprintf("%d limit violations:\n\t%s", len(vs), strings.Join(lines, "\n\t"))
+}
+
+// Check returns every timepoint value that is outside the ranges of the profile, and every change between
+// consecutive timepoints that is faster than the profile's rates.
+func (p LimitProfile) Check(timepoints []TimePoint) LimitViolations {
+	var violations LimitViolations
+	add := func(i int, header, format string, args ...interface{}) {
+		violations = append(violations, LimitViolation{
+			Index:    i,
+			Datetime: timepoints[i].Datetime,
+			Header:   header,
+			Message:  fmt.Sprintf(format, args...),
+		})
+	}
+	checkRange := func(i int, header string, r Range, v NullFloat64) {
+		if !v.Valid {
+			return
+		}
+		if msg := r.check(v.Float64); msg != "" {
+			add(i, header, "%s", msg)
+		}
+	}
+	checkRate := func(i int, hours float64, header string, maxRate float64, a, b NullFloat64, unit string) {
+		if maxRate <= 0 || !a.Valid || !b.Valid || a.Float64 == b.Float64 {
+			return
+		}
+		if rate := math.Abs(b.Float64-a.Float64) / hours; rate > maxRate {
+			add(i, header, "changes from %v to %v at %.4g%s per hour, faster than the maximum %v%s per hour",
+				a.Float64, b.Float64, rate, unit, maxRate, unit)
+		}
+	}
+	checksRates := p.MaxTemperatureRate > 0 || p.MaxHumidityRate > 0 || p.MaxCO2Rate > 0
+	toFloat := func(v NullInt) NullFloat64 {
+		if !v.Valid {
+			return NullFloat64{}
+		}
+		return NewNullFloat64(float64(v.Int))
+	}
+
+	for i, tp := range timepoints {
+		checkRange(i, "temperature", p.Temperature, tp.Temperature)
+		checkRange(i, "humidity", p.RelativeHumidity, tp.RelativeHumidity)
+		checkRange(i, "co2", p.CO2, tp.CO2)
+		checkRange(i, "totalsolar", p.TotalSolar, tp.TotalSolar)
+		checkRange(i, "light1", p.Light1, toFloat(tp.Light1))
+		checkRange(i, "light2", p.Light2, toFloat(tp.Light2))
+		for c, v := range tp.Channels {
+			checkRange(i, fmt.Sprintf("channel-%d", c+1), p.Channels, v)
+		}
+		if i == 0 {
+			// every timepoint has the same channels, as they come from the same header line
+			if p.ChannelCount > 0 && len(tp.Channels) > p.ChannelCount {
+				add(i, "channels", "%d channels, %s has %d", len(tp.Channels), p.Name, p.ChannelCount)
+			}
+			continue
+		}
+		prev := timepoints[i-1]
+		hours := tp.Datetime.Sub(prev.Datetime).Hours()
+		if hours <= 0 {
+			// there is no rate of change between timepoints at the same time
+			if checksRates {
+				add(i, "datetime", "is not after the previous TimePoint at %v, they are out of order or duplicated",
+					prev.Datetime)
+			}
+			continue
+		}
+		checkRate(i, hours, "temperature", p.MaxTemperatureRate, prev.Temperature, tp.Temperature, "°C")
+		checkRate(i, hours, "humidity", p.MaxHumidityRate, prev.RelativeHumidity, tp.RelativeHumidity, "%RH")
+		checkRate(i, hours, "co2", p.MaxCO2Rate, prev.CO2, tp.CO2, "ppm")
+	}
+	return violations
+}
+
+// Clamp clamps every value of a timepoint to the ranges of the profile, rates are not clamped.
+// returns true if any value was changed.
+func (p LimitProfile) Clamp(tp TimePoint) (TimePoint, bool) {
+	changed := false
+	clampFloat := func(r Range, v NullFloat64) NullFloat64 {
+		if !v.Valid {
+			return v
+		}
+		c := r.clamp(v.Float64)
+		changed = changed || c != v.Float64
+		return NewNullFloat64(c)
+	}
+	clampInt := func(r Range, v NullInt) NullInt {
+		if !v.Valid {
+			return v
+		}
+		c := int(r.clamp(float64(v.Int)))
+		changed = changed || c != v.Int
+		return NewNullInt(c)
+	}
+
+	tp.Temperature = clampFloat(p.Temperature, tp.Temperature)
+	tp.RelativeHumidity = clampFloat(p.RelativeHumidity, tp.RelativeHumidity)
+	tp.CO2 = clampFloat(p.CO2, tp.CO2)
+	tp.TotalSolar = clampFloat(p.TotalSolar, tp.TotalSolar)
+	tp.Light1 = clampInt(p.Light1, tp.Light1)
+	tp.Light2 = clampInt(p.Light2, tp.Light2)
+	if tp.Channels != nil {
+		channels := make([]NullFloat64, len(tp.Channels))
+		for i, v := range tp.Channels {
+			channels[i] = clampFloat(p.Channels, v)
+		}
+		tp.Channels = channels
+	}
+	return tp, changed
+}
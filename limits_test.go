@@ -0,0 +1,157 @@
+package chamber_tools
+
+import (
+	"github.com/pkg/errors"
+	"strings"
+	"testing"
+)
+
+func TestLimitProfileCheck(t *testing.T) {
+	profile := LimitProfile{
+		Name:               "test",
+		Temperature:        between(10, 40),
+		RelativeHumidity:   between(0, 100),
+		Light1:             between(0, 5),
+		Channels:           between(0, 100),
+		ChannelCount:       2,
+		MaxTemperatureRate: 5,
+	}
+	timepoints := []TimePoint{
+		{Datetime: at(0), Temperature: NewNullFloat64(20), Channels: []NullFloat64{{}, {}, {}}},
+		{Datetime: at(1), Temperature: NewNullFloat64(30), RelativeHumidity: NewNullFloat64(150)},
+		{Datetime: at(2), Temperature: NewNullFloat64(32), Light1: NewNullInt(6)},
+		{Datetime: at(2), Temperature: NewNullFloat64(45)},
+		{Datetime: at(3), Temperature: NewNullFloat64(45), Channels: []NullFloat64{NewNullFloat64(-1), {}}},
+	}
+	want := []struct {
+		index   int
+		header  string
+		message string
+	}{
+		{0, "channels", "3 channels, test has 2"},
+		{1, "humidity", "above the maximum 100"},
+		{1, "temperature", "at 10°C per hour, faster than the maximum 5°C per hour"},
+		{2, "light1", "above the maximum 5"},
+		{3, "temperature", "above the maximum 40"},
+		{3, "datetime", "out of order or duplicated"},
+		{4, "temperature", "above the maximum 40"},
+		{4, "channel-1", "below the minimum 0"},
+	}
+
+	violations := profile.Check(timepoints)
+	if len(violations) != len(want) {
+		t.Fatalf("found %d violations, want %d: %v", len(violations), len(want), violations)
+	}
+	for i, w := range want {
+		v := violations[i]
+		if v.Index != w.index || v.Header != w.header || !strings.Contains(v.Message, w.message) {
+			t.Errorf("violation %d is %q, want TimePoint %d %s %q", i, v, w.index, w.header, w.message)
+		}
+		if strings.Contains(v.Message, "Inf") {
+			t.Errorf("violation %d has an infinite rate: %s", i, v)
+		}
+	}
+
+	profile.MaxTemperatureRate = 0
+	for _, v := range profile.Check(timepoints) {
+		if v.Header == "datetime" {
+			t.Errorf("reported %q without any rates to check", v)
+		}
+	}
+}
+
+func TestLimitProfileClamp(t *testing.T) {
+	profile := DefaultLimitProfiles()["conviron"]
+	tp := TimePoint{
+		Temperature:      NewNullFloat64(80),
+		RelativeHumidity: NewNullFloat64(150),
+		Light1:           NewNullInt(120),
+		Light2:           NewNullInt(100),
+	}
+	clamped, changed := profile.Clamp(tp)
+	if !changed {
+		t.Error("Clamp didn't change anything")
+	}
+	if clamped.RelativeHumidity != NewNullFloat64(100) || clamped.Light1 != NewNullInt(100) {
+		t.Errorf("clamped to %s", clamped.NulledString())
+	}
+	if clamped.Temperature != NewNullFloat64(80) || clamped.Light2 != NewNullInt(100) || clamped.CO2.Valid {
+		t.Errorf("clamped values that are inside the limits or unset: %s", clamped.NulledString())
+	}
+	if _, changed := profile.Clamp(clamped); changed {
+		t.Error("clamping a clamped timepoint changed it")
+	}
+}
+
+// TestDefaultLimitProfiles checks that the defaults accept the values create_xlsx.py writes
+func TestDefaultLimitProfiles(t *testing.T) {
+	full := func(n int) []NullFloat64 {
+		channels := make([]NullFloat64, n)
+		for i := range channels {
+			channels[i] = NewNullFloat64(100)
+		}
+		return channels
+	}
+	day := TimePoint{Datetime: at(7), Temperature: NewNullFloat64(28), RelativeHumidity: NewNullFloat64(55)}
+	written := map[string]TimePoint{
+		"conviron":         {Light1: NewNullInt(100), Light2: NewNullInt(100)},
+		"psi":              {Channels: full(8)},
+		"heliospectra_s7":  {Channels: full(7)},
+		"heliospectra_s10": {Channels: full(10)},
+	}
+	profiles := DefaultLimitProfiles()
+	if len(profiles) != len(written) {
+		t.Errorf("%d default profiles, want %d", len(profiles), len(written))
+	}
+	for name, lights := range written {
+		tp := day
+		tp.Light1, tp.Light2, tp.Channels = lights.Light1, lights.Light2, lights.Channels
+		if violations := profiles[name].Check([]TimePoint{tp}); len(violations) > 0 {
+			t.Errorf("%s rejects what create_xlsx.py writes: %v", name, violations)
+		}
+		tp.Channels = full(len(tp.Channels) + 1)
+		if len(lights.Channels) > 0 && len(profiles[name].Check([]TimePoint{tp})) == 0 {
+			t.Errorf("%s accepts %d channels", name, len(tp.Channels))
+		}
+	}
+}
+
+func TestLoadLimitProfiles(t *testing.T) {
+	yamlPath := writeFile(t, "limits.yaml", `
+chamber-a:
+  temperature: {min: 10, max: 35}
+  max_temperature_rate: 4
+`)
+	jsonPath := writeFile(t, "limits.json", `{"chamber-a": {"temperature": {"min": 10, "max": 35},
+"max_temperature_rate": 4}}`)
+	for _, p := range []string{yamlPath, jsonPath} {
+		profiles, err := LoadLimitProfiles(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := profiles["chamber-a"]
+		if got.Name != "chamber-a" || *got.Temperature.Min != 10 || *got.Temperature.Max != 35 ||
+			got.Temperature.check(35) != "" || got.MaxTemperatureRate != 4 || got.RelativeHumidity.Min != nil {
+			t.Errorf("loaded %+v from %s", got, p)
+		}
+	}
+
+	if _, err := LoadLimitProfiles(writeFile(t, "limits.yaml", "chamber-a:\n  colour: red\n")); err == nil {
+		t.Error("loaded a profile with an unknown field")
+	}
+	if _, err := LoadLimitProfiles(writeFile(t, "limits.toml", "")); err == nil {
+		t.Error("loaded an unsupported file type")
+	}
+}
+
+func TestLoadScheduleWithLimits(t *testing.T) {
+	p := writeFile(t, "conditions.csv", `datetime,humidity
+2020-01-01 00:00,55
+2020-01-01 06:00,150
+`)
+	profile := DefaultLimitProfiles()["psi"]
+	_, err := LoadScheduleWithOptions(discardLog, p, LoadOptions{Limits: &profile})
+	if vs, ok := errors.Cause(err).(LimitViolations); !ok || len(vs) != 1 || vs[0].Index != 1 {
+		t.Errorf("loading a schedule outside the limits returned %v", err)
+	}
+}
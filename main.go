@@ -1,8 +1,9 @@
 package chamber_tools
 
 import (
-	"bufio"
 	"fmt"
+	"github.com/appf-anu/chamber-tools/derived"
+	"github.com/appf-anu/chamber-tools/fixture"
 	"github.com/bcampbell/fuzzytime"
 	"github.com/mdaffin/go-telegraf"
 	"github.com/pkg/errors"
@@ -10,15 +11,15 @@ import (
 	"log"
 	"math"
 	"os"
-	"path/filepath"
 	"reflect"
-	"regexp"
-	"strconv"
 	"strings"
 	"time"
 )
 
-// Indices type to store the column indexes of columns with specific headers denoted by "header" tags
+// Indices type to store the column indexes of columns with specific headers denoted by "header" tags.
+// vpd is a vapour pressure deficit in kPa that is converted to a RelativeHumidity target using the temperature on the
+// same row. spectrum is a light target like "400 red:blue=3:1 far-red=5%" that is solved into Channels for Fixture, see
+// fixture.ParseSpectrum.
 type Indices struct {
 	DatetimeIdx    int   `header:"datetime"`
 	SimDatetimeIdx int   `header:"datetime-sim"`
@@ -28,7 +29,13 @@ type Indices struct {
 	Light2Idx      int   `header:"light2"`
 	CO2Idx         int   `header:"co2"`
 	TotalSolarIdx  int   `header:"totalsolar"`
+	VPDIdx         int   `header:"vpd"`
+	SpectrumIdx    int   `header:"spectrum"`
 	ChannelsIdx    []int `header:"channel-%d"`
+	// Interpolation is the interpolation declared for columns in their headers, eg. "temperature:step"
+	Interpolation InterpolationPolicy
+	// Fixture is the light fixture that spectrum cells are solved for, from LoadOptions.Fixture
+	Fixture *fixture.Profile
 }
 
 // it is extremely unlikely (see. impossible) that we will be measuring or sending a humidity of 214,748,365 %RH or
@@ -46,61 +53,118 @@ const NullTargetInt64 int64 = math.MinInt64
 // NullTargetFloat64 exported see above
 const NullTargetFloat64 float64 = -math.MaxFloat32
 
-// IndexConfig package level struct to store indices. -1 means it doesnt exist.
-var IndexConfig = &Indices{
-	-1,
-	-1,
-	-1,
-	-1,
-	-1,
-	-1,
-	-1,
-	-1,
-	[]int{},
+// NewIndices returns an Indices with every column marked as missing (-1)
+func NewIndices() Indices {
+	return Indices{
+		DatetimeIdx:    -1,
+		SimDatetimeIdx: -1,
+		TemperatureIdx: -1,
+		HumidityIdx:    -1,
+		Light1Idx:      -1,
+		Light2Idx:      -1,
+		CO2Idx:         -1,
+		TotalSolarIdx:  -1,
+		VPDIdx:         -1,
+		SpectrumIdx:    -1,
+		ChannelsIdx:    []int{},
+		Interpolation:  InterpolationPolicy{},
+	}
 }
 
+// Column returns the index of the column with a header, or -1 if there isn't one
+func (indices Indices) Column(header string) int {
+	switch header {
+	case "datetime":
+		return indices.DatetimeIdx
+	case "datetime-sim":
+		return indices.SimDatetimeIdx
+	case "temperature":
+		return indices.TemperatureIdx
+	case "humidity":
+		return indices.HumidityIdx
+	case "light1":
+		return indices.Light1Idx
+	case "light2":
+		return indices.Light2Idx
+	case "co2":
+		return indices.CO2Idx
+	case "totalsolar":
+		return indices.TotalSolarIdx
+	case "vpd":
+		return indices.VPDIdx
+	case "spectrum":
+		return indices.SpectrumIdx
+	}
+	var n int
+	if _, err := fmt.Sscanf(header, "channel-%d", &n); err == nil && n >= 1 && n <= len(indices.ChannelsIdx) {
+		return indices.ChannelsIdx[n-1]
+	}
+	return -1
+}
+
+// IndexConfig package level struct to store indices. -1 means it doesnt exist.
+//
+// Deprecated: IndexConfig is shared by every conditions file in the process. It is only kept populated by
+// InitIndexConfig for existing callers, use the Indices returned by InitIndexConfig or ReadIndices, or Schedule.Indices.
+var IndexConfig = func() *Indices {
+	idx := NewIndices()
+	return &idx
+}()
+
+// TimePoint is a row of a conditions file, unset targets are not Valid
 type TimePoint struct {
 	Datetime         time.Time
 	SimDatetime      time.Time
-	Temperature      float64
-	RelativeHumidity float64
-	Light1           int
-	Light2           int
-	CO2              float64
-	TotalSolar       float64
-	Channels         []float64
+	Temperature      NullFloat64
+	RelativeHumidity NullFloat64
+	Light1           NullInt
+	Light2           NullInt
+	CO2              NullFloat64
+	TotalSolar       NullFloat64
+	Channels         []NullFloat64
 }
 
+// NulledString returns the timepoint formatted with %+v, unset targets are formatted as NULL
 func (tp TimePoint) NulledString() string {
-	repr := fmt.Sprintf("%+v", tp)
-	nullTargetFloatStr := fmt.Sprintf("%v", NullTargetFloat64)
-	repr = strings.Replace(repr, nullTargetFloatStr, "NULL", -1)
-	nullTargetIntStr := fmt.Sprintf("%v", NullTargetInt)
-	repr = strings.Replace(repr, nullTargetIntStr, "NULL", -1)
-	return repr
+	return fmt.Sprintf("%+v", tp)
+}
+
+// Derived returns the quantities derived from the temperature and humidity targets of the timepoint, eg. for metrics.
+// returns false if either target is unset or the humidity is not above 0.
+func (tp TimePoint) Derived() (derived.Values, bool) {
+	if !tp.Temperature.Valid || !tp.RelativeHumidity.Valid || tp.RelativeHumidity.Float64 <= 0 {
+		return derived.Values{}, false
+	}
+	return derived.FromTemperatureAndHumidity(tp.Temperature.Float64, tp.RelativeHumidity.Float64), true
+}
+
+// Equal returns true if two timepoints have the same targets at the same time. datetimes are compared as instants,
+// as csv and xlsx files are read in different locations.
+func (tp TimePoint) Equal(other TimePoint) bool {
+	if !tp.Datetime.Equal(other.Datetime) || !tp.SimDatetime.Equal(other.SimDatetime) {
+		return false
+	}
+	tp.Datetime, other.Datetime = time.Time{}, time.Time{}
+	tp.SimDatetime, other.SimDatetime = time.Time{}, time.Time{}
+	return reflect.DeepEqual(tp, other)
 }
 
 var (
 	ctx fuzzytime.Context
 	// ZoneName exported so that packages that use this package can refer to the current timezone
-	ZoneName   string
-	zoneOffset int
+	ZoneName string
 )
 
-const (
-	matchFloatExp = `[-+]?\d*\.\d+|\d+`
-)
-
-var /* const */ matchFloat = regexp.MustCompile(matchFloatExp)
-
 func init() {
-	ZoneName, zoneOffset = time.Now().Zone()
+	ZoneName, _ = time.Now().Zone()
 	ctx = fuzzytime.Context{
 		DateResolver: fuzzytime.DMYResolver,
 		TZResolver:   fuzzytime.DefaultTZResolver(ZoneName),
 	}
 }
 
+// parseDateTime parses a datetime as wall time in time.Local. the offset is looked up for each date, so that datetimes
+// are in daylight saving time when it is in effect on their date, like the datetimes of xlsx files.
 func parseDateTime(tString string, errLog *log.Logger) (time.Time, error) {
 
 	datetimeValue, _, err := ctx.Extract(tString)
@@ -114,9 +178,13 @@ func parseDateTime(tString string, errLog *log.Logger) (time.Time, error) {
 	datetimeValue.Time.SetHour(datetimeValue.Time.Hour())
 	datetimeValue.Time.SetMinute(datetimeValue.Time.Minute())
 	datetimeValue.Time.SetSecond(datetimeValue.Time.Second())
-	datetimeValue.Time.SetTZOffset(zoneOffset)
+	datetimeValue.Time.SetTZOffset(0)
 
-	return time.Parse("2006-01-02T15:04:05Z07:00", datetimeValue.ISOFormat())
+	t, err := time.Parse("2006-01-02T15:04:05Z07:00", datetimeValue.ISOFormat())
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, time.Local), nil
 }
 
 // Min returns a value clamped to a lower limit limit
@@ -140,6 +208,27 @@ func Clamp(value, minimum, maximum int) int {
 	return Min(Max(value, minimum), maximum)
 }
 
+// MinFloat64 returns the smaller of value and limit, clamping value to an upper limit
+func MinFloat64(value, limit float64) float64 {
+	if value < limit {
+		return value
+	}
+	return limit
+}
+
+// MaxFloat64 returns the larger of value and limit, clamping value to a lower limit
+func MaxFloat64(value, limit float64) float64 {
+	if value > limit {
+		return value
+	}
+	return limit
+}
+
+// ClampFloat64 clamps a value to between a minimum and maximum value
+func ClampFloat64(value, minimum, maximum float64) float64 {
+	return MinFloat64(MaxFloat64(value, minimum), maximum)
+}
+
 func indexInSlice(a string, list []string) int {
 	for i, b := range list {
 		if strings.Trim(b, "\t ,\n") == a {
@@ -149,11 +238,29 @@ func indexInSlice(a string, list []string) int {
 	return -1
 }
 
-func getIndices(errLog *log.Logger, headerLine []string) {
+// getIndices finds the column index of each header in headerLine
+func getIndices(errLog *log.Logger, headerLine []string) Indices {
 	// initialize as invalid/empty
+	indices := NewIndices()
+
+	// strip interpolation annotations from the headers
+	names := make([]string, len(headerLine))
+	for i, h := range headerLine {
+		name, annotation := splitHeaderAnnotation(trimField(h))
+		names[i] = name
+		if annotation == "" {
+			continue
+		}
+		interpolation, err := ParseInterpolation(annotation)
+		if err != nil {
+			errLog.Printf("header %q: %v", h, err)
+			continue
+		}
+		indices.Interpolation[name] = interpolation
+	}
 
-	v := reflect.ValueOf(IndexConfig)
-	t := reflect.TypeOf(IndexConfig)
+	v := reflect.ValueOf(&indices)
+	t := reflect.TypeOf(&indices)
 
 	for i := 0; i < t.Elem().NumField(); i++ {
 		field := v.Elem().Field(i)
@@ -165,7 +272,7 @@ func getIndices(errLog *log.Logger, headerLine []string) {
 
 		if field.CanSet() {
 			if field.Kind() == reflect.Int {
-				if idx := indexInSlice(header, headerLine); idx >= 0 {
+				if idx := indexInSlice(header, names); idx >= 0 {
 					field.SetInt(int64(idx))
 				}
 			}
@@ -174,7 +281,7 @@ func getIndices(errLog *log.Logger, headerLine []string) {
 				cIdx := 1 // start at channel 1
 				for {
 					cHeader := fmt.Sprintf(header, cIdx)
-					if idx := indexInSlice(cHeader, headerLine); idx >= 0 {
+					if idx := indexInSlice(cHeader, names); idx >= 0 {
 						iVal := reflect.ValueOf(int(idx))
 						field.Set(reflect.Append(field, iVal))
 						cIdx++
@@ -185,6 +292,7 @@ func getIndices(errLog *log.Logger, headerLine []string) {
 			}
 		}
 	}
+	return indices
 }
 
 // DecodeStructFieldToMeasurement turns a field of a struct into a measurement field and adds it to the measurment.
@@ -216,6 +324,16 @@ func DecodeStructFieldToMeasurement(m *telegraf.Measurement, va reflect.Value, i
 		m.AddString(n, v)
 	case bool:
 		m.AddBool(n, v)
+	case NullFloat64:
+		if !v.Valid {
+			break
+		}
+		m.AddFloat64(n, v.Float64)
+	case NullInt:
+		if !v.Valid {
+			break
+		}
+		m.AddInt(n, v.Int)
 	case []int64:
 		for i, iv := range v {
 			if iv == NullTargetInt64 {
@@ -255,722 +373,86 @@ func DecodeStructFieldToMeasurement(m *telegraf.Measurement, va reflect.Value, i
 		for i, iv := range v {
 			m.AddBool(fmt.Sprintf("%s-%02d", n, i+1), iv)
 		}
-	}
-}
-
-// InitIndexConfig populates the chamber_tools.IndexConfig struct from a header line
-func InitIndexConfig(errLog *log.Logger, conditionsPath string) {
-	if filepath.Ext(conditionsPath) == ".xlsx" {
-		xlFile, err := xlsx.OpenFile(conditionsPath)
-		if err != nil {
-			log.Fatal(err)
-		}
-		sheet, ok := xlFile.Sheet["timepoints"]
-		if !ok {
-			fmt.Println("no sheet named \"timepoints\" in xlsx file")
-			os.Exit(3)
-		}
-
-		row := sheet.Row(0)
-
-		headers := make([]string, 0)
-		for _, cell := range row.Cells {
-			headers = append(headers, cell.String())
-		}
-
-		getIndices(errLog, headers)
-	}
-	if filepath.Ext(conditionsPath) == ".csv" {
-		file, err := os.Open(conditionsPath)
-		if err != nil {
-			log.Fatal(err)
-		}
-		defer file.Close()
-
-		scanner := bufio.NewScanner(file)
-		scanner.Scan()
-		line := scanner.Text()
-		lineSplit := strings.Split(line, ",")
-
-		if err := scanner.Err(); err != nil {
-			log.Fatal(err)
-		}
-
-		getIndices(errLog, lineSplit)
-	}
-	errLog.Printf("%#v\n", IndexConfig)
-	if IndexConfig.DatetimeIdx < 0 {
-		errLog.Println("No datetime header in conditions file")
-		os.Exit(1)
-	}
-}
-
-func NewTimePointFromStringArray(errLog *log.Logger, row []string) (*TimePoint, error) {
-	tp := &TimePoint{}
-	for i, cell := range row {
-
-		if i == IndexConfig.DatetimeIdx {
-			t, err := parseDateTime(cell, errLog)
-			if err != nil {
-				return nil, err
-			}
-			tp.Datetime = t
-		}
-		if i == IndexConfig.SimDatetimeIdx {
-			t, err := parseDateTime(cell, errLog)
-			if err != nil {
-				errLog.Println("Couldn't get SimDatetime")
-				continue
-			}
-			tp.SimDatetime = t
-		}
-		if i == IndexConfig.TemperatureIdx {
-			found := matchFloat.FindString(cell)
-			if len(found) < 0 {
-				return nil, errors.New("no temp value found")
-			}
-			t, err := strconv.ParseFloat(found, 64)
-			if err != nil {
-				errLog.Println("failed parsing float")
-				return nil, err
-			}
-			tp.Temperature = t
-		}
-		if i == IndexConfig.HumidityIdx {
-			found := matchFloat.FindString(cell)
-			if len(found) < 0 {
-				return nil, errors.New("no hum value found")
-			}
-			t, err := strconv.ParseFloat(found, 64)
-			if err != nil {
-				errLog.Println("failed parsing humidity float")
-				return nil, err
-			}
-			tp.RelativeHumidity = t
-		}
-		if i == IndexConfig.CO2Idx {
-			found := matchFloat.FindString(cell)
-			if len(found) < 0 {
-				return nil, errors.New("no Co2 value found")
-			}
-			t, err := strconv.ParseFloat(found, 64)
-			if err != nil {
-				errLog.Println("failed parsing CO2 float")
-				return nil, err
-			}
-			tp.CO2 = t
-		}
-		if i == IndexConfig.TotalSolarIdx {
-			found := matchFloat.FindString(cell)
-			if len(found) < 0 {
-				return nil, errors.New("no total solar value found")
-			}
-			t, err := strconv.ParseFloat(found, 64)
-			if err != nil {
-				errLog.Println("failed parsing TotalSolar float")
-				return nil, err
-			}
-			tp.TotalSolar = t
-		}
-		if i == IndexConfig.Light1Idx {
-			found := strings.TrimSpace(cell)
-			t, err := strconv.ParseInt(found, 10, 64)
-			if err != nil {
-				errLog.Println("failed parsing Light1 int")
-				return nil, err
-			}
-			tp.Light1 = int(t)
-		}
-		if i == IndexConfig.Light2Idx {
-			found := strings.TrimSpace(cell)
-			t, err := strconv.ParseInt(found, 10, 64)
-			if err != nil {
-				errLog.Println("failed parsing Light2 int")
-				return nil, err
-			}
-			tp.Light2 = int(t)
-		}
-	}
-	// do channels
-
-	for chaNumber, chanIdx := range IndexConfig.ChannelsIdx {
-		v := row[chanIdx]
-		found := matchFloat.FindString(v)
-		if len(found) < 0 {
-			errLog.Printf("couldnt parse channel-%d \"%s\" as a float\n", chaNumber+1, v)
-			continue
-		}
-		chanValue, err := strconv.ParseFloat(found, 64)
-		if err != nil {
-			errLog.Println(err)
-			tp.Channels = append(tp.Channels, -1.0)
-			continue
-		}
-		tp.Channels = append(tp.Channels, chanValue)
-	}
-	return tp, nil
-
-}
-
-func NewTimePointFromRow(errLog *log.Logger, row *xlsx.Row) (*TimePoint, error) {
-	tp := &TimePoint{
-		Temperature:      NullTargetFloat64,
-		RelativeHumidity: NullTargetFloat64,
-		Light1:           NullTargetInt,
-		Light2:           NullTargetInt,
-		CO2:              NullTargetFloat64,
-		TotalSolar:       NullTargetFloat64,
-	}
-	for i, cell := range row.Cells {
-
-		if i == IndexConfig.DatetimeIdx {
-			t, err := cell.GetTime(false)
-			if err != nil {
-				return nil, err
-			}
-			tp.Datetime = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(),
-				time.Local)
-		}
-		if i == IndexConfig.SimDatetimeIdx {
-			t, err := cell.GetTime(false)
-			if err != nil {
-				errLog.Println("Couldn't get SimDatetime from row")
-				continue
-			}
-			tp.SimDatetime = t
-		}
-		if i == IndexConfig.TemperatureIdx {
-			if cell.String() == "" || cell.String() == "NULL" {
-				tp.Temperature = NullTargetFloat64
-				continue
-			}
-			t, err := cell.Float()
-			if err != nil {
-				return nil, err
-			}
-			tp.Temperature = t
-
-		}
-		if i == IndexConfig.HumidityIdx {
-			if cell.String() == "" || cell.String() == "NULL" {
-				tp.RelativeHumidity = NullTargetFloat64
-				continue
-			}
-			t, err := cell.Float()
-			if err != nil {
-				return nil, err
-			}
-			tp.RelativeHumidity = t
-
-		}
-		if i == IndexConfig.CO2Idx {
-			if cell.String() == "" || cell.String() == "NULL" {
-				tp.CO2 = NullTargetFloat64
-				continue
-			}
-			t, err := cell.Float()
-			if err != nil {
-				return nil, err
-			}
-			tp.CO2 = t
-
-		}
-		if i == IndexConfig.TotalSolarIdx {
-			if cell.String() == "" || cell.String() == "NULL" {
-				tp.TotalSolar = NullTargetFloat64
-				continue
-			}
-			t, err := cell.Float()
-			if err != nil {
-				errLog.Println("Couldn't get TotalSolar from row")
-				continue
-			}
-			tp.TotalSolar = t
-
-		}
-		if i == IndexConfig.Light1Idx {
-			if cell.String() == "" || cell.String() == "NULL" {
-				tp.Light1 = NullTargetInt
+	case []NullFloat64:
+		for i, iv := range v {
+			if !iv.Valid {
 				continue
 			}
-			t, err := cell.Int()
-			if err != nil {
-				return nil, err
-			}
-			tp.Light1 = t
-
+			m.AddFloat64(fmt.Sprintf("%s-%02d", n, i+1), iv.Float64)
 		}
-		if i == IndexConfig.Light2Idx {
-			if cell.String() == "" || cell.String() == "NULL" {
-				tp.Light2 = NullTargetInt
+	case []NullInt:
+		for i, iv := range v {
+			if !iv.Valid {
 				continue
 			}
-
-			t, err := cell.Int()
-			if err != nil {
-				return nil, err
-			}
-			tp.Light2 = t
-
-		}
-	}
-	// do channels
-	for _, chanIdx := range IndexConfig.ChannelsIdx {
-		cell := row.Cells[chanIdx]
-		// handle NULL targets
-		if cell.String() == "" || cell.String() == "NULL" {
-			tp.Channels = append(tp.Channels, NullTargetFloat64)
-			continue
+			m.AddInt(fmt.Sprintf("%s-%02d", n, i+1), iv.Int)
 		}
-
-		chanValue, err := cell.Float()
-		// we still need to append a null target if we cant get the value
-		if err != nil {
-			tp.Channels = append(tp.Channels, NullTargetFloat64)
-			continue
-		}
-		tp.Channels = append(tp.Channels, chanValue)
 	}
-	return tp, nil
-
 }
 
-func loopFromCsv(errLog *log.Logger, runStuff func(point *TimePoint) bool, conditionsPath string) {
-	InitIndexConfig(errLog, conditionsPath)
-	file, err := os.Open(conditionsPath)
+// ReadIndices reads the header line of a conditions file and returns its column layout
+func ReadIndices(errLog *log.Logger, conditionsPath string) (Indices, error) {
+	headers, err := readHeaders(conditionsPath, 0)
 	if err != nil {
-		log.Fatal(err)
-	}
-	defer file.Close()
-
-	scanner := bufio.NewScanner(file)
-	idx := 0
-	var lastLineSplit []string
-	firstRun := true
-
-	var firstTime time.Time
-	data := make([]string, 0)
-	for scanner.Scan() {
-		line := scanner.Text()
-		lineSplit := strings.Split(line, ",")
-		if idx == 0 {
-			idx++
-			continue
-		}
-
-		timeStr := lineSplit[IndexConfig.DatetimeIdx]
-		theTime, err := parseDateTime(timeStr, errLog)
-		if err != nil {
-			errLog.Println(err)
-			continue
-		}
-		if firstTime.Unix() <= 0 {
-			firstTime = theTime
-		}
-		if theTime.After(firstTime.Add(time.Hour * 24)) {
-			break
-		}
-		data = append(data, line)
+		return NewIndices(), err
 	}
-
-	errLog.Printf("looping over %d timepoints", len(data))
-	for {
-		for i, line := range data {
-			lineSplit := strings.Split(line, ",")
-			timeStr := lineSplit[0]
-			tempTime, err := parseDateTime(timeStr, errLog)
-			if err != nil {
-				errLog.Println(err)
-				continue
-			}
-
-			now := time.Now()
-
-			theTime := time.Date(
-				now.Year(),
-				now.Month(),
-				now.Day(),
-				tempTime.Hour(),
-				tempTime.Minute(),
-				tempTime.Second(),
-				tempTime.Nanosecond(),
-				time.Local)
-
-			if i == len(data)-1 { // end of data, set the next time to tomorrow
-				lastLineSplit = lineSplit
-				timeStr = strings.Split(data[0], ",")[0]
-				tempTime, err = parseDateTime(timeStr, errLog)
-				if err != nil {
-					errLog.Println(err)
-					continue
-				}
-				theTime = time.Date(
-					now.Year(),
-					now.Month(),
-					now.Day(),
-					tempTime.Hour(),
-					tempTime.Minute(),
-					tempTime.Second(),
-					tempTime.Nanosecond(),
-					time.Local).Add(time.Hour * 24)
-
-				errLog.Println("Reached end of data, set time to ", theTime)
-			} else {
-				// check if theTime is Before
-				if theTime.Before(time.Now()) {
-					lastLineSplit = lineSplit
-					continue
-				}
-			}
-
-			// run the last timepoint if its the first run.
-			if firstRun {
-				firstRun = false
-				errLog.Println("running firstrun line")
-				for i := 0; i < 10; i++ {
-					tp, err := NewTimePointFromStringArray(errLog, lastLineSplit)
-					if err != nil {
-						errLog.Println(err)
-						break
-					}
-					// print the point:
-					errLog.Printf("TimePoint: %s", tp.NulledString())
-					if runStuff(tp) {
-						break
-					}
-				}
-			}
-
-			// we have reached
-			errLog.Printf("sleeping for %s\n", time.Until(theTime).String())
-			time.Sleep(time.Until(theTime))
-
-			// RUN STUFF HERE
-			for i := 0; i < 10; i++ {
-				tp, err := NewTimePointFromStringArray(errLog, lineSplit)
-				if err != nil {
-					errLog.Println(err)
-					break
-				}
-				errLog.Printf("TimePoint: %s", tp.NulledString())
-				if runStuff(tp) {
-					break
-				}
-			}
-
-		}
+	indices := getIndices(errLog, headers)
+	if indices.DatetimeIdx < 0 {
+		return indices, errors.Errorf("no datetime header in conditions file %s", conditionsPath)
 	}
+	return indices, nil
 }
 
-func runFromCsv(errLog *log.Logger, runStuff func(point *TimePoint) bool, conditionsPath string) {
-	InitIndexConfig(errLog, conditionsPath)
-	file, err := os.Open(conditionsPath)
+// InitIndexConfig reads the column layout from the header line of a conditions file and returns it.
+// it also populates the deprecated chamber_tools.IndexConfig struct for existing callers.
+func InitIndexConfig(errLog *log.Logger, conditionsPath string) Indices {
+	indices, err := ReadIndices(errLog, conditionsPath)
+	errLog.Printf("%#v\n", indices)
 	if err != nil {
-		log.Fatal(err)
-	}
-	defer file.Close()
-
-	scanner := bufio.NewScanner(file)
-	idx := 0
-	var lastLineSplit []string
-	firstRun := true
-
-	for scanner.Scan() {
-		line := scanner.Text()
-
-		lineSplit := strings.Split(line, ",")
-		if idx == 0 {
-			idx++
-			continue
-		}
-
-		timeStr := lineSplit[IndexConfig.DatetimeIdx]
-		theTime, err := parseDateTime(timeStr, errLog)
-		if err != nil {
-			errLog.Println(err)
-			continue
-		}
-
-		// if we are before the time skip until we are after it
-		// the -10s means that we shouldnt run again.
-		if theTime.Before(time.Now()) {
-			lastLineSplit = lineSplit
-			continue
-		}
-
-		if firstRun {
-			firstRun = false
-			errLog.Println("running firstrun line")
-			for i := 0; i < 10; i++ {
-				tp, err := NewTimePointFromStringArray(errLog, lastLineSplit)
-				if err != nil {
-					errLog.Println(err)
-					break
-				}
-				errLog.Printf("TimePoint: %s", tp.NulledString())
-				if runStuff(tp) {
-					break
-				}
-			}
-		}
-
-		errLog.Printf("sleeping for %s\n", time.Until(theTime).String())
-		time.Sleep(time.Until(theTime))
-
-		// RUN STUFF HERE
-		for i := 0; i < 10; i++ {
-			tp, err := NewTimePointFromStringArray(errLog, lineSplit)
-			if err != nil {
-				errLog.Println(err)
-				break
-			}
-			errLog.Printf("TimePoint: %s", tp.NulledString())
-			if runStuff(tp) {
-				break
-			}
-		}
-		// end RUN STUFF
-		idx++
+		errLog.Println(err)
+		os.Exit(1)
 	}
+	*IndexConfig = indices
+	return indices
 }
 
-func loopFromXlsx(errLog *log.Logger, runStuff func(point *TimePoint) bool, conditionsPath string) {
-	InitIndexConfig(errLog, conditionsPath)
-	xlFile, err := xlsx.OpenFile(conditionsPath)
-	if err != nil {
-		log.Fatal(err)
-	}
-	// excel files dont require closing?
-	//defer file.Close()
-	sheet, ok := xlFile.Sheet["timepoints"]
-	if !ok {
-		fmt.Println("no sheet named \"timepoints\" in xlsx file")
-		os.Exit(3)
-	}
-
-	var lastTp *TimePoint
-	var lastTpIdx int
-	firstRun := true
-
-	var firstTime time.Time
-	data := make([]*TimePoint, 0)
-	for i, row := range sheet.Rows {
-		if i == 0 {
-			continue
-		}
-		// skip rows with less than 2 cells
-		if len(row.Cells) < 2 {
-			errLog.Printf("row %05d has less than 2 cells", i)
-			continue
-		}
-		if row.Cells[IndexConfig.DatetimeIdx].String() == "" {
-			errLog.Printf("row %05d has empty datetime cell", i)
-			continue
-		}
-
-		tempTime, err := row.Cells[IndexConfig.DatetimeIdx].GetTime(false)
-		if err != nil {
-			errLog.Printf("error while extracting time value for row %d, %v", i, err)
-			continue
-		}
-		theTime := time.Date(
-			tempTime.Year(),
-			tempTime.Month(),
-			tempTime.Day(),
-			tempTime.Hour(),
-			tempTime.Minute(),
-			tempTime.Second(),
-			tempTime.Nanosecond(),
-			time.Local)
-		if firstTime.Unix() <= 0 { // check to see if firsttime has been set
-			firstTime = theTime
-		}
-		if theTime.After(firstTime.Add(time.Hour * 24)) {
-			break
-		}
-		tp, err := NewTimePointFromRow(errLog, row)
-		if err != nil {
-			errLog.Printf(" Error adding timepoint to loop data: %v", err)
-			continue
-		}
-		data = append(data, tp)
-	}
-
-	totalTimepoints := len(data) - 1
-	errLog.Printf("looping over %d timepoints", totalTimepoints)
-	for {
-		for i, tp := range data {
-			thisTpIdx := i
-			now := time.Now()
-
-			theTime := time.Date(
-				now.Year(),
-				now.Month(),
-				now.Day(),
-				tp.Datetime.Hour(),
-				tp.Datetime.Minute(),
-				tp.Datetime.Second(),
-				tp.Datetime.Nanosecond(),
-				time.Local)
-
-			if i == len(data)-1 { // end of data, set the next time to tomorrow
-				lastTp = tp
-				lastTpIdx = i
-				thisTpIdx = 0
-				theTime = time.Date(
-					now.Year(),
-					now.Month(),
-					now.Day(),
-					data[0].Datetime.Hour(),
-					data[0].Datetime.Minute(),
-					data[0].Datetime.Second(),
-					data[0].Datetime.Nanosecond(),
-					time.Local).Add(time.Hour * 24)
-				errLog.Printf("Reached end of data, looping from beginning. next TimePoint at %v ", theTime)
-			} else {
-				// check if theTime is Before
-				if theTime.Before(time.Now()) {
-					lastTp = tp
-					lastTpIdx = i
-					continue
-				}
-			}
-
-			// run the last timepoint if its the first run.
-			if firstRun {
-				firstRun = false
-				errLog.Printf("running initial TimePoint %05d/%05d", lastTpIdx, totalTimepoints)
-				for i := 0; i < 10; i++ {
-					errLog.Printf("TimePoint: %s", lastTp.NulledString())
-					if runStuff(lastTp) {
-						break
-					}
-				}
-			}
-
-			// we have reached sleeptime
-			errLog.Printf("sleeping for %s until TimePoint %05d/%05d at %v",
-				time.Until(theTime).String(), thisTpIdx, totalTimepoints, tp.Datetime)
-			time.Sleep(time.Until(theTime))
-
-			// RUN STUFF HERE
-			for try := 0; try < 10; try++ {
-				errLog.Printf("running TimePoint %05d/%05d", lastTpIdx, totalTimepoints)
-				errLog.Printf("TimePoint: %s", tp.NulledString())
-				if runStuff(tp) {
-					break
-				}
-			}
-			// end RUN STUFF
-		}
-	}
+// NewTimePointFromStringArray creates a TimePoint from a csv row using the column layout in IndexConfig.
+//
+// Deprecated: use Indices.NewTimePointFromStringArray with the layout of the file the row came from.
+func NewTimePointFromStringArray(errLog *log.Logger, row []string) (*TimePoint, error) {
+	return IndexConfig.NewTimePointFromStringArray(errLog, row)
 }
 
-func runFromXlsx(errLog *log.Logger, runStuff func(point *TimePoint) bool, conditionsPath string) {
-	InitIndexConfig(errLog, conditionsPath)
-	xlFile, err := xlsx.OpenFile(conditionsPath)
-	if err != nil {
-		log.Fatal(err)
-	}
-	// excel files dont require closing?
-	//defer file.Close()
-	sheet, ok := xlFile.Sheet["timepoints"]
-	if !ok {
-		fmt.Println("no sheet named \"timepoints\" in xlsx file")
-		os.Exit(3)
-	}
-
-	var lastRow *xlsx.Row
-	firstRun := true
-
-	for i, row := range sheet.Rows {
-		if i == 0 {
-			continue
-		}
-		// skip rows with less than 2 cells
-		if len(row.Cells) < 2 {
-			errLog.Printf("row %05d has less than 2 cells", i)
-			continue
-		}
-		if row.Cells[IndexConfig.DatetimeIdx].String() == "" {
-			errLog.Printf("row %05d has empty datetime cell", i)
-			continue
-		}
-		tempTime, err := row.Cells[IndexConfig.DatetimeIdx].GetTime(false)
-
-		if err != nil {
-			errLog.Printf("error while extracting time value for row %d, %v", i, err)
-			continue
-		}
-		theTime := time.Date(
-			tempTime.Year(),
-			tempTime.Month(),
-			tempTime.Day(),
-			tempTime.Hour(),
-			tempTime.Minute(),
-			tempTime.Second(),
-			tempTime.Nanosecond(),
-			time.Local)
-
-		// if we are before the time skip until we are after it
-		if theTime.Before(time.Now()) {
-			lastRow = row
-			continue
-		}
-
-		// run the last timepoint if its the first run.
-		if firstRun {
-			firstRun = false
-			errLog.Println("running firstrun line")
-			for i := 0; i < 10; i++ {
-				tp, err := NewTimePointFromRow(errLog, lastRow)
-				if err != nil {
-					errLog.Println(err)
-					break
-				}
-				errLog.Printf("TimePoint: %s", tp.NulledString())
-				if runStuff(tp) {
-					break
-				}
-			}
-		}
-
-		// we have reached sleeptime
-		errLog.Printf("sleeping for %s\n", time.Until(theTime).String())
-		time.Sleep(time.Until(theTime))
-
-		// RUN STUFF HERE
-		for i := 0; i < 10; i++ {
-			tp, err := NewTimePointFromRow(errLog, row)
-			if err != nil {
-				errLog.Println(err)
-				break
-			}
-			errLog.Printf("TimePoint: %s", tp.NulledString())
-			if runStuff(tp) {
-				break
-			}
-		}
-		// end RUN STUFF
-	}
+// NewTimePointFromRow creates a TimePoint from an xlsx row using the column layout in IndexConfig.
+//
+// Deprecated: use Indices.NewTimePointFromRow with the layout of the file the row came from.
+func NewTimePointFromRow(errLog *log.Logger, row *xlsx.Row) (*TimePoint, error) {
+	return IndexConfig.NewTimePointFromRow(errLog, row)
 }
 
-// RunConditions runs conditions for a file
-func RunConditions(errLog *log.Logger, runStuff func(point *TimePoint) bool, conditionsPath string, loopFirstDay bool) {
-
-	errLog.Printf("running conditions file: %s\n", conditionsPath)
+// NewTimePointFromStringArray creates a TimePoint from a csv row using this column layout
+func (indices Indices) NewTimePointFromStringArray(errLog *log.Logger, row []string) (*TimePoint, error) {
+	return indices.decodeTimePoint(errLog, row, func(i int) (time.Time, error) {
+		return parseDateTime(trimField(row[i]), errLog)
+	})
+}
 
-	if filepath.Ext(conditionsPath) == ".xlsx" {
-		if loopFirstDay {
-			loopFromXlsx(errLog, runStuff, conditionsPath)
-		}
-		runFromXlsx(errLog, runStuff, conditionsPath)
+// NewTimePointFromRow creates a TimePoint from an xlsx row using this column layout.
+// both datetime columns are read as spreadsheet dates in time.Local, rounded to the millisecond, like the datetimes of a
+// csv file. every other cell is decoded from the value stored in it rather than the text it is formatted as.
+func (indices Indices) NewTimePointFromRow(errLog *log.Logger, row *xlsx.Row) (*TimePoint, error) {
+	cells := make([]string, len(row.Cells))
+	for i, cell := range row.Cells {
+		cells[i] = cell.Value
 	}
-	if filepath.Ext(conditionsPath) == ".csv" {
-		if loopFirstDay {
-			loopFromCsv(errLog, runStuff, conditionsPath)
+	return indices.decodeTimePoint(errLog, cells, func(i int) (time.Time, error) {
+		t, err := row.Cells[i].GetTime(false)
+		if err != nil {
+			return time.Time{}, err
 		}
-		runFromCsv(errLog, runStuff, conditionsPath)
-	}
-
+		// spreadsheet dates are days stored as floats, which are off by some nanoseconds
+		t = t.Round(time.Millisecond)
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(),
+			time.Local), nil
+	})
 }
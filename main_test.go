@@ -0,0 +1,38 @@
+package chamber_tools
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestInitIndexConfig checks that the deprecated IndexConfig is still populated for callers that decode rows with it
+func TestInitIndexConfig(t *testing.T) {
+	saved := *IndexConfig
+	t.Cleanup(func() { *IndexConfig = saved })
+
+	p := writeFile(t, "conditions.csv", "temperature,datetime,light1\n20,2020-01-01 00:00,2\n")
+	indices := InitIndexConfig(discardLog, p)
+	if indices.DatetimeIdx != 1 || indices.TemperatureIdx != 0 || indices.Light1Idx != 2 {
+		t.Errorf("column layout is %v", indices.Headers())
+	}
+	if !reflect.DeepEqual(*IndexConfig, indices) {
+		t.Errorf("IndexConfig is %+v, want %+v", *IndexConfig, indices)
+	}
+
+	tp, err := NewTimePointFromStringArray(discardLog, []string{"21", "2020-01-01 06:00", "3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tp.Temperature != NewNullFloat64(21) || tp.Light1 != NewNullInt(3) || tp.Datetime.Hour() != 6 {
+		t.Errorf("decoded %s with IndexConfig", tp.NulledString())
+	}
+
+	// loading a schedule leaves IndexConfig alone
+	other := writeFile(t, "other.csv", "datetime,humidity\n2020-01-01 00:00,55\n")
+	if _, err := LoadSchedule(discardLog, other); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(*IndexConfig, indices) {
+		t.Errorf("loading another file changed IndexConfig to %v", IndexConfig.Headers())
+	}
+}
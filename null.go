@@ -0,0 +1,166 @@
+package chamber_tools
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+)
+
+// nullText is how unset values are written as text, the same as in conditions files
+const nullText = "NULL"
+
+var nullJSON = []byte("null")
+
+// NullFloat64 is a float64 target that may be unset
+type NullFloat64 struct {
+	Float64 float64
+	// Valid is true if Float64 is set
+	Valid bool
+}
+
+// NewNullFloat64 returns a set NullFloat64
+func NewNullFloat64(v float64) NullFloat64 {
+	return NullFloat64{Float64: v, Valid: true}
+}
+
+// NullFloat64FromTarget converts a value that uses NullTargetFloat64 to mean unset
+func NullFloat64FromTarget(v float64) NullFloat64 {
+	if v == NullTargetFloat64 {
+		return NullFloat64{}
+	}
+	return NewNullFloat64(v)
+}
+
+// ValueOr returns the value if it is set, otherwise def
+func (n NullFloat64) ValueOr(def float64) float64 {
+	if !n.Valid {
+		return def
+	}
+	return n.Float64
+}
+
+// Target returns the value if it is set, otherwise NullTargetFloat64
+func (n NullFloat64) Target() float64 {
+	return n.ValueOr(NullTargetFloat64)
+}
+
+// String returns the value or NULL if it is unset
+func (n NullFloat64) String() string {
+	if !n.Valid {
+		return nullText
+	}
+	return strconv.FormatFloat(n.Float64, 'f', -1, 64)
+}
+
+// MarshalText implements encoding.TextMarshaler
+func (n NullFloat64) MarshalText() ([]byte, error) {
+	return []byte(n.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler, accepting anything that is a NULL cell in a conditions file
+func (n *NullFloat64) UnmarshalText(text []byte) error {
+	v, err := decodeFloatCell(string(text))
+	if err != nil {
+		return err
+	}
+	*n = v
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler, unset values are null
+func (n NullFloat64) MarshalJSON() ([]byte, error) {
+	if !n.Valid {
+		return nullJSON, nil
+	}
+	return json.Marshal(n.Float64)
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (n *NullFloat64) UnmarshalJSON(data []byte) error {
+	if bytes.Equal(data, nullJSON) {
+		*n = NullFloat64{}
+		return nil
+	}
+	if err := json.Unmarshal(data, &n.Float64); err != nil {
+		return err
+	}
+	n.Valid = true
+	return nil
+}
+
+// NullInt is an int target that may be unset
+type NullInt struct {
+	Int int
+	// Valid is true if Int is set
+	Valid bool
+}
+
+// NewNullInt returns a set NullInt
+func NewNullInt(v int) NullInt {
+	return NullInt{Int: v, Valid: true}
+}
+
+// NullIntFromTarget converts a value that uses NullTargetInt to mean unset
+func NullIntFromTarget(v int) NullInt {
+	if v == NullTargetInt {
+		return NullInt{}
+	}
+	return NewNullInt(v)
+}
+
+// ValueOr returns the value if it is set, otherwise def
+func (n NullInt) ValueOr(def int) int {
+	if !n.Valid {
+		return def
+	}
+	return n.Int
+}
+
+// Target returns the value if it is set, otherwise NullTargetInt
+func (n NullInt) Target() int {
+	return n.ValueOr(NullTargetInt)
+}
+
+// String returns the value or NULL if it is unset
+func (n NullInt) String() string {
+	if !n.Valid {
+		return nullText
+	}
+	return strconv.Itoa(n.Int)
+}
+
+// MarshalText implements encoding.TextMarshaler
+func (n NullInt) MarshalText() ([]byte, error) {
+	return []byte(n.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler, accepting anything that is a NULL cell in a conditions file
+func (n *NullInt) UnmarshalText(text []byte) error {
+	v, err := decodeIntCell(string(text))
+	if err != nil {
+		return err
+	}
+	*n = v
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler, unset values are null
+func (n NullInt) MarshalJSON() ([]byte, error) {
+	if !n.Valid {
+		return nullJSON, nil
+	}
+	return json.Marshal(n.Int)
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (n *NullInt) UnmarshalJSON(data []byte) error {
+	if bytes.Equal(data, nullJSON) {
+		*n = NullInt{}
+		return nil
+	}
+	if err := json.Unmarshal(data, &n.Int); err != nil {
+		return err
+	}
+	n.Valid = true
+	return nil
+}
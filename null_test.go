@@ -0,0 +1,93 @@
+package chamber_tools
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// nulls has a set and an unset value of each null type
+type nulls struct {
+	Float      NullFloat64 `json:"float"`
+	UnsetFloat NullFloat64 `json:"unset_float"`
+	Int        NullInt     `json:"int"`
+	UnsetInt   NullInt     `json:"unset_int"`
+}
+
+func TestNullJSON(t *testing.T) {
+	want := nulls{Float: NewNullFloat64(-2.5), Int: NewNullInt(3)}
+	data, err := json.Marshal(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := string(data); s != `{"float":-2.5,"unset_float":null,"int":3,"unset_int":null}` {
+		t.Errorf("marshalled %s", s)
+	}
+	// unset values are overwritten by null
+	got := nulls{UnsetFloat: NewNullFloat64(1), UnsetInt: NewNullInt(1)}
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Float != want.Float || got.UnsetFloat.Valid || got.Int != want.Int || got.UnsetInt.Valid {
+		t.Errorf("unmarshalled %+v, want %+v", got, want)
+	}
+
+	for _, data := range []string{`{"float":"warm"}`, `{"int":2.5}`} {
+		if err := json.Unmarshal([]byte(data), &got); err == nil {
+			t.Errorf("unmarshalled %s", data)
+		}
+	}
+}
+
+func TestNullText(t *testing.T) {
+	// text is used for map keys
+	want := map[NullInt]NullFloat64{NewNullInt(2): NewNullFloat64(0.5), {}: {}}
+	data, err := json.Marshal(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[NullInt]NullFloat64
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[NewNullInt(2)] != NewNullFloat64(0.5) || got[NullInt{}].Valid {
+		t.Errorf("round tripped %s to %v, want %v", data, got, want)
+	}
+
+	tests := map[string]NullFloat64{
+		"25.5":    NewNullFloat64(25.5),
+		"NULL":    {},
+		"-":       {},
+		"":        {},
+		" 20 °C ": NewNullFloat64(20),
+	}
+	for text, want := range tests {
+		var n NullFloat64
+		if err := n.UnmarshalText([]byte(text)); err != nil || n != want {
+			t.Errorf("UnmarshalText(%q) = %v, %v, want %v", text, n, err, want)
+		}
+		marshalled, _ := want.MarshalText()
+		var back NullFloat64
+		if err := back.UnmarshalText(marshalled); err != nil || back != want {
+			t.Errorf("%v round tripped through %q to %v, %v", want, marshalled, back, err)
+		}
+	}
+	var n NullInt
+	if err := n.UnmarshalText([]byte("2.5")); err == nil {
+		t.Errorf("unmarshalled 2.5 into a NullInt as %v", n)
+	}
+}
+
+func TestNullTargets(t *testing.T) {
+	if n := NullFloat64FromTarget(NullTargetFloat64); n.Valid || n.Target() != NullTargetFloat64 {
+		t.Errorf("NullFloat64FromTarget(NullTargetFloat64) = %v", n)
+	}
+	if n := NullIntFromTarget(NullTargetInt); n.Valid || n.Target() != NullTargetInt {
+		t.Errorf("NullIntFromTarget(NullTargetInt) = %v", n)
+	}
+	if n := NullFloat64FromTarget(0); n != NewNullFloat64(0) || n.ValueOr(1) != 0 {
+		t.Errorf("NullFloat64FromTarget(0) = %v", n)
+	}
+	if n := NullIntFromTarget(0); n != NewNullInt(0) || n.ValueOr(1) != 0 {
+		t.Errorf("NullIntFromTarget(0) = %v", n)
+	}
+}
@@ -0,0 +1,332 @@
+package chamber_tools
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// swapError stops a runner while it waits for a timepoint so that a reloaded schedule can be swapped in
+type swapError struct {
+	// At is when the runner was stopped, the swapped in schedule starts from then
+	At time.Time
+}
+
+func (e *swapError) Error() string {
+	return fmt.Sprintf("swapping in reloaded schedule at %v", e.At)
+}
+
+// maxDiffLines is the number of changed timepoints that are logged when a reloaded schedule is swapped in
+const maxDiffLines = 20
+
+// Reload watches the conditions file of a running schedule. when the file changes it is left to settle until its
+// modification time and size are the same for a whole PollInterval, so that a file that is still being written isn't
+// loaded, then it is validated and loaded again. the new schedule is swapped in straight away and starts with the
+// timepoint that is active then, as if the runner had been started at that time, and the old schedule keeps running if
+// the new one has errors.
+type Reload struct {
+	// PollInterval is how often the modification time of the conditions file is checked, 10 seconds if it is 0
+	PollInterval time.Duration
+	// OnReload, if it is not nil, is called with each schedule that is swapped in and how it differs from the schedule
+	// it replaced
+	OnReload func(s *Schedule, diff ScheduleDiff)
+}
+
+func (reload Reload) pollInterval() time.Duration {
+	if reload.PollInterval <= 0 {
+		return time.Second * 10
+	}
+	return reload.PollInterval
+}
+
+// TimePointChange is a timepoint that differs between two schedules. Old is nil if it was added, New is nil if it was
+// removed.
+type TimePointChange struct {
+	Datetime time.Time
+	Old      *TimePoint
+	New      *TimePoint
+}
+
+func (c TimePointChange) String() string {
+	switch {
+	case c.Old == nil:
+		return fmt.Sprintf("added TimePoint at %v: %s", c.Datetime, c.New.NulledString())
+	case c.New == nil:
+		return fmt.Sprintf("removed TimePoint at %v: %s", c.Datetime, c.Old.NulledString())
+	}
+	return fmt.Sprintf("changed TimePoint at %v:\n\t%s\n\t%s", c.Datetime, c.Old.NulledString(), c.New.NulledString())
+}
+
+// ScheduleDiff is how a schedule differs from the schedule it replaced
+type ScheduleDiff struct {
+	// OldHeaders and NewHeaders are the headers of the column layouts, they are only set if the layouts differ
+	OldHeaders []string
+	NewHeaders []string
+	// Changes are ordered by Datetime
+	Changes []TimePointChange
+}
+
+// DiffSchedules returns how the timepoints and column layout of b differ from a, timepoints are matched by Datetime
+func DiffSchedules(a, b *Schedule) ScheduleDiff {
+	var diff ScheduleDiff
+	oldHeaders, newHeaders := a.Indices.Headers(), b.Indices.Headers()
+	if strings.Join(oldHeaders, ",") != strings.Join(newHeaders, ",") {
+		diff.OldHeaders, diff.NewHeaders = oldHeaders, newHeaders
+	}
+
+	i, j := 0, 0
+	for i < a.Len() || j < b.Len() {
+		switch {
+		case j >= b.Len() || (i < a.Len() && a.TimePoints[i].Datetime.Before(b.TimePoints[j].Datetime)):
+			old := a.TimePoints[i]
+			diff.Changes = append(diff.Changes, TimePointChange{Datetime: old.Datetime, Old: &old})
+			i++
+		case i >= a.Len() || b.TimePoints[j].Datetime.Before(a.TimePoints[i].Datetime):
+			tp := b.TimePoints[j]
+			diff.Changes = append(diff.Changes, TimePointChange{Datetime: tp.Datetime, New: &tp})
+			j++
+		default:
+			old, tp := a.TimePoints[i], b.TimePoints[j]
+			if !old.Equal(tp) {
+				diff.Changes = append(diff.Changes, TimePointChange{Datetime: tp.Datetime, Old: &old, New: &tp})
+			}
+			i++
+			j++
+		}
+	}
+	return diff
+}
+
+// Empty returns true if the schedules are the same
+func (d ScheduleDiff) Empty() bool {
+	return d.OldHeaders == nil && len(d.Changes) == 0
+}
+
+func (d ScheduleDiff) String() string {
+	var added, removed, changed int
+	for _, c := range d.Changes {
+		switch {
+		case c.Old == nil:
+			added++
+		case c.New == nil:
+			removed++
+		default:
+			changed++
+		}
+	}
+	summary := fmt.Sprintf("%d timepoints added, %d removed, %d changed", added, removed, changed)
+	if d.OldHeaders != nil {
+		summary = fmt.Sprintf("headers changed from %v to %v, %s", d.OldHeaders, d.NewHeaders, summary)
+	}
+	return summary
+}
+
+// logDiff logs the summary of a diff and its first maxDiffLines changes
+func logDiff(errLog *log.Logger, diff ScheduleDiff) {
+	errLog.Println(diff)
+	for i, c := range diff.Changes {
+		if i == maxDiffLines {
+			errLog.Printf("and %d more changes", len(diff.Changes)-maxDiffLines)
+			break
+		}
+		errLog.Println(c)
+	}
+}
+
+// reloader polls a conditions file and keeps the last version of it that loaded without errors
+type reloader struct {
+	errLog  *log.Logger
+	path    string
+	reload  Reload
+	opts    LoadOptions
+	clock   Clock
+	modTime time.Time
+	size    int64
+	// changed is true if the last poll saw the file change, changedModTime and changedSize are what it changed to
+	changed        bool
+	changedModTime time.Time
+	changedSize    int64
+	// ready receives once a reloaded schedule is waiting to be swapped in
+	ready chan struct{}
+
+	mu      sync.Mutex
+	pending *Schedule
+}
+
+func newReloader(errLog *log.Logger, conditionsPath string, reload Reload, opts LoadOptions,
+	clock Clock) (*reloader, error) {
+
+	info, err := os.Stat(conditionsPath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldn't watch %s", conditionsPath)
+	}
+	return &reloader{
+		errLog:  errLog,
+		path:    conditionsPath,
+		reload:  reload,
+		opts:    opts,
+		clock:   clock,
+		modTime: info.ModTime(),
+		size:    info.Size(),
+		ready:   make(chan struct{}, 1),
+	}, nil
+}
+
+// watch checks the conditions file every PollInterval until ctx is done, returning a channel that is closed once it has
+// stopped. like readDriver the first wait starts before it returns.
+func (w *reloader) watch(ctx context.Context) <-chan struct{} {
+	stopped := make(chan struct{})
+	interval := w.reload.pollInterval()
+	next := w.clock.After(interval)
+	go func() {
+		defer close(stopped)
+		for {
+			var t time.Time
+			select {
+			case <-ctx.Done():
+				stopAfter(w.clock, next)
+				return
+			case t = <-next:
+			}
+			next = w.clock.After(t.Add(interval).Sub(w.clock.Now()))
+			w.check()
+		}
+	}()
+	return stopped
+}
+
+// check loads the conditions file if it has changed since it was last loaded and hasn't changed since the last poll,
+// so that it is swapped in if it has no errors. a version with errors doesn't replace one that loaded and is still
+// waiting to be swapped in.
+func (w *reloader) check() {
+	info, err := os.Stat(w.path)
+	if err != nil {
+		w.errLog.Printf("couldn't check %s for changes: %v", w.path, err)
+		return
+	}
+	modTime, size := info.ModTime(), info.Size()
+	if modTime.Equal(w.modTime) && size == w.size {
+		w.changed = false
+		return
+	}
+	if !w.changed || !modTime.Equal(w.changedModTime) || size != w.changedSize {
+		// it may still be being written, so it is loaded once it is the same at the next poll
+		w.changed, w.changedModTime, w.changedSize = true, modTime, size
+		w.errLog.Printf("%s has changed, reloading it once it has settled", w.path)
+		return
+	}
+	w.changed, w.modTime, w.size = false, modTime, size
+	w.errLog.Printf("%s has settled, reloading it", w.path)
+
+	s, err := w.load()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err != nil {
+		if w.pending != nil {
+			// the last version that loaded is still a valid edit of the file, so it is swapped in instead
+			w.errLog.Printf("swapping in the last version of %s that loaded, %v", w.path, err)
+			return
+		}
+		w.errLog.Printf("keeping the running schedule, %v", err)
+		return
+	}
+	w.errLog.Printf("swapping in %s", w.path)
+	w.pending = s
+	select {
+	case w.ready <- struct{}{}:
+	default: // the runner hasn't taken the schedule that was loaded before yet, it takes this one instead
+	}
+}
+
+// load reads the conditions file once, returning the schedule loaded from it or an error if it has any errors
+func (w *reloader) load() (*Schedule, error) {
+	s, issues, err := validate(w.errLog, w.path, ValidateOptions{LoadOptions: w.opts})
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldn't load %s", w.path)
+	}
+	if n := issues.Errors(); n > 0 {
+		for _, issue := range issues {
+			if issue.Severity == SeverityError {
+				w.errLog.Println(issue)
+			}
+		}
+		return nil, errors.Errorf("%s has %d errors", w.path, n)
+	}
+	w.errLog.Printf("loaded %d timepoints from %s", s.Len(), w.path)
+	return s, nil
+}
+
+// take returns the reloaded schedule that is waiting to be swapped in, and stops it waiting
+func (w *reloader) take() *Schedule {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	s := w.pending
+	w.pending = nil
+	return s
+}
+
+// runReloading runs the schedule like runSchedule, swapping in the conditions file whenever it changes
+func (r *runner) runReloading(ctx context.Context, s *Schedule) error {
+	errLog, opts := r.errLog, r.opts
+	if s.Path == "" {
+		return errors.New("can't reload a schedule that wasn't loaded from a file")
+	}
+
+	sim := opts.Simulation
+	if sim != nil {
+		// fix the simulation to the time the runner started, so that reloaded schedules carry on from the same
+		// simulated time instead of starting again
+		now := r.clock.Now()
+		offset, err := sim.Offset(s, now)
+		if err != nil {
+			return err
+		}
+		realStart := sim.RealStart
+		if realStart.IsZero() {
+			realStart = now
+		}
+		sim = &Simulation{SimStart: realStart.Add(-offset), RealStart: realStart}
+	}
+
+	w, err := newReloader(errLog, s.Path, *opts.Reload, opts.Load, r.clock)
+	if err != nil {
+		return err
+	}
+	watchCtx, stop := context.WithCancel(ctx)
+	stopped := w.watch(watchCtx)
+	defer func() {
+		stop()
+		<-stopped
+	}()
+	r.reloads = w.ready
+	defer func() {
+		r.reloads = nil
+	}()
+
+	for {
+		err := r.runSchedule(ctx, s, sim)
+		swap, ok := errors.Cause(err).(*swapError)
+		if !ok {
+			return err
+		}
+		// the schedule that is started next runs the timepoint that is active now
+		r.resumeAt = swap.At
+		reloaded := w.take()
+		if reloaded == nil {
+			// it was taken after an earlier signal, carry on with the running schedule
+			continue
+		}
+		diff := DiffSchedules(s, reloaded)
+		errLog.Printf("swapped in %s", s.Path)
+		logDiff(errLog, diff)
+		if opts.Reload.OnReload != nil {
+			opts.Reload.OnReload(reloaded, diff)
+		}
+		s = reloaded
+	}
+}
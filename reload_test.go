@@ -0,0 +1,190 @@
+package chamber_tools
+
+import (
+	"context"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// reloadedConditions is testConditions with every temperature 10 degrees higher
+const reloadedConditions = `datetime,temperature
+2020-01-01 00:00,10
+2020-01-01 03:00,11
+2020-01-01 06:00,12
+2020-01-01 09:00,13
+2020-01-01 12:00,14
+2020-01-01 15:00,15
+2020-01-01 18:00,16
+2020-01-01 21:00,17
+`
+
+// logLines records what is logged to it, so that a test can wait for a goroutine to log a message
+type logLines struct {
+	mu    sync.Mutex
+	lines strings.Builder
+	// waited is how much of lines has been searched by waitFor
+	waited int
+}
+
+func (l *logLines) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.lines.Write(p)
+}
+
+// waitFor waits for a line containing message to be logged after the message it last waited for
+func (l *logLines) waitFor(t *testing.T, message string) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second * 5); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		l.mu.Lock()
+		i := strings.Index(l.lines.String()[l.waited:], message)
+		if i >= 0 {
+			l.waited += i + len(message)
+		}
+		l.mu.Unlock()
+		if i >= 0 {
+			return
+		}
+	}
+	t.Fatalf("%q wasn't logged", message)
+}
+
+// edit is new contents for a conditions file, and what is logged once the reloader has checked them. the file is left
+// as it is for a poll if contents is empty.
+type edit struct {
+	contents string
+	logged   string
+}
+
+// runReloads runs testConditions from hours after its start, polling it for changes every 30 minutes. each edit is
+// written once the one before it has been checked, then the clock is stepped every 30 minutes until the schedule ends.
+func runReloads(t *testing.T, hours float64, edits ...edit) ([]ran, []ScheduleDiff) {
+	t.Helper()
+	conditionsPath := writeFile(t, "conditions.csv", testConditions)
+	s, err := LoadSchedule(discardLog, conditionsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := NewFakeClock(s.Start().Add(time.Duration(hours * float64(time.Hour))))
+	lines := &logLines{}
+	rec := &recorder{clock: clock}
+	var diffs []ScheduleDiff
+	opts := RunOptions{
+		Clock: clock,
+		Reload: &Reload{
+			PollInterval: time.Minute * 30,
+			OnReload: func(s *Schedule, diff ScheduleDiff) {
+				diffs = append(diffs, diff)
+			},
+		},
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- s.RunWith(context.Background(), log.New(lines, "", 0), rec.run, opts)
+	}()
+
+	// the runner waits for its next timepoint and the reloader for its next poll
+	for _, e := range edits {
+		clock.BlockUntil(2)
+		if e.contents != "" {
+			if err := os.WriteFile(conditionsPath, []byte(e.contents), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		clock.Advance(opts.Reload.PollInterval)
+		lines.waitFor(t, e.logged)
+	}
+	for clock.Now().Before(s.End()) {
+		clock.BlockUntil(2)
+		clock.Advance(opts.Reload.PollInterval)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if n := clock.Waiters(); n != 0 {
+		t.Errorf("%d waiters left on the clock, want the reloader stopped with the run", n)
+	}
+
+	// runs are recorded at the real times of the schedule, which starts at midnight in time.Local
+	runs := rec.runs()
+	for i := range runs {
+		runs[i].At = at(runs[i].At.Sub(s.Start()).Hours())
+	}
+	return runs, diffs
+}
+
+func TestReload(t *testing.T) {
+	// an edit is loaded at the poll after it is seen, once it hasn't changed for a poll, and swapped in straight away
+	changed := edit{reloadedConditions, "has changed, reloading it once it has settled"}
+	settled := edit{logged: "swapping in "}
+	t.Run("swapped in after the last timepoint", func(t *testing.T) {
+		runs, diffs := runReloads(t, 19, changed, settled)
+		assertRuns(t, runs, []ran{{6, at(19)}, {16, at(20)}, {17, at(21)}})
+		if len(diffs) != 1 || len(diffs[0].Changes) != 8 || diffs[0].OldHeaders != nil {
+			t.Errorf("reloaded with diffs %v, want every timepoint changed", diffs)
+		}
+	})
+	t.Run("swapped in before the last timepoint", func(t *testing.T) {
+		runs, _ := runReloads(t, 16, changed, settled)
+		assertRuns(t, runs, []ran{{5, at(16)}, {15, at(17)}, {16, at(18)}, {17, at(21)}})
+	})
+	t.Run("edit that is still being written", func(t *testing.T) {
+		// the first half of the file loads without errors, but it is still changing at the next poll
+		half := edit{reloadedConditions[:strings.Index(reloadedConditions, "2020-01-01 12:00")], changed.logged}
+		runs, diffs := runReloads(t, 16, half, changed, settled)
+		assertRuns(t, runs, []ran{{5, at(16)}, {15, at(17.5)}, {16, at(18)}, {17, at(21)}})
+		if len(diffs) != 1 || len(diffs[0].Changes) != 8 {
+			t.Errorf("reloaded with diffs %v, want the whole file swapped in once", diffs)
+		}
+	})
+	t.Run("later edit with errors", func(t *testing.T) {
+		broken := edit{reloadedConditions + "2020-01-01 22:00,hot\n", changed.logged}
+		kept := edit{logged: "keeping the running schedule"}
+		runs, diffs := runReloads(t, 19, changed, settled, broken, kept)
+		assertRuns(t, runs, []ran{{6, at(19)}, {16, at(20)}, {17, at(21)}})
+		if len(diffs) != 1 {
+			t.Errorf("reloaded %d times, want once", len(diffs))
+		}
+	})
+	t.Run("edit with errors", func(t *testing.T) {
+		broken := edit{"datetime,temperature\n2020-01-01 00:00,hot\n", changed.logged}
+		kept := edit{logged: "keeping the running schedule"}
+		runs, diffs := runReloads(t, 19, broken, kept)
+		assertRuns(t, runs, []ran{{6, at(19)}, {7, at(21)}})
+		if len(diffs) != 0 {
+			t.Errorf("reloaded %d times, want the running schedule kept", len(diffs))
+		}
+	})
+}
+
+func TestDiffSchedules(t *testing.T) {
+	a, b := testSchedule(), testSchedule()
+	b.TimePoints = append([]TimePoint(nil), b.TimePoints[1:]...)
+	b.TimePoints[0].Temperature = NewNullFloat64(20)
+	b.TimePoints = append(b.TimePoints, TimePoint{Datetime: at(24), Temperature: NewNullFloat64(8)})
+
+	diff := DiffSchedules(a, b)
+	if len(diff.Changes) != 3 {
+		t.Fatalf("%d changes, want 3: %v", len(diff.Changes), diff.Changes)
+	}
+	removed, changed, added := diff.Changes[0], diff.Changes[1], diff.Changes[2]
+	if removed.New != nil || !removed.Datetime.Equal(at(0)) {
+		t.Errorf("first change is %s, want the timepoint at 00:00 removed", removed)
+	}
+	if changed.Old == nil || changed.New == nil || changed.New.Temperature != NewNullFloat64(20) {
+		t.Errorf("second change is %s, want the temperature at 03:00 changed", changed)
+	}
+	if added.Old != nil || !added.Datetime.Equal(at(24)) {
+		t.Errorf("third change is %s, want a timepoint added at 24:00", added)
+	}
+	if got := diff.String(); got != "1 timepoints added, 1 removed, 1 changed" {
+		t.Errorf("String = %q", got)
+	}
+	if !DiffSchedules(a, testSchedule()).Empty() {
+		t.Error("the same schedules differ")
+	}
+}
@@ -0,0 +1,153 @@
+package chamber_tools
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// RunFunc runs a timepoint on a chamber, returning an error if it wasn't applied so that it is retried
+type RunFunc func(ctx context.Context, point *TimePoint) error
+
+// ErrNotApplied is the error of a runStuff callback that returned false
+var ErrNotApplied = errors.New("runStuff returned false")
+
+// BoolRunFunc adapts a runStuff callback that returns false if the timepoint wasn't applied to a RunFunc
+func BoolRunFunc(runStuff func(point *TimePoint) bool) RunFunc {
+	return func(ctx context.Context, point *TimePoint) error {
+		if !runStuff(point) {
+			return ErrNotApplied
+		}
+		return nil
+	}
+}
+
+// nextDueKey is the context key of the time the next timepoint is due
+type nextDueKey struct{}
+
+// NextDue returns when the timepoint after the one being run is due, from the context a RunFunc or Driver is called
+// with. returns false if there is no next timepoint, eg. for the last timepoint or the safe state.
+func NextDue(ctx context.Context) (time.Time, bool) {
+	next, ok := ctx.Value(nextDueKey{}).(time.Time)
+	return next, ok && !next.IsZero()
+}
+
+// RetryPolicy is how a timepoint is retried when running it fails. the zero value tries 10 times without waiting, like
+// the runners always have.
+type RetryPolicy struct {
+	// MaxAttempts is the number of times a timepoint is tried before it is abandoned, 10 if it is 0
+	MaxAttempts int
+	// InitialBackoff is the wait after the first failed attempt, there is no wait if it is 0
+	InitialBackoff time.Duration
+	// MaxBackoff caps the wait between attempts, it isn't capped if it is 0
+	MaxBackoff time.Duration
+	// Multiplier is what the wait is multiplied by after each failed attempt, 2 if it is 0
+	Multiplier float64
+	// Jitter is the fraction of each wait that is random, from 0 to 1, so that chambers that fail together don't retry
+	// together
+	Jitter float64
+	// GiveUpBefore abandons a timepoint if the next attempt would start less than GiveUpBefore before the next
+	// timepoint is due, so that a failing timepoint never delays the next one
+	GiveUpBefore time.Duration
+}
+
+// DefaultRetryPolicy returns a policy that backs off from 1 second to a minute, for chambers that are reached over a
+// network
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:    10,
+		InitialBackoff: time.Second,
+		MaxBackoff:     time.Minute,
+		Multiplier:     2,
+		Jitter:         0.2,
+		GiveUpBefore:   time.Second,
+	}
+}
+
+func (p RetryPolicy) maxAttempts() int {
+	if p.MaxAttempts <= 0 {
+		return 10
+	}
+	return p.MaxAttempts
+}
+
+// Backoff returns the wait after a failed attempt, numbered from 1. random is a number from 0 to 1 that the jitter is
+// taken from.
+func (p RetryPolicy) Backoff(attempt int, random float64) time.Duration {
+	if p.InitialBackoff <= 0 {
+		return 0
+	}
+	multiplier := p.Multiplier
+	if multiplier == 0 {
+		multiplier = 2
+	}
+	backoff := float64(p.InitialBackoff) * math.Pow(multiplier, float64(attempt-1))
+	if p.MaxBackoff > 0 {
+		backoff = math.Min(backoff, float64(p.MaxBackoff))
+	}
+	jitter := ClampFloat64(p.Jitter, 0, 1)
+	backoff *= 1 - jitter + 2*jitter*random
+	return time.Duration(backoff)
+}
+
+// AbandonError is the error a timepoint is abandoned with after it couldn't be run
+type AbandonError struct {
+	TimePoint TimePoint
+	// Attempts is the number of times the timepoint was tried
+	Attempts int
+	// Err is the error of the last attempt
+	Err error
+}
+
+func (e *AbandonError) Error() string {
+	return fmt.Sprintf("abandoned TimePoint at %v after %d attempts: %v", e.TimePoint.Datetime, e.Attempts, e.Err)
+}
+
+// Cause returns the error of the last attempt, for errors.Cause
+func (e *AbandonError) Cause() error {
+	return e.Err
+}
+
+// Unwrap returns the error of the last attempt, for errors.Is and errors.As
+func (e *AbandonError) Unwrap() error {
+	return e.Err
+}
+
+// retry runs tp with apply until it succeeds, following the retry policy. next is when the next timepoint is due, or
+// the zero time if there isn't one. returns the number of attempts, and an *AbandonError if every attempt failed or the
+// cause if ctx is done.
+func (r *runner) retry(ctx context.Context, tp TimePoint, next time.Time) (int, error) {
+	errLog, policy := r.errLog, r.opts.Retry
+	ctx = context.WithValue(ctx, nextDueKey{}, next)
+	var err error
+	attempt := 1
+	for ; ; attempt++ {
+		if ctx.Err() != nil {
+			return attempt - 1, context.Cause(ctx)
+		}
+		point := tp
+		errLog.Printf("TimePoint: %s", point.NulledString())
+		if err = r.apply(ctx, &point); err == nil {
+			return attempt, nil
+		}
+		errLog.Printf("attempt %d/%d failed: %v", attempt, policy.maxAttempts(), err)
+		if attempt >= policy.maxAttempts() {
+			break
+		}
+		backoff := policy.Backoff(attempt, rand.Float64())
+		retryAt := r.clock.Now().Add(backoff)
+		if !next.IsZero() && retryAt.After(next.Add(-policy.GiveUpBefore)) {
+			errLog.Printf("not retrying, the next TimePoint is due at %v", next)
+			break
+		}
+		if backoff > 0 {
+			if err := r.sleepUntil(ctx, retryAt); err != nil {
+				return attempt, err
+			}
+		}
+	}
+	return attempt, &AbandonError{TimePoint: tp, Attempts: attempt, Err: err}
+}
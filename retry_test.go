@@ -0,0 +1,157 @@
+package chamber_tools
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := RetryPolicy{InitialBackoff: time.Second, MaxBackoff: time.Second * 5}
+	tests := []struct {
+		policy  RetryPolicy
+		attempt int
+		random  float64
+		want    time.Duration
+	}{
+		{RetryPolicy{}, 3, 0.5, 0},
+		{policy, 1, 0.5, time.Second},
+		{policy, 2, 0.5, time.Second * 2},
+		{policy, 3, 0.5, time.Second * 4},
+		{policy, 4, 0.5, time.Second * 5},
+		{RetryPolicy{InitialBackoff: time.Second, Multiplier: 3}, 3, 0.5, time.Second * 9},
+		{RetryPolicy{InitialBackoff: time.Second * 10, Jitter: 0.2}, 1, 0, time.Second * 8},
+		{RetryPolicy{InitialBackoff: time.Second * 10, Jitter: 0.2}, 1, 1, time.Second * 12},
+		{RetryPolicy{InitialBackoff: time.Second * 10, Jitter: 5}, 1, 0, 0},
+	}
+	for _, test := range tests {
+		if got := test.policy.Backoff(test.attempt, test.random); got != test.want {
+			t.Errorf("%+v: Backoff(%d, %v) = %v, want %v", test.policy, test.attempt, test.random, got, test.want)
+		}
+	}
+}
+
+// TestRetry runs the first two timepoints of testSchedule on a FakeClock with a callback that fails the first
+// attempts at each of them
+func TestRetry(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy RetryPolicy
+		// fails is how many attempts at each timepoint fail
+		fails []int
+		want  []ran
+		// abandoned are the number of attempts of each abandoned timepoint
+		abandoned []int
+	}{
+		{
+			name:  "zero policy retries immediately",
+			fails: []int{3, 0},
+			want:  []ran{{0, at(0)}, {0, at(0)}, {0, at(0)}, {0, at(0)}, {1, at(3)}},
+		},
+		{
+			name:      "abandoned after max attempts",
+			policy:    RetryPolicy{MaxAttempts: 2},
+			fails:     []int{5, 5},
+			want:      []ran{{0, at(0)}, {0, at(0)}, {1, at(3)}, {1, at(3)}},
+			abandoned: []int{2, 2},
+		},
+		{
+			name:   "backs off",
+			policy: RetryPolicy{InitialBackoff: time.Minute * 30},
+			fails:  []int{2, 0},
+			want:   []ran{{0, at(0)}, {0, at(0.5)}, {0, at(1.5)}, {1, at(3)}},
+		},
+		{
+			name:      "gives up before the next timepoint",
+			policy:    RetryPolicy{InitialBackoff: time.Hour, GiveUpBefore: time.Minute * 30},
+			fails:     []int{5, 1},
+			want:      []ran{{0, at(0)}, {0, at(1)}, {1, at(3)}, {1, at(4)}},
+			abandoned: []int{2},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := testSchedule()
+			s.TimePoints = s.TimePoints[:2]
+			clock := NewFakeClock(at(0))
+			rec := &recorder{clock: clock}
+			attempts := make([]int, len(test.fails))
+			run := func(ctx context.Context, point *TimePoint) error {
+				rec.run(ctx, point)
+				i := int(point.Temperature.Float64)
+				if attempts[i]++; attempts[i] <= test.fails[i] {
+					return errors.Errorf("attempt %d failed", attempts[i])
+				}
+				return nil
+			}
+			var abandoned []int
+			err := drive(clock, func() error {
+				return s.RunWith(context.Background(), discardLog, run, RunOptions{
+					Clock: clock,
+					Retry: test.policy,
+					OnAbandon: func(err *AbandonError) {
+						abandoned = append(abandoned, err.Attempts)
+					},
+				})
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertRuns(t, rec.runs(), test.want)
+			if len(abandoned) != len(test.abandoned) {
+				t.Fatalf("abandoned after %v attempts, want %v", abandoned, test.abandoned)
+			}
+			for i := range abandoned {
+				if abandoned[i] != test.abandoned[i] {
+					t.Errorf("abandoned after %v attempts, want %v", abandoned, test.abandoned)
+				}
+			}
+		})
+	}
+}
+
+func TestNextDue(t *testing.T) {
+	s := testSchedule()
+	s.TimePoints = s.TimePoints[5:]
+	clock := NewFakeClock(at(16))
+	var due []time.Time
+	run := func(ctx context.Context, point *TimePoint) error {
+		next, ok := NextDue(ctx)
+		if !ok {
+			next = time.Time{}
+		}
+		due = append(due, next)
+		return nil
+	}
+	err := drive(clock, func() error {
+		return s.RunWith(context.Background(), discardLog, run, RunOptions{Clock: clock, Tick: time.Hour})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []time.Time{at(17), at(18), at(19), at(20), at(21), {}}
+	if len(due) != len(want) {
+		t.Fatalf("NextDue was %v, want %v", due, want)
+	}
+	for i := range want {
+		if !due[i].Equal(want[i]) {
+			t.Errorf("NextDue of run %d was %v, want %v", i, due[i], want[i])
+		}
+	}
+}
+
+func TestBoolRunFunc(t *testing.T) {
+	run := BoolRunFunc(func(point *TimePoint) bool { return point.Temperature.Valid })
+	if err := run(context.Background(), &TimePoint{Temperature: NewNullFloat64(1)}); err != nil {
+		t.Errorf("returned %v for an applied timepoint", err)
+	}
+	if err := run(context.Background(), &TimePoint{}); err != ErrNotApplied {
+		t.Errorf("returned %v for a timepoint that wasn't applied, want ErrNotApplied", err)
+	}
+
+	abandoned := &AbandonError{Attempts: 3, Err: ErrNotApplied}
+	if errors.Cause(errors.Wrap(abandoned, "running")) != ErrNotApplied {
+		t.Error("the cause of an AbandonError isn't the error of the last attempt")
+	}
+}
@@ -0,0 +1,404 @@
+package chamber_tools
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"log"
+	"time"
+)
+
+// RunOptions control how a schedule is run
+type RunOptions struct {
+	// LoopFirstDay loops over the first LoopPeriod of the schedule forever instead of running it once
+	LoopFirstDay bool
+	// LoopPeriod is the length of the loop, 24 hours is used if it is 0
+	LoopPeriod time.Duration
+	// LoopAnchor is the instant the first timepoint of the loop runs at, repeated every LoopPeriod before and after
+	// it. the Datetime of the first timepoint is used if it is the zero time, so a 24 hour loop runs each timepoint
+	// at the same time of day as in the conditions file.
+	LoopAnchor time.Time
+	// SafeState is run before the runner returns, for whatever reason, if it is not nil
+	SafeState *TimePoint
+	// Clock is used for all timekeeping, RealClock is used if it is nil
+	Clock Clock
+	// Tick, if it is not 0, runs timepoints interpolated with Schedule.At every Tick between the rows of the schedule
+	Tick time.Duration
+	// Clamp, if it is not nil, clamps every timepoint to the ranges of the profile before it is run
+	Clamp *LimitProfile
+	// Simulation, if it is not nil, runs timepoints at their SimDatetime shifted onto real time instead of at their
+	// Datetime, see Schedule.Simulate
+	Simulation *Simulation
+	// Retry is how timepoints are retried when running them fails
+	Retry RetryPolicy
+	// OnAbandon, if it is not nil, is called with each timepoint that is abandoned after it couldn't be run. the runner
+	// carries on with the next timepoint.
+	OnAbandon func(err *AbandonError)
+	// Reload, if it is not nil, watches the conditions file of the schedule and swaps in its changes once they have
+	// settled, see Reload
+	Reload *Reload
+	// Load are the options the RunConditions functions load the conditions file with, and that Reload loads it again
+	// with. set its Fixture to run spectrum columns and its Limits to refuse conditions outside a chamber's limits.
+	Load LoadOptions
+}
+
+func (opts RunOptions) clock() Clock {
+	if opts.Clock == nil {
+		return RealClock
+	}
+	return opts.Clock
+}
+
+func (opts RunOptions) loopPeriod() time.Duration {
+	if opts.LoopPeriod <= 0 {
+		return time.Hour * 24
+	}
+	return opts.LoopPeriod
+}
+
+// runner runs the timepoints of a schedule with apply
+type runner struct {
+	errLog *log.Logger
+	apply  RunFunc
+	opts   RunOptions
+	clock  Clock
+	// reloads, if it is not nil, receives when a reloaded schedule is waiting to be swapped in
+	reloads <-chan struct{}
+	// resumeAt is when a reloaded schedule was swapped in, it is zero unless a swapped in schedule is about to start
+	resumeAt time.Time
+}
+
+func newRunner(errLog *log.Logger, apply RunFunc, opts RunOptions) *runner {
+	return &runner{
+		errLog: errLog,
+		apply:  apply,
+		opts:   opts,
+		clock:  opts.clock(),
+	}
+}
+
+// sleepUntil sleeps until t or until ctx is done, returning the reason ctx was cancelled if it was.
+func (r *runner) sleepUntil(ctx context.Context, t time.Time) error {
+	return sleep(ctx, r.clock, t.Sub(r.clock.Now()))
+}
+
+// sleepUntilDue sleeps until the timepoint at t is due like sleepUntil, returning a *swapError as soon as a reloaded
+// schedule is waiting to be swapped in instead.
+func (r *runner) sleepUntilDue(ctx context.Context, t time.Time) error {
+	if r.reloads == nil {
+		return r.sleepUntil(ctx, t)
+	}
+	c := r.clock.After(t.Sub(r.clock.Now()))
+	select {
+	case <-ctx.Done():
+		stopAfter(r.clock, c)
+		return context.Cause(ctx)
+	case <-r.reloads:
+		stopAfter(r.clock, c)
+		return &swapError{At: r.clock.Now()}
+	case <-c:
+		return nil
+	}
+}
+
+// start returns the time a schedule starts running from, which is now unless a reloaded schedule is being swapped in.
+// resumed is true if it is, and t is when it was swapped in.
+func (r *runner) start() (t time.Time, resumed bool) {
+	if t = r.resumeAt; !t.IsZero() {
+		r.resumeAt = time.Time{}
+		return t, true
+	}
+	return r.clock.Now(), false
+}
+
+// nextDue returns when the timepoint after the one that runs at t is due, which is next or the tick after t if it is
+// sooner. returns the zero time if next is the zero time, as there are no ticks after the last timepoint.
+func (r *runner) nextDue(t, next time.Time) time.Time {
+	if tick := r.opts.Tick; tick > 0 && !next.IsZero() {
+		if n := t.Truncate(tick).Add(tick); n.Before(next) {
+			return n
+		}
+	}
+	return next
+}
+
+// runTimePoint runs a copy of tp with apply, retrying it with opts.Retry until it succeeds or is abandoned. next is when
+// the next timepoint is due, or the zero time if there isn't one. abandoned timepoints are passed to opts.OnAbandon
+// and skipped.
+func (r *runner) runTimePoint(ctx context.Context, tp TimePoint, next time.Time) error {
+	errLog := r.errLog
+	if r.opts.Clamp != nil {
+		var clamped bool
+		if tp, clamped = r.opts.Clamp.Clamp(tp); clamped {
+			errLog.Printf("clamped TimePoint to %s limits", r.opts.Clamp.Name)
+		}
+	}
+	_, err := r.retry(ctx, tp, next)
+	if abandoned, ok := err.(*AbandonError); ok {
+		errLog.Println(abandoned)
+		if r.opts.OnAbandon != nil {
+			r.opts.OnAbandon(abandoned)
+		}
+		return nil
+	}
+	return err
+}
+
+// runTicks runs the timepoints interpolated by at every tick after now and before end.
+func (r *runner) runTicks(ctx context.Context, now, end time.Time, at func(t time.Time) TimePoint) error {
+	tick := r.opts.Tick
+	for t := now.Truncate(tick).Add(tick); t.Before(end); t = t.Add(tick) {
+		if err := r.sleepUntilDue(ctx, t); err != nil {
+			return errors.Wrapf(err, "stopped while waiting for interpolated TimePoint at %v", t)
+		}
+		r.errLog.Printf("running interpolated TimePoint at %v", t)
+		if err := r.runTimePoint(ctx, at(t), r.nextDue(t, end)); err != nil {
+			return errors.Wrapf(err, "stopped while running interpolated TimePoint at %v", t)
+		}
+	}
+	return nil
+}
+
+// loop loops over the first opts.LoopPeriod of a schedule until ctx is done.
+// each cycle of the loop starts opts.LoopAnchor plus a whole number of periods, and the timepoints keep their offset
+// from the first timepoint. periods are measured in elapsed time, so a daylight saving change shifts the time of day.
+func (r *runner) loop(ctx context.Context, s *Schedule) error {
+	if s.Len() == 0 {
+		return errors.New("no timepoints to loop over")
+	}
+	errLog, opts := r.errLog, r.opts
+	period := opts.loopPeriod()
+	firstTime := s.Start()
+	anchor := opts.LoopAnchor
+	if anchor.IsZero() {
+		anchor = firstTime
+	}
+
+	// cycle is one period of the schedule, with the first timepoint repeated at the end so that the last timepoint
+	// interpolates towards the start of the next cycle.
+	cycle := &Schedule{Interpolation: s.Interpolation}
+	for _, tp := range s.TimePoints {
+		if !tp.Datetime.Before(firstTime.Add(period)) {
+			break
+		}
+		cycle.TimePoints = append(cycle.TimePoints, tp)
+	}
+	totalTimepoints := cycle.Len() - 1
+	wrap := cycle.TimePoints[0]
+	wrap.Datetime = firstTime.Add(period)
+	cycle.TimePoints = append(cycle.TimePoints, wrap)
+
+	// position returns the real time the cycle containing t started at, and the time in the schedule t maps to
+	position := func(t time.Time) (time.Time, time.Time) {
+		elapsed := t.Sub(anchor)
+		n := elapsed / period
+		if elapsed < 0 && elapsed%period != 0 {
+			n--
+		}
+		cycleStart := anchor.Add(n * period)
+		return cycleStart, firstTime.Add(t.Sub(cycleStart))
+	}
+	at := func(t time.Time) TimePoint {
+		_, pos := position(t)
+		tp, _ := cycle.At(pos)
+		return tp
+	}
+
+	errLog.Printf("looping over %d timepoints every %s from %v", totalTimepoints+1, period, anchor)
+
+	now, _ := r.start()
+	cycleStart, pos := position(now)
+	i := cycle.Index(pos)
+	// nextTime returns when the timepoint after i is due, the wrapped first timepoint follows the last one
+	nextTime := func(i int) time.Time {
+		return r.nextDue(now, cycleStart.Add(cycle.TimePoints[i+1].Datetime.Sub(firstTime)))
+	}
+
+	// run the timepoint that is already active
+	initial := cycle.TimePoints[i]
+	if opts.Tick > 0 {
+		initial = at(now)
+	}
+	errLog.Printf("running initial TimePoint %05d/%05d", i, totalTimepoints)
+	if err := r.runTimePoint(ctx, initial, nextTime(i)); err != nil {
+		return errors.Wrapf(err, "stopped while running initial TimePoint %05d", i)
+	}
+
+	for {
+		i++
+		if i > totalTimepoints { // end of data, the next timepoint starts the next cycle
+			i = 0
+			cycleStart = cycleStart.Add(period)
+			errLog.Printf("Reached end of data, looping from beginning. next TimePoint at %v ", cycleStart)
+		}
+		tp := cycle.TimePoints[i]
+		theTime := cycleStart.Add(tp.Datetime.Sub(firstTime))
+
+		if opts.Tick > 0 {
+			if err := r.runTicks(ctx, now, theTime, at); err != nil {
+				return err
+			}
+		}
+
+		// we have reached sleeptime
+		errLog.Printf("sleeping for %s until TimePoint %05d/%05d at %v",
+			theTime.Sub(r.clock.Now()).String(), i, totalTimepoints, tp.Datetime)
+		if err := r.sleepUntilDue(ctx, theTime); err != nil {
+			return errors.Wrapf(err, "stopped while waiting for TimePoint %05d at %v", i, theTime)
+		}
+
+		now = theTime
+		errLog.Printf("running TimePoint %05d/%05d", i, totalTimepoints)
+		if err := r.runTimePoint(ctx, tp, nextTime(i)); err != nil {
+			return errors.Wrapf(err, "stopped while running TimePoint %05d", i)
+		}
+	}
+}
+
+// run runs each timepoint of a schedule at its Datetime, starting with the timepoint that is already active.
+// if opts.Tick is set interpolated timepoints are run every tick between them.
+// returns nil once the last timepoint has been run.
+func (r *runner) run(ctx context.Context, s *Schedule) error {
+	errLog, opts := r.errLog, r.opts
+	totalTimepoints := s.Len() - 1
+	now, resumed := r.start()
+	first := s.Index(now) + 1
+
+	// run the timepoint that is already active, if the schedule hasn't finished. a schedule that was swapped in
+	// runs the timepoint that is active when it was swapped in even if it is the last one, so that its conditions
+	// are applied straight away.
+	if first > 0 && (first < s.Len() || resumed) {
+		initial := s.TimePoints[first-1]
+		if opts.Tick > 0 {
+			initial, _ = s.At(now)
+		}
+		var next time.Time
+		if first < s.Len() {
+			next = r.nextDue(now, s.TimePoints[first].Datetime)
+		}
+		errLog.Printf("running initial TimePoint %05d/%05d", first-1, totalTimepoints)
+		if err := r.runTimePoint(ctx, initial, next); err != nil {
+			return errors.Wrapf(err, "stopped while running initial TimePoint %05d", first-1)
+		}
+	}
+
+	for i := first; i < s.Len(); i++ {
+		tp := s.TimePoints[i]
+
+		if opts.Tick > 0 && i > 0 {
+			at := func(t time.Time) TimePoint {
+				point, _ := s.At(t)
+				return point
+			}
+			if err := r.runTicks(ctx, now, tp.Datetime, at); err != nil {
+				return err
+			}
+		}
+
+		// we have reached sleeptime
+		errLog.Printf("sleeping for %s until TimePoint %05d/%05d at %v",
+			tp.Datetime.Sub(r.clock.Now()).String(), i, totalTimepoints, tp.Datetime)
+		if err := r.sleepUntilDue(ctx, tp.Datetime); err != nil {
+			return errors.Wrapf(err, "stopped while waiting for TimePoint %05d at %v", i, tp.Datetime)
+		}
+
+		var next time.Time
+		if i+1 < s.Len() {
+			next = r.nextDue(tp.Datetime, s.TimePoints[i+1].Datetime)
+		}
+		errLog.Printf("running TimePoint %05d/%05d", i, totalTimepoints)
+		if err := r.runTimePoint(ctx, tp, next); err != nil {
+			return errors.Wrapf(err, "stopped while running TimePoint %05d", i)
+		}
+		now = tp.Datetime
+	}
+	return nil
+}
+
+// Run runs the schedule until it ends or ctx is done, with a runStuff callback that returns false if a timepoint
+// wasn't applied.
+func (s *Schedule) Run(ctx context.Context, errLog *log.Logger, runStuff func(point *TimePoint) bool,
+	opts RunOptions) error {
+
+	return s.RunWith(ctx, errLog, BoolRunFunc(runStuff), opts)
+}
+
+// RunWith runs the schedule until it ends or ctx is done, retrying timepoints that run returns an error for.
+// if ctx is cancelled the returned error wraps the cause of the cancellation, as it does for every runner in the
+// package.
+func (s *Schedule) RunWith(ctx context.Context, errLog *log.Logger, run RunFunc, opts RunOptions) error {
+	r := newRunner(errLog, run, opts)
+	if opts.SafeState != nil {
+		defer r.runSafeState(*opts.SafeState)
+	}
+
+	if opts.Reload != nil {
+		return r.runReloading(ctx, s)
+	}
+	return r.runSchedule(ctx, s, opts.Simulation)
+}
+
+// safeStateTimeout is how long the safe state is given to apply once the runner has stopped
+const safeStateTimeout = time.Second * 30
+
+// runSafeState tries the safe state once within safeStateTimeout, so that a chamber that can't be reached doesn't hold
+// up shutdown with retries.
+func (r *runner) runSafeState(tp TimePoint) {
+	r.errLog.Println("running safe state TimePoint")
+	if r.opts.Clamp != nil {
+		tp, _ = r.opts.Clamp.Clamp(tp)
+	}
+	// ctx may already be done, the safe state must still be applied.
+	ctx, cancel := context.WithTimeout(context.Background(), safeStateTimeout)
+	defer cancel()
+	once := *r
+	once.opts.Retry = RetryPolicy{MaxAttempts: 1}
+	if _, err := once.retry(ctx, tp, time.Time{}); err != nil {
+		r.errLog.Printf("couldn't run safe state TimePoint: %v", err)
+	}
+}
+
+// runSchedule simulates the schedule with sim if it is not nil, then runs it or loops over it
+func (r *runner) runSchedule(ctx context.Context, s *Schedule, sim *Simulation) error {
+	if sim != nil {
+		simulated, err := s.Simulate(r.errLog, *sim, r.clock.Now())
+		if err != nil {
+			return err
+		}
+		s = simulated
+	}
+
+	if r.opts.LoopFirstDay {
+		return r.loop(ctx, s)
+	}
+	return r.run(ctx, s)
+}
+
+// RunConditionsContext runs conditions for a file until the conditions end or ctx is done.
+func RunConditionsContext(ctx context.Context, errLog *log.Logger, runStuff func(point *TimePoint) bool,
+	conditionsPath string, opts RunOptions) error {
+
+	return RunConditionsWith(ctx, errLog, BoolRunFunc(runStuff), conditionsPath, opts)
+}
+
+// RunConditionsWith runs conditions for a file until the conditions end or ctx is done, retrying timepoints that run
+// returns an error for.
+func RunConditionsWith(ctx context.Context, errLog *log.Logger, run RunFunc, conditionsPath string,
+	opts RunOptions) error {
+
+	errLog.Printf("running conditions file: %s\n", conditionsPath)
+
+	schedule, err := LoadScheduleWithOptions(errLog, conditionsPath, opts.Load)
+	if err != nil {
+		return err
+	}
+	return schedule.RunWith(ctx, errLog, run, opts)
+}
+
+// RunConditions runs conditions for a file, returning an error if they couldn't be loaded or run
+func RunConditions(errLog *log.Logger, runStuff func(point *TimePoint) bool, conditionsPath string,
+	loopFirstDay bool) error {
+
+	return RunConditionsContext(context.Background(), errLog, runStuff, conditionsPath, RunOptions{
+		LoopFirstDay: loopFirstDay,
+	})
+}
@@ -0,0 +1,334 @@
+package chamber_tools
+
+import (
+	"context"
+	"github.com/appf-anu/chamber-tools/fixture"
+	"github.com/pkg/errors"
+	"math"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testStart is midnight on the first day of the schedules the runners are tested with
+var testStart = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// at returns the time on the first day of the test schedules at hours after midnight
+func at(hours float64) time.Time {
+	return testStart.Add(time.Duration(hours * float64(time.Hour)))
+}
+
+// testSchedule returns a 24 hour schedule with a timepoint every 3 hours, the Temperature of each is its index
+func testSchedule() *Schedule {
+	s := &Schedule{Indices: NewIndices(), Interpolation: DefaultInterpolationPolicy()}
+	for i := 0; i < 8; i++ {
+		s.TimePoints = append(s.TimePoints, TimePoint{
+			Datetime:    at(float64(i * 3)),
+			Temperature: NewNullFloat64(float64(i)),
+		})
+	}
+	return s
+}
+
+// ran is a timepoint that reached the run callback, identified by its Temperature, and the clock time it was run at
+type ran struct {
+	Index float64
+	At    time.Time
+}
+
+// recorder is a run callback that records every timepoint it is called with
+type recorder struct {
+	clock Clock
+	mu    sync.Mutex
+	ran   []ran
+	// onRun, if it is not nil, is called after each timepoint is recorded
+	onRun func(n int)
+}
+
+func (rec *recorder) run(ctx context.Context, point *TimePoint) error {
+	rec.mu.Lock()
+	rec.ran = append(rec.ran, ran{Index: point.Temperature.Float64, At: rec.clock.Now()})
+	n := len(rec.ran)
+	rec.mu.Unlock()
+	if rec.onRun != nil {
+		rec.onRun(n)
+	}
+	return nil
+}
+
+func (rec *recorder) runs() []ran {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	return append([]ran(nil), rec.ran...)
+}
+
+// drive advances clock to whatever is waiting on it next until run returns, and returns its error
+func drive(clock *FakeClock, run func() error) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- run()
+	}()
+	for {
+		select {
+		case err := <-done:
+			return err
+		default:
+		}
+		if !clock.AdvanceToNext() {
+			time.Sleep(time.Microsecond * 100)
+		}
+	}
+}
+
+// assertRuns fails the test if the recorded runs are not want
+func assertRuns(t *testing.T, got, want []ran) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("%d timepoints ran, want %d:\n\tgot  %v\n\twant %v", len(got), len(want), got, want)
+	}
+	for i := range want {
+		if math.Abs(got[i].Index-want[i].Index) > 1e-9 || !got[i].At.Equal(want[i].At) {
+			t.Errorf("run %d was TimePoint %v at %v, want TimePoint %v at %v",
+				i, got[i].Index, got[i].At, want[i].Index, want[i].At)
+		}
+	}
+}
+
+func TestRunOnFakeClock(t *testing.T) {
+	tests := []struct {
+		name  string
+		start time.Time
+		opts  RunOptions
+		want  []ran
+	}{
+		{
+			name:  "before first row",
+			start: at(-1),
+			want: []ran{
+				{0, at(0)}, {1, at(3)}, {2, at(6)}, {3, at(9)}, {4, at(12)}, {5, at(15)}, {6, at(18)}, {7, at(21)},
+			},
+		},
+		{
+			name:  "mid schedule runs the active row immediately",
+			start: at(10.5),
+			want:  []ran{{3, at(10.5)}, {4, at(12)}, {5, at(15)}, {6, at(18)}, {7, at(21)}},
+		},
+		{
+			name:  "on a row",
+			start: at(18),
+			want:  []ran{{6, at(18)}, {7, at(21)}},
+		},
+		{
+			name:  "after last row",
+			start: at(25),
+			want:  nil,
+		},
+		{
+			name:  "ticks between rows",
+			start: at(19),
+			opts:  RunOptions{Tick: time.Hour},
+			want:  []ran{{6 + 1.0/3, at(19)}, {6 + 2.0/3, at(20)}, {7, at(21)}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clock := NewFakeClock(test.start)
+			rec := &recorder{clock: clock}
+			opts := test.opts
+			opts.Clock = clock
+			err := drive(clock, func() error {
+				return testSchedule().RunWith(context.Background(), discardLog, rec.run, opts)
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertRuns(t, rec.runs(), test.want)
+			if n := clock.Waiters(); n != 0 {
+				t.Errorf("%d waiters left on the clock", n)
+			}
+		})
+	}
+}
+
+func TestRunCancelled(t *testing.T) {
+	stop := errors.New("stopped by the test")
+	tests := []struct {
+		name  string
+		start time.Time
+		opts  RunOptions
+		// cancelAfter is the number of timepoints that run before ctx is cancelled
+		cancelAfter int
+		want        []ran
+	}{
+		{
+			name:        "run",
+			start:       at(4),
+			cancelAfter: 3,
+			want:        []ran{{1, at(4)}, {2, at(6)}, {3, at(9)}},
+		},
+		{
+			name:        "loop wraps to the next day",
+			start:       at(20),
+			opts:        RunOptions{LoopFirstDay: true},
+			cancelAfter: 4,
+			want:        []ran{{6, at(20)}, {7, at(21)}, {0, at(24)}, {1, at(27)}},
+		},
+		{
+			name:        "safe state runs after cancellation",
+			start:       at(4),
+			opts:        RunOptions{SafeState: &TimePoint{Temperature: NewNullFloat64(-1)}},
+			cancelAfter: 2,
+			want:        []ran{{1, at(4)}, {2, at(6)}, {-1, at(6)}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clock := NewFakeClock(test.start)
+			ctx, cancel := context.WithCancelCause(context.Background())
+			defer cancel(nil)
+			rec := &recorder{clock: clock, onRun: func(n int) {
+				if n == test.cancelAfter {
+					cancel(stop)
+				}
+			}}
+			opts := test.opts
+			opts.Clock = clock
+			err := drive(clock, func() error {
+				return testSchedule().RunWith(ctx, discardLog, rec.run, opts)
+			})
+			if errors.Cause(err) != stop {
+				t.Errorf("returned %v, want the cause of the cancellation", err)
+			}
+			assertRuns(t, rec.runs(), test.want)
+			if n := clock.Waiters(); n != 0 {
+				t.Errorf("%d waiters left on the clock after cancellation", n)
+			}
+		})
+	}
+}
+
+func TestLoop(t *testing.T) {
+	twoDays := &Schedule{Indices: NewIndices()}
+	for i := 0; i < 16; i++ {
+		twoDays.TimePoints = append(twoDays.TimePoints, TimePoint{
+			Datetime:    at(float64(i * 3)),
+			Temperature: NewNullFloat64(float64(i)),
+		})
+	}
+	tests := []struct {
+		name     string
+		schedule *Schedule
+		start    time.Time
+		opts     RunOptions
+		want     []ran
+	}{
+		{
+			name:     "12 hour period",
+			schedule: testSchedule(),
+			start:    at(10),
+			opts:     RunOptions{LoopPeriod: time.Hour * 12},
+			want:     []ran{{3, at(10)}, {0, at(12)}, {1, at(15)}, {2, at(18)}, {3, at(21)}, {0, at(24)}},
+		},
+		{
+			name:     "36 hour period is not a multiple of a day",
+			schedule: twoDays,
+			start:    at(34),
+			opts:     RunOptions{LoopPeriod: time.Hour * 36},
+			want:     []ran{{11, at(34)}, {0, at(36)}, {1, at(39)}, {2, at(42)}, {3, at(45)}, {4, at(48)}},
+		},
+		{
+			name:     "anchored a day and an hour after the first timepoint",
+			schedule: testSchedule(),
+			start:    at(2),
+			opts:     RunOptions{LoopAnchor: at(25)},
+			want:     []ran{{0, at(2)}, {1, at(4)}, {2, at(7)}, {3, at(10)}, {4, at(13)}, {5, at(16)}},
+		},
+		{
+			name:     "started before the anchor",
+			schedule: testSchedule(),
+			start:    at(-2),
+			want:     []ran{{7, at(-2)}, {0, at(0)}, {1, at(3)}, {2, at(6)}, {3, at(9)}, {4, at(12)}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clock := NewFakeClock(test.start)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			rec := &recorder{clock: clock, onRun: func(n int) {
+				if n == len(test.want) {
+					cancel()
+				}
+			}}
+			opts := test.opts
+			opts.LoopFirstDay = true
+			opts.Clock = clock
+			err := drive(clock, func() error {
+				return test.schedule.RunWith(ctx, discardLog, rec.run, opts)
+			})
+			if errors.Cause(err) != context.Canceled {
+				t.Errorf("returned %v, want it to loop until it is cancelled", err)
+			}
+			assertRuns(t, rec.runs(), test.want)
+		})
+	}
+
+	if err := (&Schedule{}).RunWith(context.Background(), discardLog, nil, RunOptions{LoopFirstDay: true}); err == nil {
+		t.Error("looping over an empty schedule didn't fail")
+	}
+}
+
+// spectrumConditions is a day with the lights off at midnight and a spectrum from noon
+const spectrumConditions = `datetime,temperature,spectrum
+2020-01-01 00:00,20,0
+2020-01-01 12:00,25,100 red=100%
+`
+
+// ledProfile is a measured fixture with one red channel that delivers 200 µmol/m²/s at full output
+func ledProfile() *fixture.Profile {
+	return &fixture.Profile{
+		Name:     "led",
+		Unit:     fixture.Percent,
+		Channels: []fixture.Channel{{Name: "660nm", Wavelength: 660, MaxPPFD: 200}},
+	}
+}
+
+func TestRunConditionsLoadOptions(t *testing.T) {
+	conditionsPath := writeFile(t, "conditions.csv", spectrumConditions)
+	start := time.Date(2020, 1, 1, 11, 0, 0, 0, time.Local)
+	run := func(opts RunOptions) ([]TimePoint, error) {
+		clock := NewFakeClock(start)
+		opts.Clock = clock
+		var applied []TimePoint
+		apply := func(ctx context.Context, point *TimePoint) error {
+			applied = append(applied, *point)
+			return nil
+		}
+		err := drive(clock, func() error {
+			return RunConditionsWith(context.Background(), discardLog, apply, conditionsPath, opts)
+		})
+		return applied, err
+	}
+
+	applied, err := run(RunOptions{Load: LoadOptions{Fixture: ledProfile()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []NullFloat64{NewNullFloat64(0), NewNullFloat64(50)}
+	if len(applied) != 2 {
+		t.Fatalf("applied %d timepoints, want both rows", len(applied))
+	}
+	for i, tp := range applied {
+		if len(tp.Channels) != 1 || tp.Channels[0] != want[i] {
+			t.Errorf("applied %s, want the spectrum solved into channel-1 = %v", tp.NulledString(), want[i])
+		}
+	}
+
+	if applied, _ := run(RunOptions{}); len(applied) != 0 {
+		t.Errorf("applied %d timepoints without a fixture, want the spectrum rows skipped", len(applied))
+	}
+	limits := &LimitProfile{Name: "cool", Temperature: between(0, 22)}
+	if _, err := run(RunOptions{Load: LoadOptions{Fixture: ledProfile(), Limits: limits}}); err == nil {
+		t.Error("ran conditions outside the limits they were loaded with")
+	}
+}
@@ -0,0 +1,259 @@
+package chamber_tools
+
+import (
+	"github.com/appf-anu/chamber-tools/fixture"
+	"github.com/pkg/errors"
+	"github.com/tealeg/xlsx"
+	"log"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Schedule is a conditions file loaded into memory so that it can be inspected before it is run.
+type Schedule struct {
+	// Path is the conditions file the schedule was loaded from
+	Path string
+	// Indices is the column layout of the conditions file
+	Indices Indices
+	// Location is the timezone the datetimes in the conditions file were interpreted in
+	Location *time.Location
+	// Interpolation is how each column changes between timepoints when the schedule is queried with At
+	Interpolation InterpolationPolicy
+	// TimePoints are ordered by Datetime
+	TimePoints []TimePoint
+}
+
+// Len returns the number of timepoints in the schedule
+func (s *Schedule) Len() int {
+	return len(s.TimePoints)
+}
+
+// Start returns the Datetime of the first timepoint, or the zero time if the schedule is empty
+func (s *Schedule) Start() time.Time {
+	if len(s.TimePoints) == 0 {
+		return time.Time{}
+	}
+	return s.TimePoints[0].Datetime
+}
+
+// End returns the Datetime of the last timepoint, or the zero time if the schedule is empty
+func (s *Schedule) End() time.Time {
+	if len(s.TimePoints) == 0 {
+		return time.Time{}
+	}
+	return s.TimePoints[len(s.TimePoints)-1].Datetime
+}
+
+// Index returns the index of the timepoint that is active at t, that is the last timepoint at or before t.
+// returns -1 if t is before the first timepoint.
+func (s *Schedule) Index(t time.Time) int {
+	return sort.Search(len(s.TimePoints), func(i int) bool {
+		return s.TimePoints[i].Datetime.After(t)
+	}) - 1
+}
+
+// LoadOptions control how a conditions file is read into a Schedule
+type LoadOptions struct {
+	// Delimiter is the field delimiter of csv files, it is detected from the header line if it is 0
+	Delimiter rune
+	// Interpolation overrides the default interpolation policy and the interpolation declared in the headers
+	Interpolation InterpolationPolicy
+	// Limits, if it is not nil, fails loading with LimitViolations if any timepoint is outside the profile's limits
+	Limits *LimitProfile
+	// Fixture is the light fixture that spectrum columns are solved for, rows with a spectrum fail to load without it
+	Fixture *fixture.Profile
+}
+
+// LoadSchedule reads a .csv or .xlsx conditions file into a Schedule.
+// rows that cannot be parsed are logged to errLog and skipped.
+func LoadSchedule(errLog *log.Logger, conditionsPath string) (*Schedule, error) {
+	return LoadScheduleWithOptions(errLog, conditionsPath, LoadOptions{})
+}
+
+// LoadScheduleWithOptions reads a .csv or .xlsx conditions file into a Schedule.
+// rows that cannot be parsed are logged to errLog and skipped.
+func LoadScheduleWithOptions(errLog *log.Logger, conditionsPath string, opts LoadOptions) (*Schedule, error) {
+	s := newFileSchedule(conditionsPath)
+	_, err := s.readRows(errLog, opts, func(row int, tp *TimePoint, err error) {
+		if err != nil {
+			errLog.Printf("skipping %v", err)
+			return
+		}
+		s.TimePoints = append(s.TimePoints, *tp)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.finishLoading(opts)
+	if opts.Limits != nil {
+		if violations := opts.Limits.Check(s.TimePoints); len(violations) > 0 {
+			return nil, errors.Wrapf(violations, "%s is outside the %s limits", conditionsPath, opts.Limits.Name)
+		}
+	}
+	errLog.Printf("loaded %d timepoints from %s", len(s.TimePoints), conditionsPath)
+	return s, nil
+}
+
+// newFileSchedule returns an empty schedule for a conditions file, that its rows are read into
+func newFileSchedule(conditionsPath string) *Schedule {
+	return &Schedule{
+		Path:     conditionsPath,
+		Indices:  NewIndices(),
+		Location: time.Local,
+	}
+}
+
+// finishLoading orders the timepoints read from the conditions file by Datetime and sets the interpolation policy of
+// the schedule from its headers and opts
+func (s *Schedule) finishLoading(opts LoadOptions) {
+	s.Interpolation = DefaultInterpolationPolicy().Merge(s.Indices.Interpolation).Merge(opts.Interpolation)
+	sort.SliceStable(s.TimePoints, func(i, j int) bool {
+		return s.TimePoints[i].Datetime.Before(s.TimePoints[j].Datetime)
+	})
+}
+
+// setIndices sets the column layout of the schedule from its header line, with the fixture spectrum columns are solved
+// for
+func (s *Schedule) setIndices(errLog *log.Logger, headers []string, profile *fixture.Profile) error {
+	s.Indices = getIndices(errLog, headers)
+	s.Indices.Fixture = profile
+	if s.Indices.DatetimeIdx < 0 {
+		return errors.Errorf("no datetime header in conditions file %s", s.Path)
+	}
+	return nil
+}
+
+// readRows sets the column layout of the schedule from the header line of its file, then decodes every row after it
+// that isn't blank. fn is called with the row number, counting the header line as row 1, and either the decoded
+// timepoint or the *CellError or CellErrors of its bad cells. the header line is returned if it was read, even if the
+// column layout couldn't be set from it.
+func (s *Schedule) readRows(errLog *log.Logger, opts LoadOptions,
+	fn func(row int, tp *TimePoint, err error)) ([]string, error) {
+
+	switch filepath.Ext(s.Path) {
+	case ".xlsx":
+		return s.readXlsxRows(errLog, opts, fn)
+	case ".csv":
+		return s.readCsvRows(errLog, opts, fn)
+	}
+	return nil, errors.Errorf("unsupported conditions file type %q", filepath.Ext(s.Path))
+}
+
+// withRow adds the row number to a decoding error
+func withRow(row int, err error) error {
+	switch err := err.(type) {
+	case *CellError:
+		err.Row = row
+	case CellErrors:
+		for _, cellErr := range err {
+			cellErr.Row = row
+		}
+	}
+	return err
+}
+
+func (s *Schedule) readXlsxRows(errLog *log.Logger, opts LoadOptions,
+	fn func(row int, tp *TimePoint, err error)) ([]string, error) {
+
+	sheet, err := openTimepointsSheet(s.Path)
+	if err != nil {
+		return nil, err
+	}
+	if len(sheet.Rows) == 0 {
+		return nil, errors.Errorf("no header line in conditions file %s", s.Path)
+	}
+	headers := xlsxHeaders(sheet.Rows[0])
+	if err := s.setIndices(errLog, headers, opts.Fixture); err != nil {
+		return headers, err
+	}
+
+	for i, row := range sheet.Rows {
+		if i == 0 {
+			continue
+		}
+		cells := make([]string, len(row.Cells))
+		for c, cell := range row.Cells {
+			cells[c] = trimField(cell.Value)
+		}
+		if isEmptyRecord(cells) {
+			continue
+		}
+		tp, err := s.Indices.NewTimePointFromRow(errLog, row)
+		fn(i+1, tp, withRow(i+1, err))
+	}
+	return headers, nil
+}
+
+func (s *Schedule) readCsvRows(errLog *log.Logger, opts LoadOptions,
+	fn func(row int, tp *TimePoint, err error)) ([]string, error) {
+
+	records, err := readCsvFile(s.Path, opts.Delimiter)
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, errors.Errorf("no header line in conditions file %s", s.Path)
+	}
+	if err := s.setIndices(errLog, records[0], opts.Fixture); err != nil {
+		return records[0], err
+	}
+
+	for i, record := range records[1:] {
+		if isEmptyRecord(record) {
+			continue
+		}
+		tp, err := s.Indices.NewTimePointFromStringArray(errLog, record)
+		fn(i+2, tp, withRow(i+2, err))
+	}
+	return records[0], nil
+}
+
+// openTimepointsSheet opens an xlsx conditions file and returns its "timepoints" sheet
+func openTimepointsSheet(conditionsPath string) (*xlsx.Sheet, error) {
+	xlFile, err := xlsx.OpenFile(conditionsPath)
+	if err != nil {
+		return nil, err
+	}
+	sheet, ok := xlFile.Sheet["timepoints"]
+	if !ok {
+		return nil, errors.Errorf("no sheet named \"timepoints\" in xlsx file %s", conditionsPath)
+	}
+	return sheet, nil
+}
+
+// xlsxHeaders returns the trimmed text of each cell in an xlsx header row
+func xlsxHeaders(row *xlsx.Row) []string {
+	headers := make([]string, 0)
+	for _, cell := range row.Cells {
+		headers = append(headers, trimField(cell.String()))
+	}
+	return headers
+}
+
+// readHeaders reads the header line of a .csv or .xlsx conditions file.
+// the delimiter of csv files is detected if it is 0.
+func readHeaders(conditionsPath string, delimiter rune) ([]string, error) {
+	switch filepath.Ext(conditionsPath) {
+	case ".xlsx":
+		sheet, err := openTimepointsSheet(conditionsPath)
+		if err != nil {
+			return nil, err
+		}
+		if len(sheet.Rows) == 0 {
+			return nil, errors.Errorf("no header line in conditions file %s", conditionsPath)
+		}
+		return xlsxHeaders(sheet.Rows[0]), nil
+	case ".csv":
+		records, err := readCsvFile(conditionsPath, delimiter)
+		if err != nil {
+			return nil, err
+		}
+		if len(records) == 0 {
+			return nil, errors.Errorf("no header line in conditions file %s", conditionsPath)
+		}
+		return records[0], nil
+	}
+	return nil, errors.Errorf("unsupported conditions file type %q", filepath.Ext(conditionsPath))
+}
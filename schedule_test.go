@@ -0,0 +1,179 @@
+package chamber_tools
+
+import (
+	"github.com/tealeg/xlsx"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// discardLog is the errLog of tests, runners and loaders log every timepoint
+var discardLog = log.New(io.Discard, "", 0)
+
+// writeFile writes a file into a temporary directory of the test and returns its path
+func writeFile(t *testing.T, name, contents string) string {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(p, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestLoadScheduleSortsTimePoints(t *testing.T) {
+	p := writeFile(t, "conditions.csv", `datetime,temperature,humidity
+2020-01-01 12:00,25,60
+2020-01-01 00:00,20,55
+2020-01-01 06:00,22,
+`)
+	s, err := LoadSchedule(discardLog, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Len() != 3 {
+		t.Fatalf("loaded %d timepoints, want 3", s.Len())
+	}
+	for i, hour := range []int{0, 6, 12} {
+		if got := s.TimePoints[i].Datetime.Hour(); got != hour {
+			t.Errorf("TimePoint %d is at %02d:00, want %02d:00", i, got, hour)
+		}
+	}
+	if s.TimePoints[1].RelativeHumidity.Valid {
+		t.Errorf("blank humidity loaded as %v, want it unset", s.TimePoints[1].RelativeHumidity)
+	}
+	if s.Indices.TemperatureIdx != 1 || s.Indices.Light1Idx != -1 {
+		t.Errorf("column layout is %+v", s.Indices)
+	}
+	if s.Start().After(s.End()) {
+		t.Errorf("schedule starts at %v after it ends at %v", s.Start(), s.End())
+	}
+}
+
+// TestLoadSchedulesWithDifferentLayouts loads two files with their columns in different orders in one process, at the
+// same time, and checks that each is read with its own layout
+func TestLoadSchedulesWithDifferentLayouts(t *testing.T) {
+	files := []string{
+		writeFile(t, "a.csv", "datetime,temperature,humidity\n2020-01-01 00:00,20,55\n"),
+		writeFile(t, "b.csv", "humidity,light1,datetime,temperature\n55,2,2020-01-01 00:00,20\n"),
+	}
+	schedules := make([]*Schedule, len(files))
+	errs := make(chan error, len(files))
+	for i, p := range files {
+		go func(i int, p string) {
+			var err error
+			schedules[i], err = LoadSchedule(discardLog, p)
+			errs <- err
+		}(i, p)
+	}
+	for range files {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	a, b := schedules[0], schedules[1]
+	if a.Indices.DatetimeIdx != 0 || a.Indices.TemperatureIdx != 1 || a.Indices.Light1Idx != -1 {
+		t.Errorf("a.csv column layout is %v", a.Indices.Headers())
+	}
+	if b.Indices.DatetimeIdx != 2 || b.Indices.TemperatureIdx != 3 || b.Indices.Light1Idx != 1 {
+		t.Errorf("b.csv column layout is %v", b.Indices.Headers())
+	}
+	want := TimePoint{Datetime: a.TimePoints[0].Datetime, Temperature: NewNullFloat64(20),
+		RelativeHumidity: NewNullFloat64(55)}
+	if !a.TimePoints[0].Equal(want) {
+		t.Errorf("a.csv TimePoint is %s", a.TimePoints[0].NulledString())
+	}
+	want.Light1 = NewNullInt(2)
+	if !b.TimePoints[0].Equal(want) {
+		t.Errorf("b.csv TimePoint is %s", b.TimePoints[0].NulledString())
+	}
+}
+
+// inLocation sets time.Local to the named timezone until the end of the test, and returns it
+func inLocation(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	local := time.Local
+	time.Local = loc
+	t.Cleanup(func() {
+		time.Local = local
+	})
+	return loc
+}
+
+// TestLoadScheduleDaylightSaving loads datetimes either side of the daylight saving changes of Berlin, which are
+// wall times with the offset in effect on their date
+func TestLoadScheduleDaylightSaving(t *testing.T) {
+	inLocation(t, "Europe/Berlin")
+	s, err := LoadSchedule(discardLog, writeFile(t, "conditions.csv", `datetime,temperature
+2020-03-28 12:00,1
+2020-03-29 12:00,2
+2020-10-24 12:00,3
+2020-10-25 12:00,4
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, offset := range []int{1, 2, 2, 1} {
+		tp := s.TimePoints[i]
+		if _, got := tp.Datetime.Zone(); tp.Datetime.Hour() != 12 || got != offset*3600 {
+			t.Errorf("TimePoint %d is at %v, want 12:00 at +%02d00", i, tp.Datetime, offset)
+		}
+	}
+}
+
+func TestLoadScheduleEmptyFile(t *testing.T) {
+	xlsxPath := filepath.Join(t.TempDir(), "empty.xlsx")
+	f := xlsx.NewFile()
+	if _, err := f.AddSheet("timepoints"); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Save(xlsxPath); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, p := range []string{xlsxPath, writeFile(t, "empty.csv", "")} {
+		if _, err := LoadSchedule(discardLog, p); err == nil {
+			t.Errorf("loading %s didn't fail", filepath.Base(p))
+		}
+		if _, err := ReadIndices(discardLog, p); err == nil {
+			t.Errorf("reading the headers of %s didn't fail", filepath.Base(p))
+		}
+	}
+}
+
+func TestScheduleIndex(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := &Schedule{}
+	for i := 0; i < 3; i++ {
+		s.TimePoints = append(s.TimePoints, TimePoint{Datetime: start.Add(time.Duration(i) * time.Hour)})
+	}
+	tests := []struct {
+		t    time.Time
+		want int
+	}{
+		{start.Add(-time.Minute), -1},
+		{start, 0},
+		{start.Add(time.Minute * 90), 1},
+		{start.Add(time.Hour * 2), 2},
+		{start.Add(time.Hour * 48), 2},
+	}
+	for _, test := range tests {
+		if got := s.Index(test.t); got != test.want {
+			t.Errorf("Index(%v) = %d, want %d", test.t, got, test.want)
+		}
+	}
+}
+
+func TestRunConditionsReturnsError(t *testing.T) {
+	runStuff := func(point *TimePoint) bool { return true }
+	if err := RunConditions(discardLog, runStuff, filepath.Join(t.TempDir(), "missing.csv"), false); err == nil {
+		t.Error("running a missing conditions file didn't fail")
+	}
+}
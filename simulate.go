@@ -0,0 +1,64 @@
+package chamber_tools
+
+import (
+	"github.com/pkg/errors"
+	"log"
+	"sort"
+	"time"
+)
+
+// Simulation maps the SimDatetime of timepoints onto real time, so that historical or simulated climates can be
+// replayed without rewriting the datetime column. SimStart runs at RealStart, and every other timepoint runs at the
+// same offset from it as in the simulation.
+type Simulation struct {
+	// SimStart is the simulated time that runs at RealStart, the earliest SimDatetime is used if it is the zero time
+	SimStart time.Time
+	// RealStart is the real time SimStart runs at, the time the runner starts is used if it is the zero time
+	RealStart time.Time
+}
+
+// Offset returns how far real time is ahead of simulated time for a schedule, starting at now if RealStart is zero
+func (sim Simulation) Offset(s *Schedule, now time.Time) (time.Duration, error) {
+	simStart := sim.SimStart
+	if simStart.IsZero() {
+		for _, tp := range s.TimePoints {
+			if !tp.SimDatetime.IsZero() && (simStart.IsZero() || tp.SimDatetime.Before(simStart)) {
+				simStart = tp.SimDatetime
+			}
+		}
+		if simStart.IsZero() {
+			return 0, errors.New("no timepoints have a datetime-sim to simulate")
+		}
+	}
+	realStart := sim.RealStart
+	if realStart.IsZero() {
+		realStart = now
+	}
+	return realStart.Sub(simStart), nil
+}
+
+// Simulate returns a copy of the schedule ordered by SimDatetime, with the Datetime of each timepoint set to the
+// real time its SimDatetime runs at. runStuff is called with the real time in Datetime and the simulated time in
+// SimDatetime. timepoints without a SimDatetime are logged to errLog and skipped.
+func (s *Schedule) Simulate(errLog *log.Logger, sim Simulation, now time.Time) (*Schedule, error) {
+	offset, err := sim.Offset(s, now)
+	if err != nil {
+		return nil, err
+	}
+	simulated := *s
+	simulated.TimePoints = make([]TimePoint, 0, s.Len())
+	for i, tp := range s.TimePoints {
+		if tp.SimDatetime.IsZero() {
+			errLog.Printf("skipping TimePoint %05d at %v, it has no datetime-sim", i, tp.Datetime)
+			continue
+		}
+		tp.Datetime = tp.SimDatetime.Add(offset)
+		simulated.TimePoints = append(simulated.TimePoints, tp)
+	}
+	sort.SliceStable(simulated.TimePoints, func(i, j int) bool {
+		return simulated.TimePoints[i].Datetime.Before(simulated.TimePoints[j].Datetime)
+	})
+	errLog.Printf("simulating %d timepoints from %v at %v", simulated.Len(),
+		simulated.Start().Add(-offset), simulated.Start())
+	return &simulated, nil
+}
@@ -0,0 +1,97 @@
+package chamber_tools
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// simSchedule returns testSchedule with the SimDatetime of each timepoint on a day in 1990, with the first two
+// swapped and the last one missing
+func simSchedule() *Schedule {
+	s := testSchedule()
+	simStart := time.Date(1990, 7, 1, 0, 0, 0, 0, time.UTC)
+	for i := range s.TimePoints {
+		s.TimePoints[i].SimDatetime = simStart.Add(s.TimePoints[i].Datetime.Sub(testStart))
+	}
+	s.TimePoints[0].SimDatetime, s.TimePoints[1].SimDatetime = s.TimePoints[1].SimDatetime, s.TimePoints[0].SimDatetime
+	s.TimePoints[7].SimDatetime = time.Time{}
+	return s
+}
+
+func TestSimulationOffset(t *testing.T) {
+	s := simSchedule()
+	simStart := time.Date(1990, 7, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		sim  Simulation
+		want time.Duration
+	}{
+		{"defaults to the earliest datetime-sim and now", Simulation{}, at(5).Sub(simStart)},
+		{"real start", Simulation{RealStart: at(8)}, at(8).Sub(simStart)},
+		{"sim start", Simulation{SimStart: simStart.Add(time.Hour), RealStart: at(8)}, at(7).Sub(simStart)},
+	}
+	for _, test := range tests {
+		offset, err := test.sim.Offset(s, at(5))
+		if err != nil || offset != test.want {
+			t.Errorf("%s: Offset = %v, %v, want %v", test.name, offset, err, test.want)
+		}
+	}
+	if _, err := (Simulation{}).Offset(testSchedule(), at(5)); err == nil {
+		t.Error("found an offset for a schedule without datetime-sim")
+	}
+}
+
+func TestSimulate(t *testing.T) {
+	s := simSchedule()
+	simulated, err := s.Simulate(discardLog, Simulation{RealStart: at(100)}, at(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if simulated.Len() != 7 {
+		t.Fatalf("simulated %d timepoints, want the 7 with a datetime-sim", simulated.Len())
+	}
+	for i, tp := range simulated.TimePoints {
+		want := at(100 + float64(i*3))
+		if !tp.Datetime.Equal(want) {
+			t.Errorf("TimePoint %d runs at %v, want %v", i, tp.Datetime, want)
+		}
+		if simStart := time.Date(1990, 7, 1, i*3, 0, 0, 0, time.UTC); !tp.SimDatetime.Equal(simStart) {
+			t.Errorf("TimePoint %d is simulated at %v, want %v", i, tp.SimDatetime, simStart)
+		}
+	}
+	if simulated.TimePoints[0].Temperature != NewNullFloat64(1) {
+		t.Errorf("first simulated TimePoint is %s, want the one with the earliest datetime-sim",
+			simulated.TimePoints[0].NulledString())
+	}
+	if !s.TimePoints[0].Datetime.Equal(at(0)) || s.Len() != 8 {
+		t.Error("Simulate changed the schedule it was called on")
+	}
+}
+
+// TestRunSimulation runs a simulation on a FakeClock, it starts when the runner starts and ends at the last
+// timepoint with a datetime-sim
+func TestRunSimulation(t *testing.T) {
+	clock := NewFakeClock(at(50))
+	var simulated []time.Time
+	rec := &recorder{clock: clock}
+	run := func(ctx context.Context, point *TimePoint) error {
+		simulated = append(simulated, point.SimDatetime)
+		return rec.run(ctx, point)
+	}
+	err := drive(clock, func() error {
+		return simSchedule().RunWith(context.Background(), discardLog, run, RunOptions{
+			Clock:      clock,
+			Simulation: &Simulation{},
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertRuns(t, rec.runs(), []ran{{1, at(50)}, {0, at(53)}, {2, at(56)}, {3, at(59)}, {4, at(62)}, {5, at(65)},
+		{6, at(68)}})
+	simStart := time.Date(1990, 7, 1, 0, 0, 0, 0, time.UTC)
+	if len(simulated) == 0 || !simulated[0].Equal(simStart) {
+		t.Errorf("runStuff was called with datetime-sim %v, want the simulated time", simulated)
+	}
+}
@@ -0,0 +1,224 @@
+package chamber_tools
+
+import (
+	"encoding/json"
+	"github.com/appf-anu/chamber-tools/fixture"
+	"github.com/appf-anu/chamber-tools/internal/config"
+	"math"
+	"time"
+)
+
+// Calibration is the photosynthetic photon flux density a light fixture delivers at the canopy for each unit of its
+// light columns, measured with a quantum sensor.
+type Calibration struct {
+	Name string `json:"name" yaml:"name"`
+	// Channels is the PPFD in µmol/m²/s for each unit of each channel, eg. at 1%
+	Channels []float64 `json:"channels" yaml:"channels"`
+	// Light1 and Light2 are the PPFD in µmol/m²/s for each level of the chamber lights
+	Light1 float64 `json:"light1" yaml:"light1"`
+	Light2 float64 `json:"light2" yaml:"light2"`
+}
+
+// PPFD returns the photosynthetic photon flux density in µmol/m²/s of the light targets of a timepoint.
+// unset targets and channels without a calibration don't add anything.
+func (c Calibration) PPFD(tp TimePoint) float64 {
+	ppfd := float64(tp.Light1.ValueOr(0))*c.Light1 + float64(tp.Light2.ValueOr(0))*c.Light2
+	for i, v := range tp.Channels {
+		if i < len(c.Channels) {
+			ppfd += v.ValueOr(0) * c.Channels[i]
+		}
+	}
+	return ppfd
+}
+
+// ProfileCalibration returns the calibration of the channels of a fixture profile
+func ProfileCalibration(p fixture.Profile) Calibration {
+	c := Calibration{Name: p.Name, Channels: make([]float64, len(p.Channels))}
+	for i, channel := range p.Channels {
+		c.Channels[i] = channel.MaxPPFD / p.FullOutput()
+	}
+	return c
+}
+
+// LoadCalibrations reads calibrations keyed by name from a .json or .yaml file.
+// calibrations without a name are named after their key.
+func LoadCalibrations(configPath string) (map[string]Calibration, error) {
+	calibrations := make(map[string]Calibration)
+	if err := config.Decode(configPath, "calibrations", &calibrations); err != nil {
+		return nil, err
+	}
+	for name, c := range calibrations {
+		if c.Name == "" {
+			c.Name = name
+			calibrations[name] = c
+		}
+	}
+	return calibrations, nil
+}
+
+// lightsOn returns true if any light target of a timepoint is above 0
+func lightsOn(tp TimePoint) bool {
+	if tp.Light1.ValueOr(0) > 0 || tp.Light2.ValueOr(0) > 0 {
+		return true
+	}
+	for _, v := range tp.Channels {
+		if v.ValueOr(0) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Stats are the time weighted statistics of a target over a day. day is while the lights are on.
+type Stats struct {
+	Mean      NullFloat64 `json:"mean"`
+	Min       NullFloat64 `json:"min"`
+	Max       NullFloat64 `json:"max"`
+	DayMean   NullFloat64 `json:"day_mean"`
+	NightMean NullFloat64 `json:"night_mean"`
+}
+
+// statsAccumulator sums a target weighted by how long it is held for
+type statsAccumulator struct {
+	sum, seconds           float64
+	daySum, daySeconds     float64
+	nightSum, nightSeconds float64
+	min, max               NullFloat64
+}
+
+func (a *statsAccumulator) add(v NullFloat64, seconds float64, day bool) {
+	if !v.Valid {
+		return
+	}
+	a.sum += v.Float64 * seconds
+	a.seconds += seconds
+	if day {
+		a.daySum += v.Float64 * seconds
+		a.daySeconds += seconds
+	} else {
+		a.nightSum += v.Float64 * seconds
+		a.nightSeconds += seconds
+	}
+	if !a.min.Valid || v.Float64 < a.min.Float64 {
+		a.min = v
+	}
+	if !a.max.Valid || v.Float64 > a.max.Float64 {
+		a.max = v
+	}
+}
+
+func (a *statsAccumulator) stats() Stats {
+	mean := func(sum, seconds float64) NullFloat64 {
+		if seconds == 0 {
+			return NullFloat64{}
+		}
+		return NewNullFloat64(sum / seconds)
+	}
+	return Stats{
+		Mean:      mean(a.sum, a.seconds),
+		Min:       a.min,
+		Max:       a.max,
+		DayMean:   mean(a.daySum, a.daySeconds),
+		NightMean: mean(a.nightSum, a.nightSeconds),
+	}
+}
+
+// DaySummary is what a schedule delivers over a calendar day
+type DaySummary struct {
+	// Date is midnight at the start of the day in the schedule's Location
+	Date time.Time `json:"date"`
+	// Duration is how much of the day the schedule covers
+	Duration time.Duration `json:"-"`
+	// Photoperiod is how long the lights are on for
+	Photoperiod time.Duration `json:"-"`
+	// DLI is the daily light integral in mol/m²/day, it is unset without a Calibration
+	DLI              NullFloat64 `json:"dli"`
+	Temperature      Stats       `json:"temperature"`
+	RelativeHumidity Stats       `json:"humidity"`
+}
+
+// MarshalJSON implements json.Marshaler, writing the date as YYYY-MM-DD and durations in hours
+func (d DaySummary) MarshalJSON() ([]byte, error) {
+	type summary DaySummary
+	return json.Marshal(struct {
+		summary
+		Date             string  `json:"date"`
+		DurationHours    float64 `json:"duration_hours"`
+		PhotoperiodHours float64 `json:"photoperiod_hours"`
+	}{
+		summary:          summary(d),
+		Date:             d.Date.Format("2006-01-02"),
+		DurationHours:    d.Duration.Hours(),
+		PhotoperiodHours: d.Photoperiod.Hours(),
+	})
+}
+
+// Summary summarises a schedule by calendar day. each timepoint is held until the next one, like the runners do without
+// RunOptions.Tick, and the last timepoint is held for as long as the one before it. the DLI is only computed if
+// calibration is not nil.
+func (s *Schedule) Summary(calibration *Calibration) []DaySummary {
+	if s.Len() == 0 {
+		return nil
+	}
+	loc := s.location()
+	midnight := func(t time.Time) time.Time {
+		t = t.In(loc)
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+	}
+
+	type day struct {
+		summary               DaySummary
+		dli                   float64
+		temperature, humidity statsAccumulator
+	}
+	var days []*day
+	current := func(t time.Time) *day {
+		date := midnight(t)
+		if len(days) == 0 || !days[len(days)-1].summary.Date.Equal(date) {
+			days = append(days, &day{summary: DaySummary{Date: date}})
+		}
+		return days[len(days)-1]
+	}
+
+	for i, tp := range s.TimePoints {
+		end := tp.Datetime
+		switch {
+		case i+1 < s.Len():
+			end = s.TimePoints[i+1].Datetime
+		case i > 0:
+			end = tp.Datetime.Add(tp.Datetime.Sub(s.TimePoints[i-1].Datetime))
+		}
+		on := lightsOn(tp)
+		// split the time the timepoint is held for at midnight
+		for start := tp.Datetime; start.Before(end); {
+			segmentEnd := midnight(start).AddDate(0, 0, 1)
+			if end.Before(segmentEnd) {
+				segmentEnd = end
+			}
+			d := current(start)
+			held := segmentEnd.Sub(start)
+			seconds := held.Seconds()
+			d.summary.Duration += held
+			if on {
+				d.summary.Photoperiod += held
+			}
+			if calibration != nil {
+				d.dli += calibration.PPFD(tp) * seconds / 1e6
+			}
+			d.temperature.add(tp.Temperature, seconds, on)
+			d.humidity.add(tp.RelativeHumidity, seconds, on)
+			start = segmentEnd
+		}
+	}
+
+	summaries := make([]DaySummary, len(days))
+	for i, d := range days {
+		d.summary.Temperature = d.temperature.stats()
+		d.summary.RelativeHumidity = d.humidity.stats()
+		if calibration != nil {
+			d.summary.DLI = NewNullFloat64(math.Round(d.dli*1000) / 1000)
+		}
+		summaries[i] = d.summary
+	}
+	return summaries
+}
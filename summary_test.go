@@ -0,0 +1,128 @@
+package chamber_tools
+
+import (
+	"encoding/json"
+	"github.com/appf-anu/chamber-tools/fixture"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// summarySchedule has the lights on at 50 from 06:00 to 18:00 on the first day, and its last timepoint at midnight is
+// held for 6 hours into the second day
+func summarySchedule() *Schedule {
+	tp := func(hours, temperature, humidity, light float64) TimePoint {
+		return TimePoint{
+			Datetime:         at(hours),
+			Temperature:      NewNullFloat64(temperature),
+			RelativeHumidity: NewNullFloat64(humidity),
+			Channels:         []NullFloat64{NewNullFloat64(light)},
+		}
+	}
+	return &Schedule{
+		Indices:  NewIndices(),
+		Location: time.UTC,
+		TimePoints: []TimePoint{
+			tp(0, 18, 70, 0),
+			tp(6, 24, 60, 50),
+			tp(18, 18, 70, 0),
+			tp(24, 16, 80, 0),
+		},
+	}
+}
+
+func TestSummary(t *testing.T) {
+	s := summarySchedule()
+	days := s.Summary(nil)
+	if len(days) != 2 {
+		t.Fatalf("%d days, want 2", len(days))
+	}
+	first, second := days[0], days[1]
+	if !first.Date.Equal(at(0)) || first.Duration != 24*time.Hour || first.Photoperiod != 12*time.Hour {
+		t.Errorf("first day is %v for %v with the lights on for %v, want 24h with 12h of light",
+			first.Date, first.Duration, first.Photoperiod)
+	}
+	wantTemperature := Stats{
+		Mean:      NewNullFloat64(21),
+		Min:       NewNullFloat64(18),
+		Max:       NewNullFloat64(24),
+		DayMean:   NewNullFloat64(24),
+		NightMean: NewNullFloat64(18),
+	}
+	if first.Temperature != wantTemperature || first.RelativeHumidity.Mean != NewNullFloat64(65) {
+		t.Errorf("first day temperature %+v and humidity %+v", first.Temperature, first.RelativeHumidity)
+	}
+	if first.DLI.Valid {
+		t.Errorf("DLI is %v without a calibration, want it unset", first.DLI)
+	}
+	// the last timepoint is held for as long as the one before it
+	if !second.Date.Equal(at(24)) || second.Duration != 6*time.Hour || second.Photoperiod != 0 ||
+		second.Temperature.DayMean.Valid || second.Temperature.NightMean != NewNullFloat64(16) {
+		t.Errorf("second day is %+v, want 6 hours of night at 16", second)
+	}
+}
+
+func TestSummaryDLI(t *testing.T) {
+	// 50% of a channel that delivers 400 µmol/m²/s at full output for 12 hours is 200 * 43200 / 1e6 mol/m²
+	channels := []fixture.Channel{{Name: "red", MaxPPFD: 400}}
+	profile := fixture.Profile{Name: "led", Unit: fixture.Percent, Channels: channels}
+	calibrations := map[string]Calibration{
+		"calibration": {Name: "calibration", Channels: []float64{4}},
+		"profile":     ProfileCalibration(profile),
+	}
+	for name, c := range calibrations {
+		c := c
+		days := summarySchedule().Summary(&c)
+		if len(days) != 2 || days[0].DLI != NewNullFloat64(8.64) || days[1].DLI != NewNullFloat64(0) {
+			t.Errorf("%s: summarised %+v, want a DLI of 8.64 then 0", name, days)
+		}
+	}
+
+	c := Calibration{Light1: 10, Light2: 20, Channels: []float64{1}}
+	tp := TimePoint{Light1: NewNullInt(2), Channels: []NullFloat64{NewNullFloat64(5), NewNullFloat64(100)}}
+	if ppfd := c.PPFD(tp); ppfd != 25 {
+		t.Errorf("PPFD = %v, want 25 from light1 and the calibrated channel", ppfd)
+	}
+}
+
+func TestDaySummaryMarshalJSON(t *testing.T) {
+	days := summarySchedule().Summary(&Calibration{Channels: []float64{4}})
+	data, err := json.Marshal(days[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]interface{}
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"date":              "2020-01-01",
+		"duration_hours":    24.0,
+		"photoperiod_hours": 12.0,
+		"dli":               8.64,
+		"temperature": map[string]interface{}{
+			"mean": 21.0, "min": 18.0, "max": 24.0, "day_mean": 24.0, "night_mean": 18.0,
+		},
+		"humidity": map[string]interface{}{
+			"mean": 65.0, "min": 60.0, "max": 70.0, "day_mean": 60.0, "night_mean": 70.0,
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("marshalled %s, want %v", data, want)
+	}
+
+	days = summarySchedule().Summary(nil)
+	data, err = json.Marshal(days[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = nil
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got["dli"] != nil || got["temperature"].(map[string]interface{})["day_mean"] != nil ||
+		math.Abs(got["duration_hours"].(float64)-6) > 1e-9 {
+		t.Errorf("marshalled %s, want null for the DLI and the day mean", data)
+	}
+}
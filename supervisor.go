@@ -0,0 +1,437 @@
+package chamber_tools
+
+import (
+	"context"
+	"fmt"
+	"github.com/appf-anu/chamber-tools/fixture"
+	"github.com/appf-anu/chamber-tools/internal/config"
+	"github.com/pkg/errors"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ChamberConfig is a chamber in a supervisor manifest
+type ChamberConfig struct {
+	Name string `json:"name" yaml:"name"`
+	// Conditions is the conditions file the chamber runs, relative to the manifest
+	Conditions string `json:"conditions" yaml:"conditions"`
+	// Driver is the name of the DriverFactory that connects to the chamber
+	Driver string `json:"driver" yaml:"driver"`
+	// Options are passed to the DriverFactory, eg. the address of the chamber
+	Options map[string]string `json:"options,omitempty" yaml:"options,omitempty"`
+	// Loop loops over the first LoopPeriodHours of the conditions forever instead of running them once
+	Loop            bool    `json:"loop" yaml:"loop"`
+	LoopPeriodHours float64 `json:"loop_period_hours,omitempty" yaml:"loop_period_hours,omitempty"`
+	// TickMinutes, if it is not 0, runs interpolated timepoints every TickMinutes between the rows of the conditions
+	TickMinutes float64 `json:"tick_minutes,omitempty" yaml:"tick_minutes,omitempty"`
+	// Backoff retries failed timepoints with DefaultRetryPolicy instead of straight away
+	Backoff bool `json:"backoff,omitempty" yaml:"backoff,omitempty"`
+	// Reload swaps in changes to the conditions file while it runs, see Reload
+	Reload bool `json:"reload,omitempty" yaml:"reload,omitempty"`
+	// Fixture is the name of the profile in SupervisorOptions.Fixtures that spectrum columns are solved for
+	Fixture string `json:"fixture,omitempty" yaml:"fixture,omitempty"`
+	// Limits is the name of the profile in SupervisorOptions.Limits that the conditions must be within to load
+	Limits string `json:"limits,omitempty" yaml:"limits,omitempty"`
+}
+
+// Validate returns an error if the chamber can't be run
+func (c ChamberConfig) Validate() error {
+	if c.Conditions == "" {
+		return errors.Errorf("chamber %s has no conditions file", c.Name)
+	}
+	if c.Driver == "" {
+		return errors.Errorf("chamber %s has no driver", c.Name)
+	}
+	if c.LoopPeriodHours < 0 || c.TickMinutes < 0 {
+		return errors.Errorf("chamber %s has a negative loop period or tick", c.Name)
+	}
+	return nil
+}
+
+// RunOptions returns the options the chamber's schedule is run with
+func (c ChamberConfig) RunOptions() RunOptions {
+	opts := RunOptions{
+		LoopFirstDay: c.Loop,
+		LoopPeriod:   time.Duration(c.LoopPeriodHours * float64(time.Hour)).Round(time.Second),
+		Tick:         time.Duration(c.TickMinutes * float64(time.Minute)).Round(time.Second),
+	}
+	if c.Backoff {
+		opts.Retry = DefaultRetryPolicy()
+	}
+	if c.Reload {
+		opts.Reload = &Reload{}
+	}
+	return opts
+}
+
+// LoadManifest reads the chambers of a supervisor manifest keyed by name from a .json or .yaml file. relative
+// conditions paths are resolved from the directory of the manifest.
+func LoadManifest(manifestPath string) (map[string]ChamberConfig, error) {
+	chambers := make(map[string]ChamberConfig)
+	if err := config.Decode(manifestPath, "chambers", &chambers); err != nil {
+		return nil, err
+	}
+	for name, c := range chambers {
+		if c.Name == "" {
+			c.Name = name
+		}
+		if c.Conditions != "" && !filepath.IsAbs(c.Conditions) {
+			c.Conditions = filepath.Join(filepath.Dir(manifestPath), c.Conditions)
+		}
+		if err := c.Validate(); err != nil {
+			return nil, errors.Wrapf(err, "reading chambers from %s", manifestPath)
+		}
+		chambers[name] = c
+	}
+	return chambers, nil
+}
+
+// DriverFactory connects to the chamber of a manifest entry. it is called every time the chamber's runner starts, with
+// the schedule it is about to run so that the driver can use its column layout.
+type DriverFactory func(errLog *log.Logger, chamber ChamberConfig, schedule *Schedule) (Driver, error)
+
+// ChamberState is what the runner of a chamber is doing
+type ChamberState string
+
+const (
+	// ChamberStarting is loading the conditions and connecting the driver
+	ChamberStarting ChamberState = "starting"
+	// ChamberRunning is running the conditions
+	ChamberRunning ChamberState = "running"
+	// ChamberRestarting is waiting to restart after the runner failed
+	ChamberRestarting ChamberState = "restarting"
+	// ChamberFinished ran all of its conditions
+	ChamberFinished ChamberState = "finished"
+	// ChamberFailed failed more than SupervisorOptions.MaxRestarts times in a row and won't be restarted
+	ChamberFailed ChamberState = "failed"
+	// ChamberStopped was stopped by the supervisor's context
+	ChamberStopped ChamberState = "stopped"
+)
+
+// ChamberStatus is the status of one chamber of a supervisor
+type ChamberStatus struct {
+	Name       string       `json:"name"`
+	Conditions string       `json:"conditions"`
+	State      ChamberState `json:"state"`
+	// Started is when the runner last started
+	Started time.Time `json:"started"`
+	// Restarts is the number of times the runner has been restarted
+	Restarts int `json:"restarts"`
+	// Error is why the runner last failed
+	Error string `json:"error,omitempty"`
+	// LastTimePoint is the Datetime of the last timepoint that was applied, and LastApplied when it was applied
+	LastTimePoint time.Time `json:"last_timepoint"`
+	LastApplied   time.Time `json:"last_applied"`
+	// Abandoned is the number of timepoints that were abandoned after they couldn't be applied
+	Abandoned int `json:"abandoned"`
+}
+
+func (s ChamberStatus) String() string {
+	status := fmt.Sprintf("%s: %s", s.Name, s.State)
+	if !s.LastTimePoint.IsZero() {
+		status += fmt.Sprintf(", last TimePoint %v applied at %v", s.LastTimePoint, s.LastApplied.Format(time.RFC3339))
+	}
+	if s.Restarts > 0 {
+		status += fmt.Sprintf(", %d restarts", s.Restarts)
+	}
+	if s.Abandoned > 0 {
+		status += fmt.Sprintf(", %d abandoned", s.Abandoned)
+	}
+	if s.Error != "" {
+		status += fmt.Sprintf(", last error: %s", s.Error)
+	}
+	return status
+}
+
+// SupervisorOptions control how a supervisor runs its chambers
+type SupervisorOptions struct {
+	// Clock is used for all timekeeping, RealClock is used if it is nil
+	Clock Clock
+	// RestartDelay is the wait before a failed runner is restarted, doubled for each failure in a row up to
+	// MaxRestartDelay. 10 seconds is used if it is 0.
+	RestartDelay time.Duration
+	// MaxRestartDelay caps the wait before a restart, 10 minutes is used if it is 0
+	MaxRestartDelay time.Duration
+	// MaxRestarts is the number of failures in a row after which a chamber is given up on, it is restarted forever if
+	// it is 0. a runner that applied a timepoint before it failed starts the count again.
+	MaxRestarts int
+	// ReadInterval, if it is not 0, reads every driver every ReadInterval
+	ReadInterval time.Duration
+	// OnReading is called with every reading and the name of the chamber it came from
+	OnReading func(chamber string, r Reading)
+	// Fixtures are the fixture profiles that chambers name, eg. from fixture.LoadProfiles
+	Fixtures map[string]fixture.Profile
+	// Limits are the limit profiles that chambers name, DefaultLimitProfiles is used if it is nil
+	Limits map[string]LimitProfile
+}
+
+// loadOptions returns the options the conditions of a chamber are loaded with, returning an error if the chamber
+// names a fixture or limit profile that opts doesn't have
+func (opts SupervisorOptions) loadOptions(c ChamberConfig) (LoadOptions, error) {
+	var load LoadOptions
+	if c.Fixture != "" {
+		profile, ok := opts.Fixtures[c.Fixture]
+		if !ok {
+			return load, errors.Errorf("chamber %s has unknown fixture profile %q", c.Name, c.Fixture)
+		}
+		load.Fixture = &profile
+	}
+	if c.Limits != "" {
+		limits := opts.Limits
+		if limits == nil {
+			limits = DefaultLimitProfiles()
+		}
+		profile, ok := limits[c.Limits]
+		if !ok {
+			return load, errors.Errorf("chamber %s has unknown limit profile %q", c.Name, c.Limits)
+		}
+		load.Limits = &profile
+	}
+	return load, nil
+}
+
+func (opts SupervisorOptions) restartDelay(failures int) time.Duration {
+	delay, maxDelay := opts.RestartDelay, opts.MaxRestartDelay
+	if delay <= 0 {
+		delay = time.Second * 10
+	}
+	if maxDelay <= 0 {
+		maxDelay = time.Minute * 10
+	}
+	for i := 1; i < failures && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxDelay {
+		return maxDelay
+	}
+	return delay
+}
+
+// Supervisor runs the schedules of many chambers in one process. every chamber has its own runner, schedule and
+// column layout, and failed runners are restarted.
+type Supervisor struct {
+	errLog   *log.Logger
+	chambers []ChamberConfig
+	drivers  map[string]DriverFactory
+	opts     SupervisorOptions
+
+	// load are the options the conditions of each chamber are loaded with
+	load map[string]LoadOptions
+
+	mu     sync.Mutex
+	status map[string]*ChamberStatus
+}
+
+// NewSupervisor returns a supervisor for the chambers of a manifest, connecting to them with the factory named by
+// their driver. returns an error if a chamber's driver isn't in drivers, or its fixture or limit profile isn't in opts.
+func NewSupervisor(errLog *log.Logger, chambers map[string]ChamberConfig, drivers map[string]DriverFactory,
+	opts SupervisorOptions) (*Supervisor, error) {
+
+	s := &Supervisor{
+		errLog:  errLog,
+		drivers: drivers,
+		opts:    opts,
+		load:    make(map[string]LoadOptions),
+		status:  make(map[string]*ChamberStatus),
+	}
+	for name, c := range chambers {
+		if c.Name == "" {
+			c.Name = name
+		}
+		if err := c.Validate(); err != nil {
+			return nil, err
+		}
+		if _, ok := drivers[c.Driver]; !ok {
+			return nil, errors.Errorf("chamber %s has unknown driver %q", c.Name, c.Driver)
+		}
+		load, err := opts.loadOptions(c)
+		if err != nil {
+			return nil, err
+		}
+		s.load[c.Name] = load
+		s.chambers = append(s.chambers, c)
+		s.status[c.Name] = &ChamberStatus{Name: c.Name, Conditions: c.Conditions, State: ChamberStarting}
+	}
+	sort.Slice(s.chambers, func(i, j int) bool {
+		return s.chambers[i].Name < s.chambers[j].Name
+	})
+	return s, nil
+}
+
+func (s *Supervisor) clock() Clock {
+	if s.opts.Clock == nil {
+		return RealClock
+	}
+	return s.opts.Clock
+}
+
+// Status returns the status of every chamber, ordered by name
+func (s *Supervisor) Status() []ChamberStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	statuses := make([]ChamberStatus, 0, len(s.chambers))
+	for _, c := range s.chambers {
+		statuses = append(statuses, *s.status[c.Name])
+	}
+	return statuses
+}
+
+// update changes the status of a chamber
+func (s *Supervisor) update(name string, fn func(status *ChamberStatus)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fn(s.status[name])
+}
+
+// Run runs every chamber until they have all finished or failed, or ctx is done. returns an error naming the chambers
+// that failed.
+func (s *Supervisor) Run(ctx context.Context) error {
+	var wg sync.WaitGroup
+	for _, c := range s.chambers {
+		wg.Add(1)
+		go func(c ChamberConfig) {
+			defer wg.Done()
+			s.supervise(ctx, c)
+		}(c)
+	}
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		return errors.Wrap(context.Cause(ctx), "supervisor stopped")
+	}
+	var failed []string
+	for _, status := range s.Status() {
+		if status.State == ChamberFailed {
+			failed = append(failed, fmt.Sprintf("%s: %s", status.Name, status.Error))
+		}
+	}
+	if len(failed) > 0 {
+		return errors.Errorf("%d of %d chambers failed: %s", len(failed), len(s.chambers), strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+// supervise runs a chamber, restarting it whenever it fails, until it finishes, is given up on or ctx is done
+func (s *Supervisor) supervise(ctx context.Context, c ChamberConfig) {
+	errLog := targetLog(s.errLog, c.Name)
+	clock := s.clock()
+	failures := 0
+	for {
+		s.update(c.Name, func(status *ChamberStatus) {
+			status.State = ChamberStarting
+			status.Started = clock.Now()
+		})
+		applied, err := s.runOnce(ctx, errLog, c)
+		if ctx.Err() != nil {
+			errLog.Printf("stopped: %v", context.Cause(ctx))
+			s.update(c.Name, func(status *ChamberStatus) {
+				status.State = ChamberStopped
+			})
+			return
+		}
+		if err == nil {
+			errLog.Println("finished running conditions")
+			s.update(c.Name, func(status *ChamberStatus) {
+				status.State = ChamberFinished
+			})
+			return
+		}
+
+		if applied {
+			failures = 0
+		}
+		failures++
+		errLog.Printf("runner failed: %v", err)
+		if s.opts.MaxRestarts > 0 && failures > s.opts.MaxRestarts {
+			errLog.Printf("giving up after %d failures in a row", failures)
+			s.update(c.Name, func(status *ChamberStatus) {
+				status.State = ChamberFailed
+				status.Error = err.Error()
+			})
+			return
+		}
+		delay := s.opts.restartDelay(failures)
+		errLog.Printf("restarting in %v", delay)
+		s.update(c.Name, func(status *ChamberStatus) {
+			status.State = ChamberRestarting
+			status.Error = err.Error()
+		})
+		if err := sleep(ctx, clock, delay); err != nil {
+			s.update(c.Name, func(status *ChamberStatus) {
+				status.State = ChamberStopped
+			})
+			return
+		}
+		s.update(c.Name, func(status *ChamberStatus) {
+			status.Restarts++
+		})
+	}
+}
+
+// statusDriver records the timepoints its driver applies in the status of a chamber
+type statusDriver struct {
+	Driver
+	supervisor *Supervisor
+	name       string
+	applied    bool
+}
+
+func (d *statusDriver) Apply(ctx context.Context, tp TimePoint) error {
+	if err := d.Driver.Apply(ctx, tp); err != nil {
+		return err
+	}
+	d.applied = true
+	now := d.supervisor.clock().Now()
+	d.supervisor.update(d.name, func(status *ChamberStatus) {
+		status.LastTimePoint = tp.Datetime
+		status.LastApplied = now
+	})
+	return nil
+}
+
+// runOnce loads the chamber's conditions and runs them on a new driver, returning whether any timepoint was applied.
+// a panic applying a timepoint is returned as an error so that the chamber is restarted, RunDriver does the same for
+// panics reading the driver.
+func (s *Supervisor) runOnce(ctx context.Context, errLog *log.Logger, c ChamberConfig) (applied bool, err error) {
+	driver := &statusDriver{supervisor: s, name: c.Name}
+	defer func() {
+		if r := recover(); r != nil {
+			applied, err = driver.applied, errors.Errorf("panic: %v", r)
+		}
+	}()
+
+	schedule, err := LoadScheduleWithOptions(errLog, c.Conditions, s.load[c.Name])
+	if err != nil {
+		return false, err
+	}
+	driver.Driver, err = s.drivers[c.Driver](errLog, c, schedule)
+	if err != nil {
+		return false, errors.Wrapf(err, "couldn't connect %s driver", c.Driver)
+	}
+
+	opts := DriverOptions{
+		RunOptions:   c.RunOptions(),
+		ReadInterval: s.opts.ReadInterval,
+	}
+	opts.Clock = s.opts.Clock
+	opts.Load = s.load[c.Name]
+	opts.OnAbandon = func(err *AbandonError) {
+		s.update(c.Name, func(status *ChamberStatus) {
+			status.Abandoned++
+		})
+	}
+	if s.opts.OnReading != nil {
+		opts.OnReading = func(r Reading) {
+			s.opts.OnReading(c.Name, r)
+		}
+	}
+	s.update(c.Name, func(status *ChamberStatus) {
+		status.State = ChamberRunning
+	})
+	err = schedule.RunDriver(ctx, errLog, driver, opts)
+	return driver.applied, err
+}
@@ -0,0 +1,314 @@
+package chamber_tools
+
+import (
+	"context"
+	"github.com/appf-anu/chamber-tools/fixture"
+	"github.com/pkg/errors"
+	"log"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testConditions is testSchedule as a conditions file
+const testConditions = `datetime,temperature
+2020-01-01 00:00,0
+2020-01-01 03:00,1
+2020-01-01 06:00,2
+2020-01-01 09:00,3
+2020-01-01 12:00,4
+2020-01-01 15:00,5
+2020-01-01 18:00,6
+2020-01-01 21:00,7
+`
+
+// panicDriver is a fakeDriver that panics when it is read. timepoints aren't applied until it has been read, so that
+// the run can't end before the read.
+type panicDriver struct {
+	*fakeDriver
+	read chan struct{}
+}
+
+func (d panicDriver) Apply(ctx context.Context, tp TimePoint) error {
+	select {
+	case <-d.read:
+	case <-ctx.Done():
+		return context.Cause(ctx)
+	}
+	return d.fakeDriver.Apply(ctx, tp)
+}
+
+func (d panicDriver) Read(ctx context.Context) (Reading, error) {
+	close(d.read)
+	panic("lost the serial port")
+}
+
+func TestLoadManifest(t *testing.T) {
+	manifestPath := writeFile(t, "chambers.yaml", `
+gc01:
+  conditions: gc01.csv
+  driver: conviron
+  loop: true
+  tick_minutes: 5
+gc02:
+  name: growth-chamber-2
+  conditions: /srv/conditions/gc02.xlsx
+  driver: psi
+  backoff: true
+  fixture: led
+  limits: conviron
+`)
+	chambers, err := LoadManifest(manifestPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gc01, gc02 := chambers["gc01"], chambers["gc02"]
+	if gc01.Name != "gc01" || gc01.Conditions != filepath.Join(filepath.Dir(manifestPath), "gc01.csv") {
+		t.Errorf("gc01 is %+v, want its name from its key and its conditions next to the manifest", gc01)
+	}
+	if gc02.Name != "growth-chamber-2" || gc02.Conditions != "/srv/conditions/gc02.xlsx" {
+		t.Errorf("gc02 is %+v", gc02)
+	}
+	if opts := gc01.RunOptions(); !opts.LoopFirstDay || opts.Tick != time.Minute*5 || opts.Retry != (RetryPolicy{}) {
+		t.Errorf("gc01 runs with %+v", opts)
+	}
+	if opts := gc02.RunOptions(); opts.Retry != DefaultRetryPolicy() || opts.Reload != nil {
+		t.Errorf("gc02 runs with %+v", opts)
+	}
+	if gc02.Fixture != "led" || gc02.Limits != "conviron" || gc01.Fixture != "" || gc01.Limits != "" {
+		t.Errorf("gc01 has fixture %q and limits %q, gc02 has %q and %q, want them only on gc02",
+			gc01.Fixture, gc01.Limits, gc02.Fixture, gc02.Limits)
+	}
+
+	if _, err := LoadManifest(writeFile(t, "chambers.yaml", "gc01:\n  driver: psi\n")); err == nil {
+		t.Error("loaded a chamber without conditions")
+	}
+}
+
+// TestChamberConfigRunOptions checks the options of each manifest key, in a json manifest
+func TestChamberConfigRunOptions(t *testing.T) {
+	manifestPath := writeFile(t, "chambers.json", `{
+	"defaults": {"conditions": "a.csv", "driver": "log"},
+	"loop": {"conditions": "a.csv", "driver": "log", "loop": true, "loop_period_hours": 1.5},
+	"tick": {"conditions": "a.csv", "driver": "log", "tick_minutes": 0.5},
+	"retried": {"conditions": "a.csv", "driver": "log", "backoff": true},
+	"reloaded": {"conditions": "a.csv", "driver": "log", "reload": true},
+	"options": {"conditions": "a.csv", "driver": "log", "options": {"address": "10.0.0.2:23"}}
+}`)
+	chambers, err := LoadManifest(manifestPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]RunOptions{
+		"defaults": {},
+		"loop":     {LoopFirstDay: true, LoopPeriod: time.Minute * 90},
+		"tick":     {Tick: time.Second * 30},
+		"retried":  {Retry: DefaultRetryPolicy()},
+		"reloaded": {Reload: &Reload{}},
+		"options":  {},
+	}
+	for name, want := range tests {
+		got := chambers[name].RunOptions()
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s runs with %+v, want %+v", name, got, want)
+		}
+	}
+	if address := chambers["options"].Options["address"]; address != "10.0.0.2:23" || chambers["loop"].Options != nil {
+		t.Errorf("options are %v and %v, want the address only on the chamber that has it",
+			chambers["options"].Options, chambers["loop"].Options)
+	}
+
+	invalid := map[string]string{
+		"conditions.yaml": "gc01:\n  driver: psi\n",
+		"driver.yaml":     "gc01:\n  conditions: gc01.csv\n",
+		"period.yaml":     "gc01:\n  conditions: gc01.csv\n  driver: psi\n  loop_period_hours: -1\n",
+		"tick.yaml":       "gc01:\n  conditions: gc01.csv\n  driver: psi\n  tick_minutes: -1\n",
+		"unknown.yaml":    "gc01:\n  conditions: gc01.csv\n  driver: psi\n  loop_hours: 24\n",
+		"unknown.json":    `{"gc01": {"conditions": "gc01.csv", "driver": "psi", "loop_hours": 24}}`,
+	}
+	for name, contents := range invalid {
+		if _, err := LoadManifest(writeFile(t, name, contents)); err == nil {
+			t.Errorf("loaded %s", name)
+		}
+	}
+}
+
+func TestRestartDelay(t *testing.T) {
+	opts := SupervisorOptions{RestartDelay: time.Second, MaxRestartDelay: time.Second * 5}
+	// indexed by the number of failures in a row
+	delays := []time.Duration{time.Second, time.Second, time.Second * 2, time.Second * 4, time.Second * 5}
+	for failures, want := range delays {
+		if got := opts.restartDelay(failures); got != want {
+			t.Errorf("restartDelay(%d) = %v, want %v", failures, got, want)
+		}
+	}
+	if got := (SupervisorOptions{}).restartDelay(1); got != time.Second*10 {
+		t.Errorf("default restartDelay(1) = %v, want 10s", got)
+	}
+}
+
+// TestSupervisor runs three chambers from 13:00 on the day of testConditions: one that finishes, one whose driver
+// panics when it is first read, and one that can't be connected to
+func TestSupervisor(t *testing.T) {
+	conditionsPath := writeFile(t, "conditions.csv", testConditions)
+	s, err := LoadSchedule(discardLog, conditionsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := NewFakeClock(s.Start().Add(time.Hour * 13))
+
+	var mu sync.Mutex
+	connections := make(map[string]int)
+	drivers := map[string]DriverFactory{
+		"fake": func(errLog *log.Logger, c ChamberConfig, schedule *Schedule) (Driver, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			connections[c.Name]++
+			driver := &fakeDriver{capabilities: Capabilities{Temperature: true}}
+			if c.Name == "panics" && connections[c.Name] == 1 {
+				return panicDriver{driver, make(chan struct{})}, nil
+			}
+			return driver, nil
+		},
+		"unreachable": func(errLog *log.Logger, c ChamberConfig, schedule *Schedule) (Driver, error) {
+			return nil, errors.New("connection refused")
+		},
+	}
+	chambers := map[string]ChamberConfig{
+		"finishes":    {Conditions: conditionsPath, Driver: "fake"},
+		"panics":      {Conditions: conditionsPath, Driver: "fake"},
+		"unreachable": {Conditions: conditionsPath, Driver: "unreachable"},
+	}
+	supervisor, err := NewSupervisor(discardLog, chambers, drivers, SupervisorOptions{
+		Clock:        clock,
+		MaxRestarts:  2,
+		ReadInterval: time.Minute * 30,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = drive(clock, func() error {
+		return supervisor.Run(context.Background())
+	})
+	if err == nil || !strings.Contains(err.Error(), "1 of 3 chambers failed: unreachable: ") {
+		t.Errorf("Run returned %v, want it to name the chamber that failed", err)
+	}
+
+	statuses := supervisor.Status()
+	if len(statuses) != 3 {
+		t.Fatalf("%d statuses, want 3", len(statuses))
+	}
+	finishes, panics, unreachable := statuses[0], statuses[1], statuses[2]
+	if finishes.State != ChamberFinished || finishes.Restarts != 0 || !finishes.LastTimePoint.Equal(s.End()) {
+		t.Errorf("finishes is %s", finishes)
+	}
+	if panics.State != ChamberFinished || panics.Restarts != 1 ||
+		!strings.Contains(panics.Error, "panic reading driver: lost the serial port") {
+		t.Errorf("panics is %s, want it restarted after the panic and finished", panics)
+	}
+	if unreachable.State != ChamberFailed || unreachable.Restarts != 2 ||
+		!strings.Contains(unreachable.Error, "connection refused") {
+		t.Errorf("unreachable is %s, want it given up on after 3 failures", unreachable)
+	}
+	if n := clock.Waiters(); n != 0 {
+		t.Errorf("%d waiters left on the clock", n)
+	}
+}
+
+// TestSupervisorLoadOptions runs spectrumConditions from 11:00 on a chamber with a fixture profile, and on one with
+// limits that it is outside of
+func TestSupervisorLoadOptions(t *testing.T) {
+	conditionsPath := writeFile(t, "conditions.csv", spectrumConditions)
+	clock := NewFakeClock(time.Date(2020, 1, 1, 11, 0, 0, 0, time.Local))
+	var mu sync.Mutex
+	drivers := make(map[string]*fakeDriver)
+	factories := map[string]DriverFactory{
+		"fake": func(errLog *log.Logger, c ChamberConfig, schedule *Schedule) (Driver, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			drivers[c.Name] = &fakeDriver{capabilities: Capabilities{Temperature: true, Channels: 1}}
+			return drivers[c.Name], nil
+		},
+	}
+	chambers := map[string]ChamberConfig{
+		"led":  {Conditions: conditionsPath, Driver: "fake", Fixture: "led"},
+		"cool": {Conditions: conditionsPath, Driver: "fake", Fixture: "led", Limits: "cool"},
+	}
+	opts := SupervisorOptions{
+		Clock:       clock,
+		MaxRestarts: 1,
+		Fixtures:    map[string]fixture.Profile{"led": *ledProfile()},
+		Limits:      map[string]LimitProfile{"cool": {Name: "cool", Temperature: between(0, 22)}},
+	}
+	supervisor, err := NewSupervisor(discardLog, chambers, factories, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = drive(clock, func() error {
+		return supervisor.Run(context.Background())
+	})
+	if err == nil || !strings.Contains(err.Error(), "1 of 2 chambers failed: cool: ") ||
+		!strings.Contains(err.Error(), "outside the cool limits") {
+
+		t.Errorf("Run returned %v, want the chamber outside its limits to fail", err)
+	}
+	if _, ok := drivers["cool"]; ok {
+		t.Error("connected to the chamber whose conditions are outside its limits")
+	}
+	led := drivers["led"]
+	if len(led.applied) != 2 || led.applied[1].Channels[0] != NewNullFloat64(50) {
+		t.Errorf("led was sent %v, want the spectrum solved with its fixture profile", led.applied)
+	}
+
+	for name, c := range map[string]ChamberConfig{
+		"unknown fixture": {Conditions: conditionsPath, Driver: "fake", Fixture: "psi"},
+		"unknown limits":  {Conditions: conditionsPath, Driver: "fake", Limits: "warm"},
+	} {
+		if _, err := NewSupervisor(discardLog, map[string]ChamberConfig{"gc01": c}, factories, opts); err == nil {
+			t.Errorf("%s: made a supervisor for a chamber with an %s profile", name, name)
+		}
+	}
+	opts.Limits = nil
+	c := ChamberConfig{Conditions: conditionsPath, Driver: "fake", Limits: "conviron"}
+	if _, err := NewSupervisor(discardLog, map[string]ChamberConfig{"gc01": c}, factories, opts); err != nil {
+		t.Errorf("couldn't use the default limit profiles: %v", err)
+	}
+}
+
+func TestSupervisorStopped(t *testing.T) {
+	conditionsPath := writeFile(t, "conditions.csv", testConditions)
+	clock := NewFakeClock(time.Date(2020, 1, 1, 13, 0, 0, 0, time.Local))
+	stop := errors.New("shutting down")
+	ctx, cancel := context.WithCancelCause(context.Background())
+	drivers := map[string]DriverFactory{
+		"fake": func(errLog *log.Logger, c ChamberConfig, schedule *Schedule) (Driver, error) {
+			return &fakeDriver{capabilities: Capabilities{Temperature: true}}, nil
+		},
+	}
+	chambers := map[string]ChamberConfig{"gc01": {Conditions: conditionsPath, Driver: "fake"}}
+	supervisor, err := NewSupervisor(discardLog, chambers, drivers, SupervisorOptions{Clock: clock})
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- supervisor.Run(ctx)
+	}()
+	clock.BlockUntil(1)
+	cancel(stop)
+	if err := <-done; errors.Cause(err) != stop {
+		t.Errorf("Run returned %v, want the cause of the cancellation", err)
+	}
+	if status := supervisor.Status()[0]; status.State != ChamberStopped || status.LastTimePoint.IsZero() {
+		t.Errorf("gc01 is %s, want it stopped after it applied the active timepoint", status)
+	}
+
+	chambers = map[string]ChamberConfig{"gc02": {Conditions: conditionsPath, Driver: "psi"}}
+	if _, err := NewSupervisor(discardLog, chambers, drivers, SupervisorOptions{}); err == nil {
+		t.Error("made a supervisor for a chamber with an unknown driver")
+	}
+}
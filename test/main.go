@@ -1,11 +1,18 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
 	"github.com/appf-anu/chamber-tools"
+	"github.com/pkg/errors"
 	"log"
+	"math/rand"
 	"os"
+	"os/signal"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 )
 
@@ -19,13 +26,83 @@ var (
 	address                           string
 	conditionsPath, hostTag, groupTag string
 	interval                          time.Duration
+	fakeDuration                      time.Duration
+	loopPeriod                        time.Duration
+	simulate                          bool
+	simStart                          string
+	failRate                          float64
+	backoff                           bool
+	readInterval                      time.Duration
+	targets                           string
+	reloadInterval                    time.Duration
+	clock                             = chamber_tools.RealClock
 )
 
-// runStuff, should send values and write metrics.
-// returns true if program should continue, false if program should retry
-func runStuff(point *chamber_tools.TimePoint) bool {
-	errLog.Printf("%+v\n", point.NulledString())
-	return true
+// logDriver is a chamber_tools.Driver that logs the timepoints it is sent, and reads them back as its conditions
+type logDriver struct {
+	errLog *log.Logger
+	// channels is the number of channels the driver has
+	channels int
+	mu       sync.Mutex
+	last     chamber_tools.TimePoint
+}
+
+func newLogDriver(errLog *log.Logger, channels int) *logDriver {
+	return &logDriver{errLog: errLog, channels: channels}
+}
+
+// Apply, should send values and write metrics.
+// returns an error if the timepoint should be retried
+func (d *logDriver) Apply(ctx context.Context, point chamber_tools.TimePoint) error {
+	if rand.Float64() < failRate {
+		return errors.New("pretending the chamber is unreachable")
+	}
+	if simulate {
+		d.errLog.Printf("real %v simulated %v", point.Datetime.Format(time.RFC3339),
+			point.SimDatetime.Format(time.RFC3339))
+	}
+	d.errLog.Printf("%v %+v\n", clock.Now().Format(time.RFC3339), point.NulledString())
+	if values, ok := point.Derived(); ok {
+		d.errLog.Printf("derived %+v\n", values)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.last = point
+	return nil
+}
+
+// Read returns the targets of the last timepoint that was applied
+func (d *logDriver) Read(ctx context.Context) (chamber_tools.Reading, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return chamber_tools.Reading{
+		Time:             clock.Now(),
+		Temperature:      d.last.Temperature,
+		RelativeHumidity: d.last.RelativeHumidity,
+		CO2:              d.last.CO2,
+		Light1:           d.last.Light1,
+		Light2:           d.last.Light2,
+		Channels:         d.last.Channels,
+	}, nil
+}
+
+// Capabilities returns every target, and the channels of the driver
+func (d *logDriver) Capabilities() chamber_tools.Capabilities {
+	return chamber_tools.Capabilities{
+		Temperature:      true,
+		RelativeHumidity: true,
+		CO2:              true,
+		TotalSolar:       true,
+		Light1:           true,
+		Light2:           true,
+		Channels:         d.channels,
+	}
+}
+
+// Close does nothing, there is no device
+func (d *logDriver) Close() error {
+	d.errLog.Println("closing driver")
+	return nil
 }
 
 func init() {
@@ -42,6 +119,8 @@ func init() {
 		}
 	}
 
+	flag.DurationVar(&loopPeriod, "period", time.Hour*24, "length of the loop")
+
 	flag.StringVar(&conditionsPath, "conditions", "", "conditions file to")
 	if tempV := os.Getenv("CONDITIONS_FILE"); tempV != "" {
 		conditionsPath = tempV
@@ -54,17 +133,28 @@ func init() {
 			errLog.Println(err)
 		}
 	}
+	flag.BoolVar(&simulate, "simulate", false, "run timepoints at their datetime-sim, starting now")
+	flag.StringVar(&simStart, "sim-start", "",
+		"datetime-sim (RFC3339) to start the simulation from, defaults to the first datetime-sim")
+	flag.DurationVar(&fakeDuration, "fake", 0,
+		"run the conditions on a fake clock for this long instead of waiting in real time")
+	flag.Float64Var(&failRate, "fail-rate", 0, "fraction of timepoints that fail to run, to test retries")
+	flag.BoolVar(&backoff, "backoff", false, "retry failed timepoints with the default retry policy")
+	flag.DurationVar(&readInterval, "read", 0, "read the driver this often and log the readings")
+	flag.StringVar(&targets, "targets", "",
+		"run on several drivers, eg. \"chamber=temperature,humidity;lights=channel-1..7\"")
+	flag.DurationVar(&reloadInterval, "reload", 0, "check the conditions file for changes this often and swap them in")
 	flag.Parse()
 
 	if conditionsPath != "" {
-		chamber_tools.InitIndexConfig(errLog, conditionsPath)
-		if chamber_tools.IndexConfig.TemperatureIdx == -1 || chamber_tools.IndexConfig.HumidityIdx == -1 {
+		indices := chamber_tools.InitIndexConfig(errLog, conditionsPath)
+		if indices.TemperatureIdx == -1 || indices.HumidityIdx == -1 {
 			errLog.Println("No temperature or humidity headers found in conditions file")
 		}
 	}
-	errLog.Printf("loopFirstDay: \t%s\n", loopFirstDay)
-	errLog.Printf("light1: \t%s\n", useLight1)
-	errLog.Printf("light2: \t%s\n", useLight2)
+	errLog.Printf("loopFirstDay: \t%v\n", loopFirstDay)
+	errLog.Printf("light1: \t%v\n", useLight1)
+	errLog.Printf("light2: \t%v\n", useLight2)
 	errLog.Printf("timezone: \t%s\n", chamber_tools.ZoneName)
 	errLog.Printf("hostTag: \t%s\n", hostTag)
 	errLog.Printf("groupTag: \t%s\n", groupTag)
@@ -74,10 +164,122 @@ func init() {
 
 }
 
+// parseTargets parses the -targets flag into a logDriver for each name=mapping
+func parseTargets(s string) ([]chamber_tools.Target, error) {
+	var parsed []chamber_tools.Target
+	for _, target := range strings.Split(s, ";") {
+		name, mapping, ok := strings.Cut(target, "=")
+		if !ok {
+			return nil, errors.Errorf("target %q is not name=mapping", target)
+		}
+		m, err := chamber_tools.ParseMapping(mapping)
+		if err != nil {
+			return nil, errors.Wrapf(err, "couldn't parse mapping of target %s", name)
+		}
+		targetErrLog := log.New(errLog.Writer(), fmt.Sprintf("%s[%s] ", errLog.Prefix(), name), errLog.Flags())
+		t := chamber_tools.Target{Name: name, Driver: newLogDriver(targetErrLog, len(m.Channels)), Mapping: &m}
+		if backoff {
+			t.Retry = chamber_tools.DefaultRetryPolicy()
+		}
+		parsed = append(parsed, t)
+	}
+	return parsed, nil
+}
+
+// runFake replaces the clock with a fake one that skips straight to each timepoint, and returns a context that is
+// cancelled once d of fake time has passed. the clock only advances once waiters goroutines are waiting on it, that is
+// the runner, the watcher of a reloaded conditions file and the readers of the drivers.
+func runFake(ctx context.Context, d time.Duration, waiters int) context.Context {
+	fakeClock := chamber_tools.NewFakeClock(time.Now())
+	clock = fakeClock
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		defer cancel()
+		end := fakeClock.Now().Add(d)
+		for fakeClock.Now().Before(end) {
+			fakeClock.BlockUntil(waiters)
+			fakeClock.AdvanceToNext()
+		}
+	}()
+	return ctx
+}
+
 func main() {
 
 	if conditionsPath != "" {
-		chamber_tools.RunConditions(errLog, runStuff, conditionsPath, loopFirstDay)
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		if fakeDuration > 0 {
+			waiters := 1
+			if readInterval > 0 && targets != "" {
+				waiters += len(strings.Split(targets, ";"))
+			} else if readInterval > 0 {
+				waiters++
+			}
+			if reloadInterval > 0 {
+				waiters++
+			}
+			ctx = runFake(ctx, fakeDuration, waiters)
+		}
+		opts := chamber_tools.DriverOptions{
+			RunOptions: chamber_tools.RunOptions{
+				LoopFirstDay: loopFirstDay,
+				LoopPeriod:   loopPeriod,
+				Clock:        clock,
+				OnAbandon: func(err *chamber_tools.AbandonError) {
+					errLog.Printf("gave up on TimePoint at %v", err.TimePoint.Datetime)
+				},
+			},
+			ReadInterval: readInterval,
+			OnReading: func(r chamber_tools.Reading) {
+				errLog.Printf("read %+v", r)
+			},
+		}
+		if backoff {
+			opts.Retry = chamber_tools.DefaultRetryPolicy()
+		}
+		if reloadInterval > 0 {
+			opts.Reload = &chamber_tools.Reload{PollInterval: reloadInterval}
+		}
+		var err error
+		if simulate {
+			opts.Simulation = &chamber_tools.Simulation{}
+			if simStart != "" {
+				t, err := time.Parse(time.RFC3339, simStart)
+				if err != nil {
+					errLog.Fatalf("couldn't parse sim-start: %v", err)
+				}
+				opts.Simulation.SimStart = t
+			}
+		}
+		if targets != "" {
+			fanOutTargets, parseErr := parseTargets(targets)
+			if parseErr != nil {
+				errLog.Fatal(parseErr)
+			}
+			fanOutOpts := chamber_tools.FanOutOptions{
+				RunOptions:   opts.RunOptions,
+				ReadInterval: readInterval,
+				OnResult: func(result chamber_tools.FanOutResult) {
+					for _, r := range result.Results {
+						errLog.Printf("%s: %d attempts, error: %v", r.Name, r.Attempts, r.Err)
+					}
+				},
+				OnReading: func(name string, r chamber_tools.Reading) {
+					errLog.Printf("read %s %+v", name, r)
+				},
+			}
+			err = chamber_tools.RunConditionsFanOut(ctx, errLog, fanOutTargets, conditionsPath, fanOutOpts)
+		} else {
+			var schedule *chamber_tools.Schedule
+			if schedule, err = chamber_tools.LoadSchedule(errLog, conditionsPath); err == nil {
+				driver := newLogDriver(errLog, len(schedule.Indices.ChannelsIdx))
+				err = schedule.RunDriver(ctx, errLog, driver, opts)
+			}
+		}
+		if err != nil {
+			errLog.Println(err)
+		}
 	}
 
 }
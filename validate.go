@@ -0,0 +1,244 @@
+package chamber_tools
+
+import (
+	"fmt"
+	"log"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Severity is how bad an Issue is
+type Severity int
+
+const (
+	// SeverityWarning issues don't stop a conditions file from running as intended
+	SeverityWarning Severity = iota
+	// SeverityError issues mean that rows or columns of a conditions file are ignored or run in the wrong order
+	SeverityError
+)
+
+func (s Severity) String() string {
+	if s == SeverityError {
+		return "error"
+	}
+	return "warning"
+}
+
+// Issue is a problem found in a conditions file.
+// Row and Column are numbered from 1 like in a spreadsheet, they are 0 if the issue is not about a row or column.
+type Issue struct {
+	Severity Severity
+	Row      int
+	Column   int
+	Message  string
+}
+
+func (i Issue) String() string {
+	switch {
+	case i.Row > 0 && i.Column > 0:
+		return fmt.Sprintf("%s: row %d, column %d: %s", i.Severity, i.Row, i.Column, i.Message)
+	case i.Row > 0:
+		return fmt.Sprintf("%s: row %d: %s", i.Severity, i.Row, i.Message)
+	case i.Column > 0:
+		return fmt.Sprintf("%s: column %d: %s", i.Severity, i.Column, i.Message)
+	}
+	return fmt.Sprintf("%s: %s", i.Severity, i.Message)
+}
+
+// Issues are the problems found in a conditions file
+type Issues []Issue
+
+// Errors returns the number of issues that are errors
+func (issues Issues) Errors() int {
+	n := 0
+	for _, i := range issues {
+		if i.Severity == SeverityError {
+			n++
+		}
+	}
+	return n
+}
+
+// ValidateOptions control how a conditions file is validated
+type ValidateOptions struct {
+	LoadOptions
+	// Required are the headers that must be present as well as datetime
+	Required []string
+}
+
+// knownHeader returns true if a header is read by Indices
+func knownHeader(name string) bool {
+	t := reflect.TypeOf(Indices{})
+	for i := 0; i < t.NumField(); i++ {
+		header, ok := t.Field(i).Tag.Lookup("header")
+		if !ok {
+			continue
+		}
+		if header == name {
+			return true
+		}
+		var n int
+		if strings.Contains(header, "%d") {
+			if _, err := fmt.Sscanf(name, header, &n); err == nil && fmt.Sprintf(header, n) == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// validateHeaders checks the header line of a conditions file
+func validateHeaders(headers []string, required []string) Issues {
+	var issues Issues
+	add := func(severity Severity, column int, format string, args ...interface{}) {
+		issues = append(issues, Issue{Severity: severity, Column: column, Message: fmt.Sprintf(format, args...)})
+	}
+
+	seen := make(map[string]int)
+	channels := make(map[int]int)
+	for i, h := range headers {
+		name, annotation := splitHeaderAnnotation(trimField(h))
+		if name == "" {
+			continue
+		}
+		if annotation != "" {
+			if _, err := ParseInterpolation(annotation); err != nil {
+				add(SeverityError, i+1, "header %q: %v", h, err)
+			}
+		}
+		if first, ok := seen[name]; ok {
+			add(SeverityError, i+1, "duplicate header %q, only column %d is used", name, first+1)
+			continue
+		}
+		seen[name] = i
+		var n int
+		if _, err := fmt.Sscanf(name, "channel-%d", &n); err == nil && fmt.Sprintf("channel-%d", n) == name {
+			channels[n] = i
+		}
+		if !knownHeader(name) {
+			add(SeverityWarning, i+1, "unknown header %q is ignored", name)
+		}
+	}
+
+	_, hasHumidity := seen["humidity"]
+	if vpd, ok := seen["vpd"]; ok && hasHumidity {
+		add(SeverityError, vpd+1, "humidity and vpd both set the humidity target, rows can only have one of them")
+	}
+	if spectrum, ok := seen["spectrum"]; ok && len(channels) > 0 {
+		add(SeverityError, spectrum+1, "channels and spectrum both set the channel targets, rows can only have one of them")
+	}
+
+	for _, name := range append([]string{"datetime"}, required...) {
+		if _, ok := seen[name]; !ok {
+			add(SeverityError, 0, "missing required header %q", name)
+		}
+	}
+
+	// channels are read from channel-1 until the first missing channel
+	numbers := make([]int, 0, len(channels))
+	for n := range channels {
+		numbers = append(numbers, n)
+	}
+	sort.Ints(numbers)
+	expected := 1
+	for _, n := range numbers {
+		if n != expected {
+			add(SeverityError, channels[n]+1,
+				"channel-%d has no channel-%d before it, it and the channels after it are ignored", n, expected)
+			break
+		}
+		expected++
+	}
+	return issues
+}
+
+// cellIssues returns an issue for each bad cell of a row that couldn't be decoded
+func cellIssues(row int, err error) Issues {
+	var cellErrs CellErrors
+	switch err := err.(type) {
+	case *CellError:
+		cellErrs = CellErrors{err}
+	case CellErrors:
+		cellErrs = err
+	default:
+		return Issues{{Severity: SeverityError, Row: row, Message: err.Error()}}
+	}
+	issues := make(Issues, len(cellErrs))
+	for i, cellErr := range cellErrs {
+		issues[i] = Issue{Severity: SeverityError, Row: row, Column: cellErr.Column,
+			Message: fmt.Sprintf("%s: %v", cellErr.Header, cellErr.Err)}
+	}
+	return issues
+}
+
+// Validate checks a conditions file for problems that would stop it from running as intended, without running it.
+// the returned error is only set if the file can't be read at all.
+func Validate(errLog *log.Logger, conditionsPath string, opts ValidateOptions) (Issues, error) {
+	_, issues, err := validate(errLog, conditionsPath, opts)
+	return issues, err
+}
+
+// validate reads a conditions file once, returning the schedule loaded from it along with its issues. the schedule
+// is nil if the file has no datetime header.
+func validate(errLog *log.Logger, conditionsPath string, opts ValidateOptions) (*Schedule, Issues, error) {
+	var issues Issues
+	var previous *TimePoint
+	var previousRow int
+	var rows []int
+	s := newFileSchedule(conditionsPath)
+	headers, err := s.readRows(errLog, opts.LoadOptions, func(row int, tp *TimePoint, err error) {
+		if err != nil {
+			issues = append(issues, cellIssues(row, err)...)
+			return
+		}
+		s.TimePoints = append(s.TimePoints, *tp)
+		rows = append(rows, row)
+		if previous != nil {
+			switch {
+			case tp.Datetime.Equal(previous.Datetime):
+				issues = append(issues, Issue{Severity: SeverityError, Row: row, Column: s.Indices.DatetimeIdx + 1,
+					Message: fmt.Sprintf("duplicate datetime %v, the same as row %d", tp.Datetime, previousRow)})
+			case tp.Datetime.Before(previous.Datetime):
+				issues = append(issues, Issue{Severity: SeverityError, Row: row, Column: s.Indices.DatetimeIdx + 1,
+					Message: fmt.Sprintf("datetime %v is out of order, it is before row %d (%v)",
+						tp.Datetime, previousRow, previous.Datetime)})
+			}
+		}
+		previous, previousRow = tp, row
+	})
+	if headers == nil {
+		return nil, nil, err
+	}
+	issues = append(validateHeaders(headers, opts.Required), issues...)
+	if err != nil {
+		// the header line was read but it has no datetime header, which is one of the issues
+		return nil, issues, nil
+	}
+
+	if len(s.TimePoints) == 0 {
+		issues = append(issues, Issue{Severity: SeverityError, Message: "no timepoints"})
+	}
+	if opts.Limits != nil {
+		// checked before the timepoints are sorted so that their indices are the same as rows
+		for _, v := range opts.Limits.Check(s.TimePoints) {
+			column := s.Indices.Column(v.Header)
+			switch {
+			case v.Header == "humidity" && column < 0:
+				// the humidity came from the vpd column
+				column = s.Indices.VPDIdx
+			case strings.HasPrefix(v.Header, "channel") && column < 0:
+				// the channels were solved from the spectrum column
+				column = s.Indices.SpectrumIdx
+			}
+			issues = append(issues, Issue{
+				Severity: SeverityError,
+				Row:      rows[v.Index],
+				Column:   column + 1,
+				Message:  fmt.Sprintf("%s %s for %s", v.Header, v.Message, opts.Limits.Name),
+			})
+		}
+	}
+	s.finishLoading(opts.LoadOptions)
+	return s, issues, nil
+}
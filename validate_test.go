@@ -0,0 +1,158 @@
+package chamber_tools
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		contents string
+		opts     ValidateOptions
+		// want are the issues that must be found, as "severity row column message substring"
+		want []Issue
+	}{
+		{
+			name: "valid",
+			contents: `datetime,temperature,humidity,channel-1,channel-2
+2020-01-01 00:00,20,55,0,0
+2020-01-01 06:00,25,NULL,100,50
+`,
+		},
+		{
+			name: "unparseable date",
+			contents: `datetime,temperature
+2020-01-01 00:00,20
+yesterday,25
+`,
+			want: []Issue{{SeverityError, 3, 1, "datetime"}},
+		},
+		{
+			name: "out of order and duplicate datetimes",
+			contents: `datetime,temperature
+2020-01-01 06:00,20
+2020-01-01 03:00,25
+2020-01-01 03:00,26
+`,
+			want: []Issue{{SeverityError, 3, 1, "out of order"}, {SeverityError, 4, 1, "duplicate datetime"}},
+		},
+		{
+			name: "non-numeric cell",
+			contents: `datetime,temperature,light1
+2020-01-01 00:00,warm,1
+2020-01-01 06:00,20,2.5
+2020-01-01 12:00,20,2
+`,
+			want: []Issue{{SeverityError, 2, 2, "temperature"}, {SeverityError, 3, 3, "light1"}},
+		},
+		{
+			name: "several bad cells in a row",
+			contents: `datetime,temperature,humidity,channel-1,channel-2
+yesterday,warm,55,bright,50
+2020-01-01 06:00,20,damp,100,50
+`,
+			want: []Issue{
+				{SeverityError, 2, 1, "datetime"},
+				{SeverityError, 2, 2, "temperature"},
+				{SeverityError, 2, 4, "channel-1"},
+				{SeverityError, 3, 3, "humidity"},
+				{SeverityError, 0, 0, "no timepoints"},
+			},
+		},
+		{
+			name: "partial numbers",
+			contents: `datetime,temperature,humidity,light1
+2020-01-01 00:00,2o.5,55 %RH,1
+2020-01-01 06:00,-3 °C,55%,2x
+2020-01-01 12:00,-3 °C,55 RH,2.0
+`,
+			want: []Issue{
+				{SeverityError, 2, 2, `"2o.5" is not a number`},
+				{SeverityError, 3, 4, `"2x" is not a number`},
+			},
+		},
+		{
+			name: "missing required header",
+			contents: `datetime,temperature
+2020-01-01 00:00,20
+`,
+			opts: ValidateOptions{Required: []string{"humidity"}},
+			want: []Issue{{SeverityError, 0, 0, `missing required header "humidity"`}},
+		},
+		{
+			name: "missing datetime header",
+			contents: `time,temperature
+2020-01-01 00:00,20
+`,
+			want: []Issue{
+				{SeverityWarning, 0, 1, `unknown header "time"`},
+				{SeverityError, 0, 0, `missing required header "datetime"`},
+			},
+		},
+		{
+			name: "channel gap",
+			contents: `datetime,channel-1,channel-3
+2020-01-01 00:00,10,30
+`,
+			want: []Issue{{SeverityError, 0, 3, "channel-3 has no channel-2"}},
+		},
+		{
+			name: "duplicate and unknown headers",
+			contents: `datetime,temperature,temperature,colour
+2020-01-01 00:00,20,21,red
+`,
+			want: []Issue{{SeverityError, 0, 3, "duplicate header"}, {SeverityWarning, 0, 4, "unknown header"}},
+		},
+		{
+			name: "bad interpolation annotation",
+			contents: `datetime,temperature:cubic
+2020-01-01 00:00,20
+`,
+			want: []Issue{{SeverityError, 0, 2, "unknown interpolation"}},
+		},
+		{
+			name: "no timepoints",
+			contents: `datetime,temperature
+`,
+			want: []Issue{{SeverityError, 0, 0, "no timepoints"}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := writeFile(t, "conditions.csv", test.contents)
+			issues, err := Validate(discardLog, p, test.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(issues) != len(test.want) {
+				t.Fatalf("found %d issues, want %d: %v", len(issues), len(test.want), issues)
+			}
+			for i, want := range test.want {
+				got := issues[i]
+				if got.Severity != want.Severity || got.Row != want.Row || got.Column != want.Column ||
+					!strings.Contains(got.Message, want.Message) {
+					t.Errorf("issue %d is %q, want %s at row %d, column %d containing %q",
+						i, got, want.Severity, want.Row, want.Column, want.Message)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateUnreadableFile(t *testing.T) {
+	if _, err := Validate(discardLog, writeFile(t, "conditions.txt", "datetime\n"), ValidateOptions{}); err == nil {
+		t.Error("validated an unsupported file type")
+	}
+}
+
+func TestIssuesErrors(t *testing.T) {
+	issues := Issues{{Severity: SeverityWarning}, {Severity: SeverityError}, {Severity: SeverityError}}
+	if n := issues.Errors(); n != 2 {
+		t.Errorf("Errors() = %d, want 2", n)
+	}
+	issue := Issue{Severity: SeverityError, Row: 3, Column: 2, Message: "bad"}
+	if s := issue.String(); s != "error: row 3, column 2: bad" {
+		t.Errorf("String() = %q", s)
+	}
+}
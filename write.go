@@ -0,0 +1,233 @@
+package chamber_tools
+
+import (
+	"encoding/csv"
+	"fmt"
+	"github.com/appf-anu/chamber-tools/derived"
+	"github.com/pkg/errors"
+	"github.com/tealeg/xlsx"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// csvDatetimeLayout is how datetimes are written to csv files, the same as python's isoformat
+const csvDatetimeLayout = "2006-01-02T15:04:05"
+
+// NewSchedule returns a schedule of timepoints with the columns in headers, eg. a generated program to write to a
+// file. timepoints must be ordered by Datetime.
+func NewSchedule(errLog *log.Logger, headers []string, timepoints []TimePoint) *Schedule {
+	indices := getIndices(errLog, headers)
+	return &Schedule{
+		Indices:       indices,
+		Location:      time.Local,
+		Interpolation: DefaultInterpolationPolicy().Merge(indices.Interpolation),
+		TimePoints:    timepoints,
+	}
+}
+
+// Headers returns the header line of the columns in this layout, ordered by column. columns that aren't read by
+// Indices have empty headers. headers with interpolation declared in the header line keep their annotation.
+// a spectrum column that was solved for a Fixture is replaced by the channel columns it was solved into.
+func (indices Indices) Headers() []string {
+	return indices.writeLayout().headers()
+}
+
+// writeLayout returns the layout a schedule is written in, which replaces a spectrum column that was solved for a
+// Fixture with a channel column for each channel of the fixture, as the spectrum isn't kept in the timepoints.
+// the channel columns keep the interpolation declared for the spectrum column.
+func (indices Indices) writeLayout() Indices {
+	if indices.SpectrumIdx < 0 || indices.Fixture == nil || len(indices.ChannelsIdx) > 0 {
+		return indices
+	}
+	headers := indices.headers()
+	idx := indices.SpectrumIdx
+	_, annotation := splitHeaderAnnotation(headers[idx])
+	spliced := append([]string{}, headers[:idx]...)
+	for i := range indices.Fixture.Channels {
+		header := fmt.Sprintf("channel-%d", i+1)
+		if annotation != "" {
+			header += ":" + annotation
+		}
+		spliced = append(spliced, header)
+	}
+	spliced = append(spliced, headers[idx+1:]...)
+	layout := getIndices(log.New(io.Discard, "", 0), spliced)
+	layout.Fixture = indices.Fixture
+	return layout
+}
+
+// headers returns the header line of the columns in this layout as they are read
+func (indices Indices) headers() []string {
+	var headers []string
+	set := func(idx int, header string) {
+		if idx < 0 {
+			return
+		}
+		for len(headers) <= idx {
+			headers = append(headers, "")
+		}
+		if i, ok := indices.Interpolation[header]; ok {
+			header = fmt.Sprintf("%s:%s", header, i)
+		}
+		headers[idx] = header
+	}
+	set(indices.DatetimeIdx, "datetime")
+	set(indices.SimDatetimeIdx, "datetime-sim")
+	set(indices.TemperatureIdx, "temperature")
+	set(indices.HumidityIdx, "humidity")
+	set(indices.Light1Idx, "light1")
+	set(indices.Light2Idx, "light2")
+	set(indices.CO2Idx, "co2")
+	set(indices.TotalSolarIdx, "totalsolar")
+	set(indices.VPDIdx, "vpd")
+	set(indices.SpectrumIdx, "spectrum")
+	for i, idx := range indices.ChannelsIdx {
+		set(idx, fmt.Sprintf("channel-%d", i+1))
+	}
+	return headers
+}
+
+// cells returns the value of each column of a timepoint in this layout. datetimes are time.Time, unset values and
+// columns that aren't read by Indices are nil.
+func (indices Indices) cells(tp TimePoint) []interface{} {
+	indices = indices.writeLayout()
+	cells := make([]interface{}, len(indices.headers()))
+	set := func(idx int, v interface{}) {
+		if idx >= 0 {
+			cells[idx] = v
+		}
+	}
+	float := func(v NullFloat64) interface{} {
+		if !v.Valid {
+			return nil
+		}
+		return v.Float64
+	}
+	integer := func(v NullInt) interface{} {
+		if !v.Valid {
+			return nil
+		}
+		return v.Int
+	}
+	set(indices.DatetimeIdx, tp.Datetime)
+	if !tp.SimDatetime.IsZero() {
+		set(indices.SimDatetimeIdx, tp.SimDatetime)
+	}
+	set(indices.TemperatureIdx, float(tp.Temperature))
+	set(indices.HumidityIdx, float(tp.RelativeHumidity))
+	set(indices.Light1Idx, integer(tp.Light1))
+	set(indices.Light2Idx, integer(tp.Light2))
+	set(indices.CO2Idx, float(tp.CO2))
+	set(indices.TotalSolarIdx, float(tp.TotalSolar))
+	// humidity is written as vpd if the layout has a vpd column and no humidity column
+	if tp.Temperature.Valid && tp.RelativeHumidity.Valid && indices.HumidityIdx < 0 {
+		set(indices.VPDIdx, derived.VPD(tp.Temperature.Float64, tp.RelativeHumidity.Float64))
+	}
+	for i, idx := range indices.ChannelsIdx {
+		if i < len(tp.Channels) {
+			set(idx, float(tp.Channels[i]))
+		}
+	}
+	return cells
+}
+
+// location returns the timezone the schedule's datetimes are written in
+func (s *Schedule) location() *time.Location {
+	if s.Location == nil {
+		return time.Local
+	}
+	return s.Location
+}
+
+// WriteCSV writes the schedule as a csv conditions file. datetimes are written without a timezone in the schedule's
+// Location, as they are read in the local timezone. unset values are written as empty cells.
+func (s *Schedule) WriteCSV(w io.Writer, delimiter rune) error {
+	writer := csv.NewWriter(w)
+	if delimiter != 0 {
+		writer.Comma = delimiter
+	}
+	if err := writer.Write(s.Indices.Headers()); err != nil {
+		return err
+	}
+	for _, tp := range s.TimePoints {
+		cells := s.Indices.cells(tp)
+		record := make([]string, len(cells))
+		for i, cell := range cells {
+			switch v := cell.(type) {
+			case time.Time:
+				record[i] = v.In(s.location()).Format(csvDatetimeLayout)
+			case float64:
+				record[i] = NewNullFloat64(v).String()
+			case int:
+				record[i] = NewNullInt(v).String()
+			}
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// WriteXLSX writes the schedule to the "timepoints" sheet of an xlsx conditions file. datetimes are written as
+// spreadsheet dates in the schedule's Location, unset values are written as empty cells.
+func (s *Schedule) WriteXLSX(w io.Writer) error {
+	file := xlsx.NewFile()
+	sheet, err := file.AddSheet("timepoints")
+	if err != nil {
+		return err
+	}
+	dateOptions := xlsx.DateTimeOptions{
+		Location:        s.location(),
+		ExcelTimeFormat: xlsx.DefaultDateTimeFormat,
+	}
+
+	row := sheet.AddRow()
+	for _, header := range s.Indices.Headers() {
+		row.AddCell().SetString(header)
+	}
+	for _, tp := range s.TimePoints {
+		row := sheet.AddRow()
+		for _, cell := range s.Indices.cells(tp) {
+			c := row.AddCell()
+			switch v := cell.(type) {
+			case time.Time:
+				c.SetDateWithOptions(v, dateOptions)
+			case float64:
+				c.SetFloat(v)
+			case int:
+				c.SetInt(v)
+			}
+		}
+	}
+	return file.Write(w)
+}
+
+// WriteFile writes the schedule to a .csv or .xlsx conditions file, csv files are comma separated
+func (s *Schedule) WriteFile(conditionsPath string) error {
+	var write func(io.Writer) error
+	switch filepath.Ext(conditionsPath) {
+	case ".xlsx":
+		write = s.WriteXLSX
+	case ".csv":
+		write = func(w io.Writer) error {
+			return s.WriteCSV(w, ',')
+		}
+	default:
+		return errors.Errorf("unsupported conditions file type %q", filepath.Ext(conditionsPath))
+	}
+
+	f, err := os.Create(conditionsPath)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return errors.Wrapf(err, "writing %s", conditionsPath)
+	}
+	return f.Close()
+}
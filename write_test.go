@@ -0,0 +1,73 @@
+package chamber_tools
+
+import (
+	"github.com/appf-anu/chamber-tools/derived"
+	"math"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestWriteVPD reads a conditions file with a vpd column, and checks that it is written and read back as vpd
+func TestWriteVPD(t *testing.T) {
+	conditionsPath := writeFile(t, "conditions.csv", `datetime,temperature,vpd
+2020-01-01 00:00,25,1.2
+2020-01-01 06:00,20,0.5
+2020-01-01 12:00,20,2.338
+2020-01-01 18:00,20,
+`)
+	s, err := LoadSchedule(discardLog, conditionsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Len() != 4 {
+		t.Fatalf("read %d timepoints, want 4", s.Len())
+	}
+	for i, vpd := range []float64{1.2, 0.5, 2.338} {
+		tp := s.TimePoints[i]
+		want, _ := derived.RelativeHumidityFromVPD(tp.Temperature.Float64, vpd)
+		if !tp.RelativeHumidity.Valid || math.Abs(tp.RelativeHumidity.Float64-want) > 1e-9 {
+			t.Errorf("TimePoint %d humidity is %v, want %v from the vpd", i, tp.RelativeHumidity, want)
+		}
+	}
+	if s.TimePoints[3].RelativeHumidity.Valid {
+		t.Errorf("TimePoint 3 humidity is %v, want it unset without a vpd", s.TimePoints[3].RelativeHumidity)
+	}
+
+	for _, ext := range []string{".csv", ".xlsx"} {
+		written := filepath.Join(t.TempDir(), "written"+ext)
+		if err := s.WriteFile(written); err != nil {
+			t.Fatal(err)
+		}
+		headers, err := readHeaders(written, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(headers, ",") != "datetime,temperature,vpd" {
+			t.Errorf("%s headers are %v, want the vpd column kept", ext, headers)
+		}
+		read, err := LoadSchedule(discardLog, written)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if read.Len() != s.Len() {
+			t.Fatalf("%s: read %d timepoints, wrote %d", ext, read.Len(), s.Len())
+		}
+		for i, tp := range s.TimePoints {
+			got := read.TimePoints[i].RelativeHumidity
+			if got.Valid != tp.RelativeHumidity.Valid || math.Abs(got.Float64-tp.RelativeHumidity.Float64) > 1e-6 {
+				t.Errorf("%s: TimePoint %d humidity is %v, wrote %v", ext, i, got, tp.RelativeHumidity)
+			}
+		}
+	}
+}
+
+// TestWriteVPDOfDryAir checks that a humidity of 0, which has no dew point, is still written as a vpd
+func TestWriteVPDOfDryAir(t *testing.T) {
+	tp := TimePoint{Datetime: at(0), Temperature: NewNullFloat64(25), RelativeHumidity: NewNullFloat64(0)}
+	s := NewSchedule(discardLog, []string{"datetime", "temperature", "vpd"}, []TimePoint{tp})
+	cells := s.Indices.cells(tp)
+	if vpd, ok := cells[2].(float64); !ok || vpd != derived.SaturationVapourPressure(25) {
+		t.Errorf("vpd cell is %v, want the saturation vapour pressure", cells[2])
+	}
+}